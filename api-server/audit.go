@@ -0,0 +1,50 @@
+package main
+
+import (
+	"draglog_api/utils"
+	"fmt"
+	"os"
+
+	"github.com/danielgtaylor/huma/v2/humacli"
+	"github.com/spf13/cobra"
+)
+
+// addAuditCommands adds the command checking the audit log of --audit-log
+func addAuditCommands(cli humacli.CLI) {
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Manage the audit log of --audit-log",
+	}
+
+	var start utils.AuditStart
+	var pruned bool
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the hash chain of the audit log and its rotated files",
+		Long: "Check the hash chain of the audit log and its rotated files, with the --audit-log-key the server used. " +
+			"An edited, removed or reordered entry, a missing rotated file or a log truncated before its last " +
+			"recorded entry fails the check. Once the oldest rotated files are deleted, start the chain with " +
+			"--from-seq and --prev-hash, the entry after the last one and the last hash of an earlier check, or " +
+			"with --pruned to trust the first retained entry.",
+		Args: cobra.NoArgs,
+		Run: humacli.WithOptions(func(cmd *cobra.Command, args []string, options *Options) {
+			var from *utils.AuditStart
+			if pruned || cmd.Flags().Changed("from-seq") || cmd.Flags().Changed("prev-hash") {
+				from = &start
+			}
+			result, err := utils.VerifyAuditLog(options.AuditLog, []byte(options.AuditLogKey), from)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s: %d entries in %d files, entries %d to %d, last hash %s\n",
+				options.AuditLog, result.Entries, result.Files, result.FirstSeq, result.LastSeq, result.LastHash)
+		}),
+	}
+	verifyCmd.Flags().Uint64Var(&start.Seq, "from-seq", 0, "Sequence number of the oldest retained entry")
+	verifyCmd.Flags().StringVar(&start.PrevHash, "prev-hash", "", "Hash of the entry before the oldest retained one")
+	verifyCmd.Flags().BoolVar(&pruned, "pruned", false, "Start from the first retained entry, whatever its sequence number")
+
+	auditCmd.AddCommand(verifyCmd)
+	cli.Root().AddCommand(auditCmd)
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+
+	"draglog_api/draglog"
+
+	"github.com/spf13/cobra"
+)
+
+// commandContext is cancelled on Ctrl-C, so a stream or a long listing stops cleanly
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(cmd.Context(), os.Interrupt)
+}
+
+func newConfigCommand(g *globals) *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the profiles of the config file",
+	}
+
+	var profile Profile
+	setCmd := &cobra.Command{
+		Use:   "set-profile <name>",
+		Short: "Create or update a profile, the first one becomes the current profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadConfig(g.configPath)
+			if err != nil {
+				return err
+			}
+			existing := config.Profiles[args[0]]
+			if cmd.Flags().Changed("server") {
+				existing.Server = profile.Server
+			}
+			if cmd.Flags().Changed("api-key") {
+				existing.APIKey = profile.APIKey
+			}
+			if cmd.Flags().Changed("token") {
+				existing.Token = profile.Token
+			}
+			config.Profiles[args[0]] = existing
+			if config.Current == "" {
+				config.Current = args[0]
+			}
+			if err := config.save(g.configPath); err != nil {
+				return err
+			}
+			fmt.Printf("Saved profile %s to %s\n", args[0], g.configPath)
+			return nil
+		},
+	}
+	// the profile values shadow the global flags of the same names
+	setCmd.Flags().StringVar(&profile.Server, "server", "", "URL of the API server")
+	setCmd.Flags().StringVar(&profile.APIKey, "api-key", "", "API key")
+	setCmd.Flags().StringVar(&profile.Token, "token", "", "JWT bearer token")
+
+	useCmd := &cobra.Command{
+		Use:   "use <name>",
+		Short: "Select the profile used by default",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadConfig(g.configPath)
+			if err != nil {
+				return err
+			}
+			if _, ok := config.Profiles[args[0]]; !ok {
+				return fmt.Errorf("unknown profile %s", args[0])
+			}
+			config.Current = args[0]
+			return config.save(g.configPath)
+		},
+	}
+
+	viewCmd := &cobra.Command{
+		Use:   "view",
+		Short: "Show the profiles, without their credentials",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadConfig(g.configPath)
+			if err != nil {
+				return err
+			}
+			type profileView struct {
+				Name     string `json:"name"`
+				Current  bool   `json:"current"`
+				Server   string `json:"server"`
+				Identity string `json:"identity"`
+			}
+			var views []profileView
+			for _, name := range slices.Sorted(maps.Keys(config.Profiles)) {
+				profile := config.Profiles[name]
+				identity := "none"
+				if profile.APIKey != "" {
+					identity = "api key"
+				} else if profile.Token != "" {
+					identity = "token"
+				}
+				views = append(views, profileView{Name: name, Current: name == config.Current, Server: profile.Server, Identity: identity})
+			}
+			return g.printValue(views, func(w io.Writer) {
+				fmt.Fprintln(w, "CURRENT\tNAME\tSERVER\tIDENTITY")
+				for _, view := range views {
+					current := ""
+					if view.Current {
+						current = "*"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", current, view.Name, view.Server, view.Identity)
+				}
+			})
+		},
+	}
+
+	configCmd.AddCommand(setCmd, useCmd, viewCmd)
+	return configCmd
+}
+
+// addRecordFlags adds the flags setting the fields of a record to create
+func addRecordFlags(cmd *cobra.Command, record *draglog.LogRecord, file *string) {
+	cmd.Flags().StringVarP(file, "file", "f", "", "JSON file holding the record, - for stdin, the flags override its fields")
+	cmd.Flags().StringVar(&record.LogID, "id", "", "Log ID")
+	cmd.Flags().StringVar(&record.LoggerID, "logger", "", "ID of the component writing the record")
+	cmd.Flags().StringVar(&record.Input, "input", "", "Input of the component")
+	cmd.Flags().StringVar(&record.InputFrom, "input-from", "", "Component the input comes from")
+	cmd.Flags().StringVar(&record.Output, "output-value", "", "Output of the component")
+	cmd.Flags().StringVar(&record.OutputTo, "output-to", "", "Component the output goes to")
+	cmd.Flags().StringVar(&record.Timestamp, "timestamp", "", "Timestamp of the record")
+	cmd.Flags().StringVar(&record.Reserved, "reserved", "", "Reserved value")
+}
+
+// readRecordInput returns the record to create from the file, with the fields set by the flags on top of it
+func readRecordInput(cmd *cobra.Command, flags draglog.LogRecord, file string) (draglog.LogRecord, error) {
+	var record draglog.LogRecord
+	if file != "" {
+		if err := readJSONFile(file, &record); err != nil {
+			return record, err
+		}
+	}
+	for flag, value := range map[string]struct {
+		target *string
+		value  string
+	}{
+		"id":           {&record.LogID, flags.LogID},
+		"logger":       {&record.LoggerID, flags.LoggerID},
+		"input":        {&record.Input, flags.Input},
+		"input-from":   {&record.InputFrom, flags.InputFrom},
+		"output-value": {&record.Output, flags.Output},
+		"output-to":    {&record.OutputTo, flags.OutputTo},
+		"timestamp":    {&record.Timestamp, flags.Timestamp},
+		"reserved":     {&record.Reserved, flags.Reserved},
+	} {
+		if cmd.Flags().Changed(flag) {
+			*value.target = value.value
+		}
+	}
+	if record.LogID == "" {
+		return record, fmt.Errorf("the record needs a log ID, set --id or logID in the file")
+	}
+	return record, nil
+}
+
+// readJSONFile decodes a JSON file, - is stdin
+func readJSONFile(path string, value any) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// newRecordCommand returns the logs or feedback command
+func newRecordCommand(g *globals, recordType string) *cobra.Command {
+	name, noun := "logs", "log"
+	if recordType == draglog.TypeFeedback {
+		name, noun = "feedback", "feedback"
+	}
+	recordCmd := &cobra.Command{
+		Use:   name,
+		Short: fmt.Sprintf("Create and read %s records", noun),
+	}
+
+	var filter draglog.RecordFilter
+	var page draglog.Page
+	var all bool
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: fmt.Sprintf("List the %s records matching the filters", noun),
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+
+			list := &draglog.RecordList{}
+			if all {
+				records := client.Logs
+				if recordType == draglog.TypeFeedback {
+					records = client.Feedback
+				}
+				for record, err := range records(ctx, filter) {
+					if err != nil {
+						return err
+					}
+					list.Records = append(list.Records, record)
+				}
+			} else {
+				listPage := client.ListLogs
+				if recordType == draglog.TypeFeedback {
+					listPage = client.ListFeedback
+				}
+				if list, err = listPage(ctx, filter, page); err != nil {
+					return err
+				}
+			}
+			return g.printList(list)
+		},
+	}
+	listCmd.Flags().StringVar(&filter.LoggerID, "logger", "", "Only records written by this logger")
+	listCmd.Flags().StringVar(&filter.InputFrom, "input-from", "", "Only records whose input comes from this component")
+	listCmd.Flags().StringVar(&filter.OutputTo, "output-to", "", "Only records whose output goes to this component")
+	listCmd.Flags().StringVar(&filter.Since, "since", "", "Only records with a timestamp at or after this one")
+	listCmd.Flags().StringVar(&filter.Until, "until", "", "Only records with a timestamp before this one")
+	listCmd.Flags().IntVar(&page.Limit, "limit", 0, "Maximum number of records, the server default if 0")
+	listCmd.Flags().StringVar(&page.Bookmark, "bookmark", "", "Bookmark of the page, as returned by the previous page")
+	listCmd.Flags().BoolVar(&all, "all", false, "List every matching record, page after page")
+
+	getCmd := &cobra.Command{
+		Use:   "get <log ID>",
+		Short: fmt.Sprintf("Show a %s record", noun),
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			get := client.GetLog
+			if recordType == draglog.TypeFeedback {
+				get = client.GetFeedback
+			}
+			record, err := get(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return g.printRecords(record, []draglog.LogRecord{*record})
+		},
+	}
+
+	var record draglog.LogRecord
+	var file string
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: fmt.Sprintf("Create a %s record", noun),
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			input, err := readRecordInput(cmd, record, file)
+			if err != nil {
+				return err
+			}
+			create := client.CreateLog
+			if recordType == draglog.TypeFeedback {
+				create = client.CreateFeedback
+			}
+			if err := create(cmd.Context(), input); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Created %s record %s\n", noun, input.LogID)
+			return nil
+		},
+	}
+	addRecordFlags(createCmd, &record, &file)
+
+	recordCmd.AddCommand(listCmd, getCmd, createCmd)
+	return recordCmd
+}
+
+func newHistoryCommand(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "history <record ID>",
+		Short: "Show the changes of a record, oldest first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			history, err := client.History(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return g.printValue(history, func(w io.Writer) {
+				fmt.Fprintln(w, "TIMESTAMP\tTX ID\tDELETED\tSCORE\tRESERVED")
+				for _, entry := range history {
+					score, reserved := "", ""
+					if entry.Record != nil {
+						score = strconv.FormatFloat(float64(entry.Record.ReliabilityScore), 'g', -1, 32)
+						reserved = truncate(entry.Record.Reserved)
+					}
+					fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", entry.Timestamp, entry.TxID, entry.IsDelete, score, reserved)
+				}
+			})
+		},
+	}
+}
+
+func newQueryCommand(g *globals) *cobra.Command {
+	var selector, bookmark string
+	var sort, fields []string
+	var limit int
+	var all bool
+	queryCmd := &cobra.Command{
+		Use:   "query",
+		Short: "Run a rich query over the records",
+		Example: `  draglogctl query --selector '{"type": "reliability", "reliabilityScore": {"$lt": 50}}' --sort reliabilityScore:asc
+  draglogctl query --selector '{"loggerID": "reranker0"}' --fields logID,timestamp --all -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			request := draglog.QueryRequest{Fields: fields, Limit: limit, Bookmark: bookmark}
+			if err := json.Unmarshal([]byte(selector), &request.Selector); err != nil {
+				return fmt.Errorf("failed to parse the selector: %w", err)
+			}
+			for _, field := range sort {
+				name, direction, _ := strings.Cut(field, ":")
+				if direction == "" {
+					direction = "asc"
+				}
+				request.Sort = append(request.Sort, map[string]string{name: direction})
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+
+			page := &draglog.QueryPage{}
+			if all {
+				for record, err := range client.QueryAll(ctx, request) {
+					if err != nil {
+						return err
+					}
+					page.Records = append(page.Records, record)
+				}
+				page.Count = len(page.Records)
+			} else if page, err = client.Query(ctx, request); err != nil {
+				return err
+			}
+
+			columns := fields
+			if len(columns) == 0 {
+				columns = []string{"logID", "loggerID", "type", "outputTo", "reliabilityScore", "timestamp"}
+			}
+			return g.printValue(page, func(w io.Writer) {
+				fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+				for _, record := range page.Records {
+					values := make([]string, len(columns))
+					for i, column := range columns {
+						values[i] = truncate(fmt.Sprint(record[column]))
+					}
+					fmt.Fprintln(w, strings.Join(values, "\t"))
+				}
+				if page.Bookmark != "" {
+					fmt.Fprintf(os.Stderr, "More results with --bookmark %s\n", page.Bookmark)
+				}
+			})
+		},
+	}
+	queryCmd.Flags().StringVar(&selector, "selector", "", "CouchDB selector over the record fields, as JSON")
+	queryCmd.Flags().StringSliceVar(&sort, "sort", nil, "Fields to sort by, as field:asc or field:desc")
+	queryCmd.Flags().StringSliceVar(&fields, "fields", nil, "Fields to return, every field if empty")
+	queryCmd.Flags().IntVar(&limit, "limit", 0, "Maximum number of records per page, the server default if 0")
+	queryCmd.Flags().StringVar(&bookmark, "bookmark", "", "Bookmark of the page to return")
+	queryCmd.Flags().BoolVar(&all, "all", false, "Return every result, page after page")
+	queryCmd.MarkFlagRequired("selector")
+	return queryCmd
+}
+
+func newLineageCommand(g *globals) *cobra.Command {
+	var direction, graph string
+	var depth int
+	lineageCmd := &cobra.Command{
+		Use:   "lineage <record ID or digest>",
+		Short: "Show the provenance graph of a record or output digest",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			options := draglog.LineageOptions{Direction: direction}
+			if cmd.Flags().Changed("depth") {
+				options.Depth = &depth
+			}
+
+			if graph != "" {
+				data, err := client.LineageGraph(cmd.Context(), args[0], graph, options)
+				if err != nil {
+					return err
+				}
+				_, err = os.Stdout.Write(data)
+				return err
+			}
+
+			lineage, err := client.Lineage(cmd.Context(), args[0], options)
+			if err != nil {
+				return err
+			}
+			return g.printValue(lineage, func(w io.Writer) {
+				fmt.Fprintln(w, "DEPTH\tLOG ID\tLOGGER\tTYPE\tOUTPUT TO")
+				for _, node := range lineage.Nodes {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", node.Depth, node.Record.LogID, node.Record.LoggerID, node.Record.Type, node.Record.OutputTo)
+				}
+				if lineage.Truncated {
+					fmt.Fprintln(os.Stderr, "The graph was truncated, lower --depth")
+				}
+			})
+		},
+	}
+	lineageCmd.Flags().StringVar(&direction, "direction", "", "Follow the edges upstream, downstream or both, the server default if empty")
+	lineageCmd.Flags().IntVar(&depth, "depth", 5, "Maximum number of edges from the start")
+	lineageCmd.Flags().StringVar(&graph, "graph", "", "Render the graph as mermaid, dot or graphml instead")
+	return lineageCmd
+}
+
+func newTailCommand(g *globals) *cobra.Command {
+	var startBlock uint64
+	tailCmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Follow the chaincode events, such as the reliability score changes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+
+			var start *uint64
+			if cmd.Flags().Changed("start-block") {
+				start = &startBlock
+			}
+			for event, err := range client.Events(ctx, start) {
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return err
+				}
+				if g.output != "table" {
+					if err := g.printValue(event, nil); err != nil {
+						return err
+					}
+					continue
+				}
+				fmt.Printf("%d\t%s\t%s\t%s\n", event.BlockNumber, event.TransactionID, event.EventName, event.Payload)
+			}
+			return nil
+		},
+	}
+	tailCmd.Flags().Uint64Var(&startBlock, "start-block", 0, "Replay the events from this block, only the new events if unset")
+	return tailCmd
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a server and the credentials to call it
+type Profile struct {
+	Server string `yaml:"server"`
+	APIKey string `yaml:"apiKey,omitempty"`
+	Token  string `yaml:"token,omitempty"`
+}
+
+// Config is the draglogctl config file, holding the profiles and the one used by default
+type Config struct {
+	Current  string             `yaml:"current,omitempty"`
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+// defaultConfigPath is the config file in the user config directory, such as ~/.config/draglogctl/config.yaml
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "draglogctl.yaml"
+	}
+	return filepath.Join(dir, "draglogctl", "config.yaml")
+}
+
+// loadConfig reads the config file, a missing file is an empty config
+func loadConfig(path string) (*Config, error) {
+	config := &Config{Profiles: map[string]Profile{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]Profile{}
+	}
+	return config, nil
+}
+
+// save writes the config file, readable by the user only since it holds credentials
+func (c *Config) save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// profile returns the named profile, or the current one when name is empty
+func (c *Config) profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.Current
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %s", name)
+	}
+	return profile, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"draglog_api/draglog"
+
+	"github.com/spf13/cobra"
+)
+
+// Export is the content of the ledger written by export and read by import
+type Export struct {
+	Logs     []draglog.LogRecord `json:"logs"`
+	Feedback []draglog.LogRecord `json:"feedback"`
+	Sources  []draglog.LogRecord `json:"sources"`
+}
+
+func newExportCommand(g *globals) *cobra.Command {
+	var file string
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export every log, feedback and reliability record as JSON",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+
+			export := Export{Logs: []draglog.LogRecord{}, Feedback: []draglog.LogRecord{}, Sources: []draglog.LogRecord{}}
+			for record, err := range client.Logs(ctx, draglog.RecordFilter{}) {
+				if err != nil {
+					return err
+				}
+				export.Logs = append(export.Logs, record)
+			}
+			for record, err := range client.Feedback(ctx, draglog.RecordFilter{}) {
+				if err != nil {
+					return err
+				}
+				export.Feedback = append(export.Feedback, record)
+			}
+			for record, err := range client.Sources(ctx, draglog.ScoreFilter{}) {
+				if err != nil {
+					return err
+				}
+				export.Sources = append(export.Sources, record)
+			}
+
+			data, err := json.MarshalIndent(export, "", "  ")
+			if err != nil {
+				return err
+			}
+			if file == "" || file == "-" {
+				_, err = os.Stdout.Write(append(data, '\n'))
+				return err
+			}
+			if err := os.WriteFile(file, append(data, '\n'), 0644); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Exported %d logs, %d feedback and %d sources to %s\n", len(export.Logs), len(export.Feedback), len(export.Sources), file)
+			return nil
+		},
+	}
+	exportCmd.Flags().StringVarP(&file, "file", "f", "", "File to write, stdout if empty")
+	return exportCmd
+}
+
+func newImportCommand(g *globals) *cobra.Command {
+	var batchSize int
+	importCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import the records of an export, admins only",
+		Long:  "Import the records of an export as they are, scores included, in batches of one transaction each. Records that already exist are skipped.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if batchSize < 1 {
+				return fmt.Errorf("the batch size must be positive")
+			}
+			var export Export
+			if err := readJSONFile(args[0], &export); err != nil {
+				return err
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+
+			// the data sources first, so their scores exist before the logs referencing them
+			records := append(append(append([]draglog.LogRecord{}, export.Sources...), export.Logs...), export.Feedback...)
+			for start := 0; start < len(records); start += batchSize {
+				batch := records[start:min(start+batchSize, len(records))]
+				if err := client.CreateSourcesBatch(ctx, batch); err != nil {
+					return fmt.Errorf("failed to import records %d to %d, the previous ones were imported: %w", start, start+len(batch)-1, err)
+				}
+				fmt.Fprintf(os.Stderr, "Imported %d/%d records\n", start+len(batch), len(records))
+			}
+			return nil
+		},
+	}
+	importCmd.Flags().IntVar(&batchSize, "batch-size", 100, "Number of records written per transaction")
+	return importCmd
+}
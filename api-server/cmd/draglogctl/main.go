@@ -0,0 +1,105 @@
+// Command draglogctl calls the DRagLog API server from the command line.
+//
+// The server and credentials come from a profile of the config file, overridden by the flags:
+//
+//	draglogctl config set-profile dev --server http://localhost:8080 --api-key change-me-admin
+//	draglogctl logs list --logger datasource0 -o yaml
+//	draglogctl sources seed sources.yaml
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"draglog_api/draglog"
+
+	"github.com/spf13/cobra"
+)
+
+// globals are the flags shared by every command
+type globals struct {
+	configPath string
+	profile    string
+	server     string
+	apiKey     string
+	token      string
+	output     string
+}
+
+// client returns the API client of the selected profile, with the flags applied on top of it
+func (g *globals) client() (*draglog.Client, error) {
+	config, err := loadConfig(g.configPath)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := config.profile(g.profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.server != "" {
+		profile.Server = g.server
+	}
+	if g.apiKey != "" {
+		profile.APIKey = g.apiKey
+	}
+	if g.token != "" {
+		profile.Token = g.token
+	}
+	if profile.Server == "" {
+		profile.Server = "http://localhost:8080"
+	}
+
+	var options []draglog.Option
+	if profile.APIKey != "" {
+		options = append(options, draglog.WithAPIKey(profile.APIKey))
+	} else if profile.Token != "" {
+		options = append(options, draglog.WithToken(profile.Token))
+	}
+	return draglog.New(profile.Server, options...)
+}
+
+func newRootCommand() *cobra.Command {
+	g := &globals{}
+	root := &cobra.Command{
+		Use:           "draglogctl",
+		Short:         "Manage the records of a DRagLog ledger through the API server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			switch g.output {
+			case "table", "json", "yaml":
+				return nil
+			}
+			return fmt.Errorf("unknown output format %s, expected table, json or yaml", g.output)
+		},
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&g.configPath, "config", defaultConfigPath(), "Path to the config file holding the profiles")
+	flags.StringVar(&g.profile, "profile", "", "Profile to use, the current one if empty")
+	flags.StringVar(&g.server, "server", "", "URL of the API server, overrides the profile")
+	flags.StringVar(&g.apiKey, "api-key", "", "API key, overrides the profile")
+	flags.StringVar(&g.token, "token", "", "JWT bearer token, overrides the profile")
+	flags.StringVarP(&g.output, "output", "o", "table", "Output format: table, json or yaml")
+
+	root.AddCommand(
+		newConfigCommand(g),
+		newRecordCommand(g, draglog.TypeLog),
+		newRecordCommand(g, draglog.TypeFeedback),
+		newSourcesCommand(g),
+		newHistoryCommand(g),
+		newQueryCommand(g),
+		newLineageCommand(g),
+		newTailCommand(g),
+		newExportCommand(g),
+		newImportCommand(g),
+	)
+	return root
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"draglog_api/draglog"
+
+	"gopkg.in/yaml.v3"
+)
+
+// printValue writes the value in the selected format, table writes the rows of the table format
+func (g *globals) printValue(value any, table func(w io.Writer)) error {
+	switch g.output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "yaml":
+		// go through JSON so the YAML keys are the JSON field names
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		var generic any
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(generic)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// printRecords writes records, with one row per record in the table format
+func (g *globals) printRecords(value any, records []draglog.LogRecord) error {
+	return g.printValue(value, func(w io.Writer) {
+		fmt.Fprintln(w, "LOG ID\tLOGGER\tTYPE\tINPUT FROM\tOUTPUT TO\tSCORE\tTIMESTAMP")
+		for _, record := range records {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%g\t%s\n", record.LogID, record.LoggerID, record.Type, truncate(record.InputFrom), record.OutputTo, record.ReliabilityScore, record.Timestamp)
+		}
+	})
+}
+
+// printList writes a page of records, with the bookmark of the next page on stderr in the table format
+func (g *globals) printList(list *draglog.RecordList) error {
+	if err := g.printRecords(list, list.Records); err != nil {
+		return err
+	}
+	if g.output == "table" && list.Bookmark != "" {
+		fmt.Fprintf(os.Stderr, "More results with --bookmark %s\n", list.Bookmark)
+	}
+	return nil
+}
+
+// truncate shortens the long values shown in a table cell
+func truncate(value string) string {
+	if runes := []rune(value); len(runes) > 40 {
+		return string(runes[:39]) + "…"
+	}
+	return value
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+
+	"draglog_api/draglog"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+func newSourcesCommand(g *globals) *cobra.Command {
+	sourcesCmd := &cobra.Command{
+		Use:   "sources",
+		Short: "Manage the data sources and their reliability scores",
+	}
+
+	var minScore, maxScore float32
+	var page draglog.Page
+	var all bool
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the reliability records of the data sources",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+
+			var filter draglog.ScoreFilter
+			if cmd.Flags().Changed("min-score") {
+				filter.MinScore = &minScore
+			}
+			if cmd.Flags().Changed("max-score") {
+				filter.MaxScore = &maxScore
+			}
+
+			list := &draglog.RecordList{}
+			if all {
+				for record, err := range client.Sources(ctx, filter) {
+					if err != nil {
+						return err
+					}
+					list.Records = append(list.Records, record)
+				}
+			} else if list, err = client.ListSources(ctx, filter, page); err != nil {
+				return err
+			}
+			return g.printList(list)
+		},
+	}
+	listCmd.Flags().Float32Var(&minScore, "min-score", 0, "Only data sources with at least this score")
+	listCmd.Flags().Float32Var(&maxScore, "max-score", 0, "Only data sources with at most this score")
+	listCmd.Flags().IntVar(&page.Limit, "limit", 0, "Maximum number of records, the server default if 0")
+	listCmd.Flags().StringVar(&page.Bookmark, "bookmark", "", "Bookmark of the page, as returned by the previous page")
+	listCmd.Flags().BoolVar(&all, "all", false, "List every matching record, page after page")
+
+	getCmd := &cobra.Command{
+		Use:   "get <data source ID>",
+		Short: "Show the reliability record of a data source",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			record, err := client.GetSource(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return g.printRecords(record, []draglog.LogRecord{*record})
+		},
+	}
+
+	var input draglog.ReliabilityRecordInput
+	var async bool
+	createCmd := &cobra.Command{
+		Use:   "create <data source ID>",
+		Short: "Create the reliability record of a data source, with a score of 100",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			input.DataSourceID = args[0]
+			create := client.CreateSource
+			if async {
+				create = client.CreateSourceAsync
+			}
+			if err := create(cmd.Context(), input); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Created data source %s\n", args[0])
+			return nil
+		},
+	}
+	createCmd.Flags().StringVar(&input.Digest, "digest", "", "Digest of the data source")
+	createCmd.Flags().StringVar(&input.Reserved, "reserved", "", "Reserved value")
+	createCmd.Flags().BoolVar(&async, "async", false, "Return once the transaction is submitted, without waiting for the commit")
+
+	scoresCmd := &cobra.Command{
+		Use:   "scores <data source ID>...",
+		Short: "Show the reliability scores of data sources",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			scores, err := client.GetScores(cmd.Context(), args)
+			if err != nil {
+				return err
+			}
+			return g.printValue(scores, func(w io.Writer) {
+				fmt.Fprintln(w, "DATA SOURCE\tSTATUS\tSCORE")
+				for _, id := range args {
+					score := scores[id]
+					value := ""
+					if score.ReliabilityScore != nil {
+						value = strconv.FormatFloat(float64(*score.ReliabilityScore), 'g', -1, 32)
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\n", id, score.Status, value)
+				}
+			})
+		},
+	}
+
+	var info, ifMatch string
+	setScoreCmd := &cobra.Command{
+		Use:   "set-score <data source ID> <score>",
+		Short: "Set the absolute reliability score of a data source, admins only",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			score, err := strconv.ParseFloat(args[1], 32)
+			if err != nil {
+				return fmt.Errorf("invalid score %s", args[1])
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			return client.SetScoreIfMatch(cmd.Context(), args[0], float32(score), info, ifMatch)
+		},
+	}
+	setScoreCmd.Flags().StringVar(&info, "info", "", "Reason of the change")
+	setScoreCmd.Flags().StringVar(&ifMatch, "if-match", "", "Only set the score if its ETag still matches")
+
+	addDeltaCmd := &cobra.Command{
+		Use:   "add-delta <data source ID> <delta>",
+		Short: "Add a delta to the reliability score of a data source, negative to lower it",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			delta, err := strconv.ParseFloat(args[1], 32)
+			if err != nil {
+				return fmt.Errorf("invalid delta %s", args[1])
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			return client.AddScoreDelta(cmd.Context(), args[0], float32(delta), info)
+		},
+	}
+	addDeltaCmd.Flags().StringVar(&info, "info", "", "Reason of the change")
+
+	var seedAsync bool
+	seedCmd := &cobra.Command{
+		Use:   "seed <file>",
+		Short: "Create the data sources listed in a YAML or JSON file, skipping the existing ones",
+		Long: `Create the data sources listed in a YAML or JSON file, such as
+
+  - dataSourceID: wiki
+    digest: 3f2a...
+  - dataSourceID: news
+    digest: 9bc1...`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			var sources []struct {
+				DataSourceID string `yaml:"dataSourceID"`
+				Digest       string `yaml:"digest"`
+				Reserved     string `yaml:"reserved"`
+			}
+			if err := yaml.Unmarshal(data, &sources); err != nil {
+				return fmt.Errorf("failed to parse %s: %w", args[0], err)
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+
+			// the existing data sources are skipped, so a seed can be run again after a failure
+			var ids []string
+			for _, source := range sources {
+				ids = append(ids, source.DataSourceID)
+			}
+			var known []string
+			for start := 0; start < len(ids); start += 1000 {
+				scores, err := client.GetScores(ctx, ids[start:min(start+1000, len(ids))])
+				if err != nil {
+					return err
+				}
+				for id, score := range scores {
+					if score.Status == "known" {
+						known = append(known, id)
+					}
+				}
+			}
+
+			created, failed := 0, 0
+			for _, source := range sources {
+				if slices.Contains(known, source.DataSourceID) {
+					fmt.Fprintf(os.Stderr, "%s: exists\n", source.DataSourceID)
+					continue
+				}
+				create := client.CreateSource
+				if seedAsync {
+					create = client.CreateSourceAsync
+				}
+				if err := create(ctx, draglog.ReliabilityRecordInput{DataSourceID: source.DataSourceID, Digest: source.Digest, Reserved: source.Reserved}); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", source.DataSourceID, err)
+					failed++
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "%s: created\n", source.DataSourceID)
+				created++
+			}
+			fmt.Fprintf(os.Stderr, "Created %d data sources, %d existed, %d failed\n", created, len(known), failed)
+			if failed > 0 {
+				return fmt.Errorf("%d data sources were not created", failed)
+			}
+			return nil
+		},
+	}
+	seedCmd.Flags().BoolVar(&seedAsync, "async", false, "Do not wait for the commit of each data source")
+
+	sourcesCmd.AddCommand(listCmd, getCmd, createCmd, scoresCmd, setScoreCmd, addDeltaCmd, seedCmd)
+	return sourcesCmd
+}
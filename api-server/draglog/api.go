@@ -0,0 +1,397 @@
+package draglog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// listPageSize is the page size of the list iterators, the maximum the server accepts
+const listPageSize = 1000
+
+type noCacheKey struct{}
+
+// NoCache returns a context whose reads bypass the score cache of the server
+func NoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// readHeader returns the headers of a read, asking the server to bypass its cache if the context says so
+func readHeader(ctx context.Context) http.Header {
+	header := make(http.Header)
+	if noCache, _ := ctx.Value(noCacheKey{}).(bool); noCache {
+		header.Set("Cache-Control", "no-cache")
+	}
+	return header
+}
+
+// Health returns the state of the server and its gateway peers
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	if _, err := c.do(ctx, http.MethodGet, "/healthz", nil, nil, nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// InitLedger creates the initial records of the ledger
+func (c *Client) InitLedger(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, "/init-ledger", nil, nil, nil, nil)
+	return err
+}
+
+// CreateLog writes a log record
+func (c *Client) CreateLog(ctx context.Context, record LogRecord) error {
+	record.Type = TypeLog
+	_, err := c.do(ctx, http.MethodPost, "/v1/logs", nil, nil, record, nil)
+	return err
+}
+
+// CreateFeedback writes a feedback record
+func (c *Client) CreateFeedback(ctx context.Context, record LogRecord) error {
+	record.Type = TypeFeedback
+	_, err := c.do(ctx, http.MethodPost, "/v1/feedback", nil, nil, record, nil)
+	return err
+}
+
+// CreateSource writes the reliability record of a data source, with a score of 100
+func (c *Client) CreateSource(ctx context.Context, input ReliabilityRecordInput) error {
+	_, err := c.do(ctx, http.MethodPost, "/v1/sources", nil, nil, input, nil)
+	return err
+}
+
+// CreateSourceAsync writes the reliability record of a data source without waiting for the commit
+func (c *Client) CreateSourceAsync(ctx context.Context, input ReliabilityRecordInput) error {
+	_, err := c.do(ctx, http.MethodPost, "/v1/sources", url.Values{"async": {"true"}}, nil, input, nil)
+	return err
+}
+
+// CreateSourcesBatch writes many reliability records in one transaction
+func (c *Client) CreateSourcesBatch(ctx context.Context, records []LogRecord) error {
+	recordsJSON, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the records: %w", err)
+	}
+	body := struct {
+		RecordsJSON string `json:"recordsJSON"`
+	}{string(recordsJSON)}
+	_, err = c.do(ctx, http.MethodPost, "/create-reliability-records-batch", nil, nil, body, nil)
+	return err
+}
+
+// CreateInteraction writes every record of an interaction in one transaction and returns their IDs.
+// An interaction without an ID gets a random one rather than the transaction ID the server would choose,
+// so that the interaction keeps its IDs when it is sent again. In local mode the interaction is logged
+// and the result only holds the interaction ID.
+func (c *Client) CreateInteraction(ctx context.Context, interaction Interaction) (*InteractionResult, error) {
+	if interaction.InteractionID == "" {
+		id := make([]byte, 16)
+		rand.Read(id)
+		interaction.InteractionID = hex.EncodeToString(id)
+	}
+	result := InteractionResult{InteractionID: interaction.InteractionID}
+	if _, err := c.do(ctx, http.MethodPost, "/v1/interactions", nil, nil, interaction, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// readRecord returns a single record
+func (c *Client) readRecord(ctx context.Context, path string) (*LogRecord, error) {
+	if c.Local() {
+		return nil, ErrNotFound
+	}
+	var record LogRecord
+	if _, err := c.do(ctx, http.MethodGet, path, nil, readHeader(ctx), nil, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// GetLog returns a log record
+func (c *Client) GetLog(ctx context.Context, logID string) (*LogRecord, error) {
+	return c.readRecord(ctx, "/v1/logs/"+url.PathEscape(logID))
+}
+
+// GetFeedback returns a feedback record
+func (c *Client) GetFeedback(ctx context.Context, logID string) (*LogRecord, error) {
+	return c.readRecord(ctx, "/v1/feedback/"+url.PathEscape(logID))
+}
+
+// GetSource returns the reliability record of a data source, pending score deltas included
+func (c *Client) GetSource(ctx context.Context, dataSourceID string) (*LogRecord, error) {
+	return c.readRecord(ctx, "/v1/sources/"+url.PathEscape(dataSourceID))
+}
+
+// values returns the query parameters of a record filter
+func (f RecordFilter) values() url.Values {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"loggerID":  f.LoggerID,
+		"inputFrom": f.InputFrom,
+		"outputTo":  f.OutputTo,
+		"since":     f.Since,
+		"until":     f.Until,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	return query
+}
+
+// values returns the query parameters of a score filter
+func (f ScoreFilter) values() url.Values {
+	query := url.Values{}
+	if f.MinScore != nil {
+		query.Set("minScore", strconv.FormatFloat(float64(*f.MinScore), 'g', -1, 32))
+	}
+	if f.MaxScore != nil {
+		query.Set("maxScore", strconv.FormatFloat(float64(*f.MaxScore), 'g', -1, 32))
+	}
+	return query
+}
+
+// list returns a page of records
+func (c *Client) list(ctx context.Context, path string, query url.Values, page Page) (*RecordList, error) {
+	list := &RecordList{Records: []LogRecord{}}
+	if c.Local() {
+		return list, nil
+	}
+	if page.Limit > 0 {
+		query.Set("limit", strconv.Itoa(page.Limit))
+	}
+	if page.Bookmark != "" {
+		query.Set("bookmark", page.Bookmark)
+	}
+	if _, err := c.do(ctx, http.MethodGet, path, query, readHeader(ctx), nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// all walks every page of a list
+func (c *Client) all(ctx context.Context, path string, query func() url.Values) iter.Seq2[LogRecord, error] {
+	return func(yield func(LogRecord, error) bool) {
+		page := Page{Limit: listPageSize}
+		for {
+			list, err := c.list(ctx, path, query(), page)
+			if err != nil {
+				yield(LogRecord{}, err)
+				return
+			}
+			for _, record := range list.Records {
+				if !yield(record, nil) {
+					return
+				}
+			}
+			if list.Bookmark == "" {
+				return
+			}
+			page.Bookmark = list.Bookmark
+		}
+	}
+}
+
+// ListLogs returns a page of the log records matching the filter
+func (c *Client) ListLogs(ctx context.Context, filter RecordFilter, page Page) (*RecordList, error) {
+	return c.list(ctx, "/v1/logs", filter.values(), page)
+}
+
+// Logs iterates over every log record matching the filter, stopping at the first error
+func (c *Client) Logs(ctx context.Context, filter RecordFilter) iter.Seq2[LogRecord, error] {
+	return c.all(ctx, "/v1/logs", filter.values)
+}
+
+// ListFeedback returns a page of the feedback records matching the filter
+func (c *Client) ListFeedback(ctx context.Context, filter RecordFilter, page Page) (*RecordList, error) {
+	return c.list(ctx, "/v1/feedback", filter.values(), page)
+}
+
+// Feedback iterates over every feedback record matching the filter, stopping at the first error
+func (c *Client) Feedback(ctx context.Context, filter RecordFilter) iter.Seq2[LogRecord, error] {
+	return c.all(ctx, "/v1/feedback", filter.values)
+}
+
+// ListSources returns a page of the reliability records matching the filter
+func (c *Client) ListSources(ctx context.Context, filter ScoreFilter, page Page) (*RecordList, error) {
+	return c.list(ctx, "/v1/sources", filter.values(), page)
+}
+
+// Sources iterates over every reliability record matching the filter, stopping at the first error
+func (c *Client) Sources(ctx context.Context, filter ScoreFilter) iter.Seq2[LogRecord, error] {
+	return c.all(ctx, "/v1/sources", filter.values)
+}
+
+// GetScore returns the reliability score of a data source, pending deltas included
+func (c *Client) GetScore(ctx context.Context, dataSourceID string) (*Score, error) {
+	if c.Local() {
+		return nil, ErrNotFound
+	}
+	var score Score
+	resp, err := c.do(ctx, http.MethodGet, "/v1/sources/"+url.PathEscape(dataSourceID)+"/score", nil, readHeader(ctx), nil, &score)
+	if err != nil {
+		return nil, err
+	}
+	score.ETag = resp.header.Get("ETag")
+	return &score, nil
+}
+
+// GetScores returns the scores of many data sources in one call, unknown data sources are marked "unknown"
+func (c *Client) GetScores(ctx context.Context, dataSourceIDs []string) (map[string]SourceScore, error) {
+	if c.Local() {
+		scores := make(map[string]SourceScore, len(dataSourceIDs))
+		for _, dataSourceID := range dataSourceIDs {
+			scores[dataSourceID] = SourceScore{Status: "unknown"}
+		}
+		return scores, nil
+	}
+
+	body := struct {
+		IDs []string `json:"ids"`
+	}{dataSourceIDs}
+	var result struct {
+		Scores map[string]SourceScore `json:"scores"`
+	}
+	if _, err := c.do(ctx, http.MethodPost, "/v1/sources/scores", nil, readHeader(ctx), body, &result); err != nil {
+		return nil, err
+	}
+	return result.Scores, nil
+}
+
+// SetScore sets the absolute reliability score of a data source, admins only
+func (c *Client) SetScore(ctx context.Context, dataSourceID string, score float32, info string) error {
+	return c.SetScoreIfMatch(ctx, dataSourceID, score, info, "")
+}
+
+// SetScoreIfMatch sets the score only if it is still the one identified by etag, or returns ErrPreconditionFailed
+func (c *Client) SetScoreIfMatch(ctx context.Context, dataSourceID string, score float32, info string, etag string) error {
+	header := make(http.Header)
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	body := struct {
+		ReliabilityScore float32 `json:"reliabilityScore"`
+		Info             string  `json:"info,omitempty"`
+	}{score, info}
+	_, err := c.do(ctx, http.MethodPut, "/v1/sources/"+url.PathEscape(dataSourceID)+"/score", nil, header, body, nil)
+	return err
+}
+
+// AddScoreDelta adds a delta to the reliability score of a data source, negative to lower it
+func (c *Client) AddScoreDelta(ctx context.Context, dataSourceID string, delta float32, info string) error {
+	body := struct {
+		Delta float32 `json:"delta"`
+		Info  string  `json:"info,omitempty"`
+	}{delta, info}
+	_, err := c.do(ctx, http.MethodPost, "/v1/sources/"+url.PathEscape(dataSourceID)+"/score/deltas", nil, nil, body, nil)
+	return err
+}
+
+// CompactScores folds the pending score deltas into the reliability records, of every data source if dataSourceID is empty
+func (c *Client) CompactScores(ctx context.Context, dataSourceID string) error {
+	query := url.Values{}
+	if dataSourceID != "" {
+		query.Set("dataSourceID", dataSourceID)
+	}
+	_, err := c.do(ctx, http.MethodPost, "/compact-reliability-records", query, nil, nil, nil)
+	return err
+}
+
+// History returns the changes of a record, oldest first
+func (c *Client) History(ctx context.Context, recordID string) ([]LogRecordHistory, error) {
+	var result struct {
+		History []LogRecordHistory `json:"history"`
+	}
+	if _, err := c.do(ctx, http.MethodGet, "/v1/records/"+url.PathEscape(recordID)+"/history", nil, readHeader(ctx), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.History, nil
+}
+
+// Query runs a rich query and returns a page of results, pass the bookmark of a page in the request to get the next one
+func (c *Client) Query(ctx context.Context, request QueryRequest) (*QueryPage, error) {
+	page := &QueryPage{Records: []map[string]any{}}
+	if c.Local() {
+		return page, nil
+	}
+	if _, err := c.do(ctx, http.MethodPost, "/v1/query", nil, readHeader(ctx), request, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// QueryAll iterates over every result of a rich query, following the bookmarks and stopping at the first error
+func (c *Client) QueryAll(ctx context.Context, request QueryRequest) iter.Seq2[map[string]any, error] {
+	return func(yield func(map[string]any, error) bool) {
+		for {
+			page, err := c.Query(ctx, request)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, record := range page.Records {
+				if !yield(record, nil) {
+					return
+				}
+			}
+			if page.Bookmark == "" {
+				return
+			}
+			request.Bookmark = page.Bookmark
+		}
+	}
+}
+
+// values returns the query parameters of a lineage traversal
+func (o LineageOptions) values() url.Values {
+	query := url.Values{}
+	if o.Direction != "" {
+		query.Set("direction", o.Direction)
+	}
+	if o.Depth != nil {
+		query.Set("depth", strconv.Itoa(*o.Depth))
+	}
+	return query
+}
+
+// Lineage returns the provenance graph of a record or an output digest
+func (c *Client) Lineage(ctx context.Context, recordIDOrDigest string, options LineageOptions) (*Lineage, error) {
+	if c.Local() {
+		return nil, ErrNotFound
+	}
+	var lineage Lineage
+	if _, err := c.do(ctx, http.MethodGet, "/v1/records/"+url.PathEscape(recordIDOrDigest)+"/lineage", options.values(), readHeader(ctx), nil, &lineage); err != nil {
+		return nil, err
+	}
+	return &lineage, nil
+}
+
+// LineageGraph returns the provenance graph of a record or an output digest rendered as GraphMermaid, GraphDOT or GraphML
+func (c *Client) LineageGraph(ctx context.Context, recordIDOrDigest string, format string, options LineageOptions) ([]byte, error) {
+	if c.Local() {
+		return nil, ErrNotFound
+	}
+	query := options.values()
+	query.Set("format", format)
+	var graph []byte
+	if _, err := c.do(ctx, http.MethodGet, "/v1/records/"+url.PathEscape(recordIDOrDigest)+"/lineage/graph", query, readHeader(ctx), nil, &graph); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// CacheStats returns the counters of the score cache of the server, admins only
+func (c *Client) CacheStats(ctx context.Context) (*CacheStats, error) {
+	var stats CacheStats
+	if _, err := c.do(ctx, http.MethodGet, "/v1/cache/stats", nil, nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,310 @@
+// Package draglog is a client of the DRagLog API server.
+//
+// The client calls the versioned /v1 endpoints, and the legacy ones for the operations /v1 does not cover.
+// Requests are retried when the server refuses them before processing them, and the GET and PUT requests
+// also when the network or a gateway fails. The lists and queries can be walked page by page or with the
+// iterators. In local mode nothing is sent: the writes are appended to a JSONL file in the format of the
+// Python client with local=True.
+// The trace context of the request contexts is sent with the propagator set by otel.SetTextMapPropagator.
+package draglog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	// ErrNotFound is returned when the record does not exist, and by the single reads in local mode
+	ErrNotFound = errors.New("draglog: not found")
+	// ErrForbidden is returned when the caller is not allowed to call the operation
+	ErrForbidden = errors.New("draglog: forbidden")
+	// ErrPreconditionFailed is returned when the If-Match ETag does not match the current score
+	ErrPreconditionFailed = errors.New("draglog: precondition failed")
+)
+
+// ErrorDetail is a problem with a field of the request
+type ErrorDetail struct {
+	Message  string `json:"message"`
+	Location string `json:"location,omitempty"`
+	Value    any    `json:"value,omitempty"`
+}
+
+// APIError is an error response of the server
+type APIError struct {
+	Status int           `json:"status"`
+	Title  string        `json:"title"`
+	Detail string        `json:"detail"`
+	Errors []ErrorDetail `json:"errors,omitempty"`
+	// RetryAfter is the delay asked by the server before retrying, if any
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("draglog: %d %s", e.Status, e.Title)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, detail := range e.Errors {
+		msg += fmt.Sprintf("; %s (%s)", detail.Message, detail.Location)
+	}
+	return msg
+}
+
+// Is matches the API error with ErrNotFound, ErrForbidden and ErrPreconditionFailed
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrForbidden:
+		return e.Status == http.StatusForbidden
+	case ErrPreconditionFailed:
+		return e.Status == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// Client calls the DRagLog API server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	header     http.Header
+	maxRetries int
+	backoff    time.Duration
+
+	localPath string
+	localMu   sync.Mutex
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAPIKey authenticates the requests with an API key sent in the X-API-Key header
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) { c.header.Set("X-API-Key", apiKey) }
+}
+
+// WithToken authenticates the requests with a JWT sent as a bearer token
+func WithToken(token string) Option {
+	return func(c *Client) { c.header.Set("Authorization", "Bearer "+token) }
+}
+
+// WithHTTPClient sets the HTTP client sending the requests, http.DefaultClient by default
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithRetries sets how many times a failed request is retried, 3 by default, and the delay
+// before the first retry, 200ms by default, doubled for every following one
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// WithLocalFile enables the local mode: writes are appended to the JSONL file instead of being sent,
+// lists and queries return no record and single reads return ErrNotFound
+func WithLocalFile(path string) Option {
+	return func(c *Client) { c.localPath = path }
+}
+
+// New returns a client of the API server at baseURL, such as http://localhost:8080
+func New(baseURL string, options ...Option) (*Client, error) {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		header:     make(http.Header),
+		maxRetries: 3,
+		backoff:    200 * time.Millisecond,
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.localPath != "" {
+		if err := os.MkdirAll(filepath.Dir(c.localPath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create the local log directory: %w", err)
+		}
+		return c, nil
+	}
+	if _, err := url.Parse(c.baseURL); err != nil || c.baseURL == "" {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+	return c, nil
+}
+
+// Local reports whether the client writes to a local file instead of the server
+func (c *Client) Local() bool {
+	return c.localPath != ""
+}
+
+// response is the part of a response the methods read besides the body
+type response struct {
+	header http.Header
+	status int
+}
+
+// do sends a request with a JSON body and decodes the JSON response into out, retrying when the request
+// was not processed. In local mode the body of the writes is logged and out is left empty.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, header http.Header, body any, out any) (*response, error) {
+	if c.Local() {
+		if method != http.MethodGet && body != nil {
+			return &response{}, c.writeLocal(method, path, body)
+		}
+		return &response{}, nil
+	}
+
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+		}
+	}
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, target, header, data, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(method, err) {
+			return resp, err
+		}
+
+		wait := delay
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// send sends a request once
+func (c *Client) send(ctx context.Context, method string, target string, header http.Header, data []byte, out any) (*response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	// the trace of the caller continues in the server, with the propagator the application set
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		apiErr := &APIError{Status: resp.StatusCode, Title: http.StatusText(resp.StatusCode)}
+		json.Unmarshal(content, apiErr)
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, apiErr
+	}
+
+	switch out := out.(type) {
+	case nil:
+	case *[]byte:
+		*out = content
+	default:
+		if len(content) > 0 && resp.StatusCode != http.StatusNotModified {
+			if err := json.Unmarshal(content, out); err != nil {
+				return nil, fmt.Errorf("failed to parse the response: %w", err)
+			}
+		}
+	}
+	return &response{header: resp.Header, status: resp.StatusCode}, nil
+}
+
+// retryable reports whether a failed request can be sent again. Every request is retried when the server
+// refused it before processing it, but only the idempotent GET and PUT when the network or a gateway failed,
+// since the server may have processed a POST whose response was lost.
+func retryable(method string, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	idempotent := method == http.MethodGet || method == http.MethodPut
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// the request could not be sent, or its response was lost
+		return idempotent
+	}
+
+	switch apiErr.Status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// writeLocal appends a write to the local JSONL file, with the operation named like the Python client does
+func (c *Client) writeLocal(method string, path string, body any) error {
+	entry := struct {
+		Timestamp string `json:"timestamp"`
+		Operation string `json:"operation"`
+		Record    any    `json:"record"`
+	}{
+		Timestamp: time.Now().Format("2006-01-02T15:04:05.000000"),
+		Operation: strings.ToLower(method) + "_" + strings.ReplaceAll(path, "/", "_"),
+		Record:    body,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the local log entry: %w", err)
+	}
+
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	file, err := os.OpenFile(c.localPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the local log: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write the local log: %w", err)
+	}
+	return nil
+}
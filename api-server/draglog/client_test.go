@@ -0,0 +1,91 @@
+package draglog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of a server answering every request with the handler, and the number of requests it got
+func newTestClient(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*Client, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := New(server.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return client, &requests
+}
+
+// dropConnection closes the connection without a response, as a network failure after the server got the request
+func dropConnection(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(err)
+	}
+	conn.Close()
+}
+
+func TestLostResponsesOnlyRetryIdempotentRequests(t *testing.T) {
+	ctx := context.Background()
+	client, requests := newTestClient(t, dropConnection)
+
+	if _, err := client.GetLog(ctx, "log1"); err == nil {
+		t.Fatal("GetLog succeeded without a response")
+	}
+	if got := requests.Swap(0); got != 3 {
+		t.Errorf("GET sent %d times, want 3", got)
+	}
+
+	if err := client.SetScore(ctx, "source1", 50, "review"); err == nil {
+		t.Fatal("SetScore succeeded without a response")
+	}
+	if got := requests.Swap(0); got != 3 {
+		t.Errorf("PUT sent %d times, want 3", got)
+	}
+
+	if err := client.AddScoreDelta(ctx, "source1", -5, "feedback"); err == nil {
+		t.Fatal("AddScoreDelta succeeded without a response")
+	}
+	if got := requests.Swap(0); got != 1 {
+		t.Errorf("POST sent %d times, want 1", got)
+	}
+}
+
+func TestRefusedRequestsAreRetried(t *testing.T) {
+	ctx := context.Background()
+	client, requests := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"status": 429, "title": "Too Many Requests"}`, http.StatusTooManyRequests)
+			return
+		}
+		http.Error(w, `{"status": 502, "title": "Bad Gateway"}`, http.StatusBadGateway)
+	})
+
+	err := client.AddScoreDelta(ctx, "source1", -5, "feedback")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusTooManyRequests {
+		t.Fatalf("AddScoreDelta: %v, want a 429", err)
+	}
+	if got := requests.Swap(0); got != 3 {
+		t.Errorf("refused POST sent %d times, want 3", got)
+	}
+
+	if _, err := client.GetLog(ctx, "log1"); err == nil {
+		t.Fatal("GetLog succeeded on a 502")
+	}
+	if got := requests.Swap(0); got != 3 {
+		t.Errorf("GET sent %d times on a 502, want 3", got)
+	}
+}
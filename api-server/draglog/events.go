@@ -0,0 +1,112 @@
+package draglog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ChaincodeEvent is an event emitted by a committed transaction of the chaincode
+type ChaincodeEvent struct {
+	BlockNumber   uint64          `json:"blockNumber"`
+	TransactionID string          `json:"transactionID"`
+	EventName     string          `json:"eventName"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Events streams the chaincode events from startBlock, or from the next committed block when startBlock
+// is nil, until the context is done or the stream fails. Resume a failed stream from the block after
+// the last event received.
+func (c *Client) Events(ctx context.Context, startBlock *uint64) iter.Seq2[ChaincodeEvent, error] {
+	return func(yield func(ChaincodeEvent, error) bool) {
+		if c.Local() {
+			yield(ChaincodeEvent{}, errors.New("draglog: events are not available in local mode"))
+			return
+		}
+
+		target := c.baseURL + "/v1/events"
+		if startBlock != nil {
+			target += "?" + url.Values{"startBlock": {strconv.FormatUint(*startBlock, 10)}}.Encode()
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			yield(ChaincodeEvent{}, err)
+			return
+		}
+		for key, values := range c.header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			yield(ChaincodeEvent{}, err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			apiErr := &APIError{Status: resp.StatusCode, Title: http.StatusText(resp.StatusCode)}
+			json.NewDecoder(resp.Body).Decode(apiErr)
+			yield(ChaincodeEvent{}, apiErr)
+			return
+		}
+
+		// a message is a set of "field: value" lines ended by a blank line
+		var name, data string
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line != "" {
+				field, value, _ := strings.Cut(line, ":")
+				value = strings.TrimPrefix(value, " ")
+				switch field {
+				case "event":
+					name = value
+				case "data":
+					data += value
+				}
+				continue
+			}
+
+			switch name {
+			case "chaincodeEvent":
+				var event ChaincodeEvent
+				if err := json.Unmarshal([]byte(data), &event); err != nil {
+					yield(ChaincodeEvent{}, fmt.Errorf("failed to parse the event: %w", err))
+					return
+				}
+				if !yield(event, nil) {
+					return
+				}
+			case "streamError":
+				var streamErr struct {
+					Message string `json:"message"`
+				}
+				json.Unmarshal([]byte(data), &streamErr)
+				yield(ChaincodeEvent{}, fmt.Errorf("draglog: event stream failed: %s", streamErr.Message))
+				return
+			}
+			name, data = "", ""
+		}
+
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			yield(ChaincodeEvent{}, fmt.Errorf("failed to read the event stream: %w", err))
+			return
+		}
+		if ctx.Err() == nil {
+			yield(ChaincodeEvent{}, errors.New("draglog: event stream closed"))
+		}
+	}
+}
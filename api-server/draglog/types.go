@@ -0,0 +1,204 @@
+package draglog
+
+// The record types stored by the chaincode
+const (
+	TypeLog         = "log"
+	TypeFeedback    = "feedback"
+	TypeReliability = "reliability"
+)
+
+// LogRecord is a log, feedback or reliability record
+type LogRecord struct {
+	LogID            string  `json:"logID"`
+	LoggerID         string  `json:"loggerID"`
+	Type             string  `json:"type"`
+	Input            string  `json:"input"`
+	InputFrom        string  `json:"inputFrom"`
+	Output           string  `json:"output"`
+	OutputTo         string  `json:"outputTo"`
+	ReliabilityScore float32 `json:"reliabilityScore"`
+	Timestamp        string  `json:"timestamp"`
+	Reserved         string  `json:"reserved"`
+}
+
+// LogRecordHistory is a change of a record
+type LogRecordHistory struct {
+	Record    *LogRecord `json:"record"`
+	Timestamp string     `json:"timestamp"`
+	TxID      string     `json:"txID"`
+	IsDelete  bool       `json:"isDelete"`
+}
+
+// ReliabilityRecordInput creates the reliability record of a data source
+type ReliabilityRecordInput struct {
+	DataSourceID string `json:"dataSourceID"`
+	Digest       string `json:"digest"`
+	Reserved     string `json:"reserved"`
+}
+
+// RecordFilter selects log and feedback records, empty fields match every record
+type RecordFilter struct {
+	LoggerID  string
+	InputFrom string
+	OutputTo  string
+	// Since and Until bound the timestamps, compared as strings
+	Since string
+	Until string
+}
+
+// ScoreFilter selects reliability records by score, nil bounds match every record
+type ScoreFilter struct {
+	MinScore *float32
+	MaxScore *float32
+}
+
+// Page selects a window of a list, a zero Limit lets the server choose and an empty Bookmark starts at the first page
+type Page struct {
+	Limit    int
+	Bookmark string
+}
+
+// RecordList is a page of records, Bookmark is the bookmark of the next page and empty on the last one
+type RecordList struct {
+	Records  []LogRecord `json:"records"`
+	Bookmark string      `json:"bookmark,omitempty"`
+}
+
+// Score is the current reliability score of a data source, pending deltas included
+type Score struct {
+	DataSourceID     string  `json:"dataSourceID"`
+	ReliabilityScore float32 `json:"reliabilityScore"`
+	// ETag identifies the score, pass it to SetScoreIfMatch to only overwrite this score
+	ETag string `json:"-"`
+}
+
+// SourceScore is the score of a data source in a bulk lookup, ReliabilityScore is nil for unknown data sources
+type SourceScore struct {
+	Status           string   `json:"status"`
+	ReliabilityScore *float32 `json:"reliabilityScore,omitempty"`
+}
+
+// QueryRequest is a rich query over the records, see POST /v1/query
+type QueryRequest struct {
+	Selector map[string]any      `json:"selector"`
+	Sort     []map[string]string `json:"sort,omitempty"`
+	Fields   []string            `json:"fields,omitempty"`
+	Limit    int                 `json:"limit,omitempty"`
+	Bookmark string              `json:"bookmark,omitempty"`
+}
+
+// QueryPage is a page of query results, Bookmark is empty on the last page
+type QueryPage struct {
+	Records  []map[string]any `json:"records"`
+	Count    int              `json:"count"`
+	Bookmark string           `json:"bookmark,omitempty"`
+}
+
+// InteractionSource is a document retrieved from a data source during an interaction
+type InteractionSource struct {
+	DataSourceID string `json:"dataSourceID"`
+	Digest       string `json:"digest"`
+}
+
+// InteractionFeedback is the evaluation of the answer of an interaction
+type InteractionFeedback struct {
+	Evaluation string `json:"evaluation"`
+	Reserved   string `json:"reserved,omitempty"`
+}
+
+// Interaction is a whole RAG interaction, written as one transaction
+type Interaction struct {
+	InteractionID  string               `json:"interactionID,omitempty"`
+	RerankerID     string               `json:"rerankerID"`
+	LLMID          string               `json:"llmID"`
+	UserID         string               `json:"userID,omitempty"`
+	Sources        []InteractionSource  `json:"sources"`
+	RerankerOutput string               `json:"rerankerOutput"`
+	LLMOutput      string               `json:"llmOutput"`
+	Timestamp      string               `json:"timestamp"`
+	Feedback       *InteractionFeedback `json:"feedback,omitempty"`
+}
+
+// InteractionResult lists the records written for an interaction
+type InteractionResult struct {
+	InteractionID string   `json:"interactionID"`
+	LogIDs        []string `json:"logIDs"`
+}
+
+// The directions of a lineage traversal
+const (
+	Upstream   = "upstream"
+	Downstream = "downstream"
+	Both       = "both"
+)
+
+// LineageOptions bound a lineage traversal, zero values let the server choose
+type LineageOptions struct {
+	Direction string
+	Depth     *int
+}
+
+// LineageNode is a record of a lineage graph, Depth is its distance to the start
+type LineageNode struct {
+	Record LogRecord `json:"record"`
+	Depth  int       `json:"depth"`
+}
+
+// LineageEdge links the record whose output is an input of another record
+type LineageEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// LineageSource is a data source whose documents are part of a lineage graph
+type LineageSource struct {
+	DataSourceID     string  `json:"dataSourceID"`
+	Found            bool    `json:"found"`
+	ReliabilityScore float32 `json:"reliabilityScore"`
+}
+
+// Lineage is the provenance graph around a record or an output digest
+type Lineage struct {
+	Start     []string        `json:"start"`
+	Nodes     []LineageNode   `json:"nodes"`
+	Edges     []LineageEdge   `json:"edges"`
+	Sources   []LineageSource `json:"sources"`
+	Truncated bool            `json:"truncated"`
+}
+
+// The formats a lineage graph can be rendered as
+const (
+	GraphMermaid = "mermaid"
+	GraphDOT     = "dot"
+	GraphML      = "graphml"
+)
+
+// CacheStats are the counters of the reliability record cache of the server
+type CacheStats struct {
+	Enabled        bool   `json:"enabled"`
+	Listening      bool   `json:"listening"`
+	Entries        int    `json:"entries"`
+	Hits           uint64 `json:"hits"`
+	Misses         uint64 `json:"misses"`
+	Bypasses       uint64 `json:"bypasses"`
+	Updates        uint64 `json:"updates"`
+	Invalidations  uint64 `json:"invalidations"`
+	LastEventBlock uint64 `json:"lastEventBlock"`
+}
+
+// PeerStatus is the state of a gateway peer of the server
+type PeerStatus struct {
+	Endpoint    string `json:"endpoint"`
+	GatewayPeer string `json:"gatewayPeer"`
+	Active      bool   `json:"active"`
+	Healthy     bool   `json:"healthy"`
+	LastError   string `json:"lastError,omitempty"`
+	LastCheck   string `json:"lastCheck,omitempty"`
+}
+
+// Health is the state of the server and its gateway peers
+type Health struct {
+	Ready      bool         `json:"ready"`
+	ActivePeer string       `json:"activePeer"`
+	Peers      []PeerStatus `json:"peers"`
+}
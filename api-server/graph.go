@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"draglog_api/utils"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/conditional"
+	"github.com/danielgtaylor/huma/v2/humacli"
+	"github.com/spf13/cobra"
+)
+
+// The formats a lineage graph can be rendered as, with their content type
+var graphFormats = map[string]string{
+	"mermaid": "text/vnd.mermaid; charset=utf-8",
+	"dot":     "text/vnd.graphviz; charset=utf-8",
+	"graphml": "application/graphml+xml; charset=utf-8",
+}
+
+// maxFeedbackLabel is the length of the feedback evaluation kept in a node label
+const maxFeedbackLabel = 40
+
+type GraphResponse struct {
+	ContentType string `header:"Content-Type"`
+	ETag        string `header:"ETag"`
+	Body        []byte
+}
+
+// graphNode is a record of a lineage graph with the annotations shown in the diagrams
+type graphNode struct {
+	ID       string
+	Record   LogRecord
+	Depth    int
+	Start    bool
+	Score    string
+	Feedback string
+}
+
+// graphNodes annotates the records of a lineage with the score of their data source and the feedback outcome
+func graphNodes(lineage *Lineage) []graphNode {
+	scores := make(map[string]string, len(lineage.Sources))
+	for _, source := range lineage.Sources {
+		scores[source.DataSourceID] = "unknown"
+		if source.Found {
+			scores[source.DataSourceID] = fmt.Sprintf("%g", source.ReliabilityScore)
+		}
+	}
+
+	nodes := make([]graphNode, 0, len(lineage.Nodes))
+	for i, node := range lineage.Nodes {
+		graph := graphNode{ID: fmt.Sprintf("n%d", i), Record: node.Record, Depth: node.Depth}
+		for _, start := range lineage.Start {
+			graph.Start = graph.Start || start == node.Record.LogID
+		}
+		if node.Record.InputFrom == "" {
+			graph.Score = scores[node.Record.LoggerID]
+		}
+		if node.Record.Type == recordTypeFeedback {
+			graph.Feedback = node.Record.Output
+			if runes := []rune(graph.Feedback); len(runes) > maxFeedbackLabel {
+				graph.Feedback = string(runes[:maxFeedbackLabel]) + "…"
+			}
+		}
+		nodes = append(nodes, graph)
+	}
+	return nodes
+}
+
+// label returns the lines describing a node in the diagrams
+func (n graphNode) label() []string {
+	lines := []string{n.Record.LogID, n.Record.LoggerID}
+	if n.Score != "" {
+		lines = append(lines, "score: "+n.Score)
+	}
+	if n.Record.Type == recordTypeFeedback {
+		lines = append(lines, "feedback: "+n.Feedback)
+	}
+	return lines
+}
+
+// class returns the style of a node in the diagrams
+func (n graphNode) class() string {
+	switch {
+	case n.Start:
+		return "start"
+	case n.Record.Type == recordTypeFeedback:
+		return "feedback"
+	case n.Score != "":
+		return "source"
+	}
+	return "record"
+}
+
+// renderLineage renders a lineage graph in the given format
+func renderLineage(lineage *Lineage, format string) ([]byte, error) {
+	nodes := graphNodes(lineage)
+	ids := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		ids[node.Record.LogID] = node.ID
+	}
+
+	switch format {
+	case "mermaid":
+		return renderMermaid(nodes, lineage.Edges, ids), nil
+	case "dot":
+		return renderDOT(nodes, lineage.Edges, ids), nil
+	case "graphml":
+		return renderGraphML(nodes, lineage.Edges, ids)
+	}
+	return nil, fmt.Errorf("unknown graph format %s", format)
+}
+
+// renderMermaid renders a Mermaid flowchart, left to right from the data sources to the feedback
+func renderMermaid(nodes []graphNode, edges []LineageEdge, ids map[string]string) []byte {
+	escape := strings.NewReplacer("#", "#35;", `"`, "#quot;", "&", "#amp;", "<", "#lt;", ">", "#gt;", "\n", " ")
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, node := range nodes {
+		lines := node.label()
+		for i, line := range lines {
+			lines[i] = escape.Replace(line)
+		}
+		fmt.Fprintf(&b, "    %s[\"%s\"]:::%s\n", node.ID, strings.Join(lines, "<br/>"), node.class())
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(&b, "    %s --> %s\n", ids[edge.From], ids[edge.To])
+	}
+	b.WriteString("    classDef start stroke-width:3px\n")
+	b.WriteString("    classDef source fill:#dae8fc\n")
+	b.WriteString("    classDef feedback fill:#fff2cc\n")
+	b.WriteString("    classDef record fill:#f5f5f5\n")
+	return []byte(b.String())
+}
+
+// dotColors are the fill colors of the node classes in DOT
+var dotColors = map[string]string{
+	"start":    "#d5e8d4",
+	"source":   "#dae8fc",
+	"feedback": "#fff2cc",
+	"record":   "#f5f5f5",
+}
+
+// renderDOT renders a Graphviz digraph
+func renderDOT(nodes []graphNode, edges []LineageEdge, ids map[string]string) []byte {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var b strings.Builder
+	b.WriteString("digraph lineage {\n")
+	b.WriteString("    rankdir=LR;\n")
+	b.WriteString("    node [shape=box, style=filled];\n")
+	for _, node := range nodes {
+		lines := node.label()
+		for i, line := range lines {
+			lines[i] = escape.Replace(line)
+		}
+		fmt.Fprintf(&b, "    %s [label=\"%s\", fillcolor=\"%s\"];\n", node.ID, strings.Join(lines, `\n`), dotColors[node.class()])
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(&b, "    %s -> %s;\n", ids[edge.From], ids[edge.To])
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+// The GraphML document, with the record fields and annotations as node data
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string           `xml:"id,attr"`
+		EdgeDefault string           `xml:"edgedefault,attr"`
+		Nodes       []graphMLElement `xml:"node"`
+		Edges       []graphMLElement `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLElement struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr,omitempty"`
+	Target string        `xml:"target,attr,omitempty"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// renderGraphML renders a GraphML document, readable by yEd, Gephi or networkx
+func renderGraphML(nodes []graphNode, edges []LineageEdge, ids map[string]string) ([]byte, error) {
+	doc := graphML{XMLNS: "http://graphml.graphdrawing.org/xmlns"}
+	for _, key := range []struct{ id, attrType string }{
+		{"logID", "string"},
+		{"loggerID", "string"},
+		{"type", "string"},
+		{"output", "string"},
+		{"outputTo", "string"},
+		{"timestamp", "string"},
+		{"depth", "int"},
+		{"start", "boolean"},
+		{"reliabilityScore", "string"},
+		{"feedback", "string"},
+	} {
+		doc.Keys = append(doc.Keys, graphMLKey{ID: key.id, For: "node", AttrName: key.id, AttrType: key.attrType})
+	}
+	doc.Graph.ID = "lineage"
+	doc.Graph.EdgeDefault = "directed"
+
+	for _, node := range nodes {
+		element := graphMLElement{ID: node.ID, Data: []graphMLData{
+			{Key: "logID", Value: node.Record.LogID},
+			{Key: "loggerID", Value: node.Record.LoggerID},
+			{Key: "type", Value: node.Record.Type},
+			{Key: "output", Value: node.Record.Output},
+			{Key: "outputTo", Value: node.Record.OutputTo},
+			{Key: "timestamp", Value: node.Record.Timestamp},
+			{Key: "depth", Value: fmt.Sprint(node.Depth)},
+			{Key: "start", Value: fmt.Sprint(node.Start)},
+		}}
+		if node.Score != "" {
+			element.Data = append(element.Data, graphMLData{Key: "reliabilityScore", Value: node.Score})
+		}
+		if node.Record.Type == recordTypeFeedback {
+			element.Data = append(element.Data, graphMLData{Key: "feedback", Value: node.Record.Output})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, element)
+	}
+	for i, edge := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLElement{ID: fmt.Sprintf("e%d", i), Source: ids[edge.From], Target: ids[edge.To]})
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal GraphML: %w", err)
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// graphResponses documents the content type of each graph format
+func graphResponses() map[string]*huma.Response {
+	content := make(map[string]*huma.MediaType, len(graphFormats))
+	for _, contentType := range graphFormats {
+		content[strings.SplitN(contentType, ";", 2)[0]] = &huma.MediaType{Schema: &huma.Schema{Type: huma.TypeString}}
+	}
+	return map[string]*huma.Response{
+		"200": {Description: "The rendered graph", Content: content},
+	}
+}
+
+// registerGraphRoute registers GET /v1/records/{id}/lineage/graph
+func registerGraphRoute(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "GetRecordLineageGraph",
+		Method:      http.MethodGet,
+		Path:        "/v1/records/{id}/lineage/graph",
+		Summary:     "Render the provenance graph of a record",
+		Description: "Render the lineage of a record or output digest as a Mermaid flowchart, a Graphviz DOT digraph or a GraphML document. Data source records are annotated with their reliability score and feedback records with the evaluation. The whole trace of an interaction is the lineage in both directions of any of its records.",
+		Tags:        []string{"v1"},
+		Responses:   graphResponses(),
+	}, func(ctx context.Context, input *struct {
+		ID     string `path:"id" doc:"Record ID or output digest"`
+		Format string `query:"format" enum:"mermaid,dot,graphml" default:"mermaid" doc:"Diagram format"`
+		LineageParams
+		conditional.Params
+	}) (*GraphResponse, error) {
+		lineage, err := readLineage(ctx, input.ID, input.LineageParams)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &GraphResponse{ContentType: graphFormats[input.Format]}
+		if resp.Body, err = renderLineage(lineage, input.Format); err != nil {
+			return nil, err
+		}
+		resp.ETag = etag(resp.Body)
+		if err := input.PreconditionFailed(resp.ETag, time.Time{}); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
+
+// addGraphCommand adds the command rendering the provenance graph of a record straight from the ledger
+func addGraphCommand(cli humacli.CLI) {
+	var params LineageParams
+	var format, output string
+	graphCmd := &cobra.Command{
+		Use:          "graph <record ID or digest>",
+		Short:        "Render the provenance graph of a record as Mermaid, DOT or GraphML",
+		Long:         "Render the provenance graph of a record as Mermaid, DOT or GraphML, read from the ledger of --ledger.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: withOptionsE(func(cmd *cobra.Command, args []string, options *Options) error {
+			return writeGraph(options, args[0], params, format, output)
+		}),
+	}
+	graphCmd.Flags().StringVar(&format, "format", "mermaid", "Diagram format: mermaid, dot or graphml")
+	graphCmd.Flags().StringVar(&params.Direction, "direction", "both", "Follow the edges upstream, downstream or both")
+	graphCmd.Flags().IntVar(&params.Depth, "depth", 5, "Maximum number of edges from the start")
+	graphCmd.Flags().StringVarP(&output, "output", "o", "", "File to write the graph to, stdout if empty")
+
+	cli.Root().AddCommand(graphCmd)
+}
+
+// writeGraph renders the provenance graph of a record read from the ledger of the options to the output file,
+// or to stdout if empty
+func writeGraph(options *Options, recordIDOrDigest string, params LineageParams, format string, output string) error {
+	if _, ok := graphFormats[format]; !ok {
+		return fmt.Errorf("unknown graph format %s, expected mermaid, dot or graphml", format)
+	}
+	gatewayConfig, err := options.gatewayConfig()
+	if err != nil {
+		return err
+	}
+	if err := options.openLedger(gatewayConfig); err != nil {
+		return err
+	}
+	defer utils.CloseLedger()
+
+	lineage, err := readLineage(context.Background(), recordIDOrDigest, params)
+	if err != nil {
+		return err
+	}
+	data, err := renderLineage(lineage, format)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the graph of %d records to %s\n", len(lineage.Nodes), output)
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// testLineage is a lineage from a data source to feedback, with IDs and outputs the formats must escape
+var testLineage = &Lineage{
+	Start: []string{`q1:LLM0-"user"`},
+	Nodes: []LineageNode{
+		{Record: LogRecord{LogID: "q1:source0-reranker0", LoggerID: "source0", Type: recordTypeLog, Output: "digest0", OutputTo: "reranker0"}, Depth: 2},
+		{Record: LogRecord{LogID: "q1:reranker0-LLM0", LoggerID: "reranker0", Type: recordTypeLog, InputFrom: `["source0"]`, Output: "a < b & c", OutputTo: "LLM0"}, Depth: 1},
+		{Record: LogRecord{LogID: `q1:LLM0-"user"`, LoggerID: "LLM0", Type: recordTypeLog, InputFrom: "reranker0", Output: "answer", OutputTo: `"user"`}, Depth: 0},
+		{Record: LogRecord{LogID: "q1:user-feedback", LoggerID: "user#1 <admin>", Type: recordTypeFeedback, InputFrom: "LLM0",
+			Output: "wrong \\ answer,\nsee <ticket> & \"notes\" about the reranker output"}, Depth: 1},
+	},
+	Edges: []LineageEdge{
+		{From: "q1:source0-reranker0", To: "q1:reranker0-LLM0"},
+		{From: "q1:reranker0-LLM0", To: `q1:LLM0-"user"`},
+		{From: `q1:LLM0-"user"`, To: "q1:user-feedback"},
+	},
+	Sources: []LineageSource{{DataSourceID: "source0", Found: true, ReliabilityScore: 72.5}},
+}
+
+func TestRenderLineage(t *testing.T) {
+	for _, format := range []string{"mermaid", "dot", "graphml"} {
+		t.Run(format, func(t *testing.T) {
+			got, err := renderLineage(testLineage, format)
+			if err != nil {
+				t.Fatalf("renderLineage: %v", err)
+			}
+			golden := filepath.Join("testdata", "lineage."+format)
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatalf("failed to write %s: %v", golden, err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", golden, err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("%s graph differs from %s:\n%s", format, golden, got)
+			}
+		})
+	}
+
+	if _, err := renderLineage(testLineage, "png"); err == nil {
+		t.Error("renderLineage accepted an unknown format")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"draglog_api/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/danielgtaylor/huma/v2/humacli"
+	"github.com/spf13/cobra"
+)
+
+// addLedgerCommands adds the commands checking the transaction log of the local ledger and replaying it into Fabric
+func addLedgerCommands(cli humacli.CLI) {
+	ledgerCmd := &cobra.Command{
+		Use:   "ledger",
+		Short: "Manage the transaction log of the local ledger in --ledger-dir",
+	}
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the hash chain of the transaction log",
+		Args:  cobra.NoArgs,
+		Run: humacli.WithOptions(func(cmd *cobra.Command, args []string, options *Options) {
+			path := filepath.Join(options.LedgerDir, utils.TxLogFile)
+			blocks, lastHash, err := utils.VerifyTxLog(path)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s: %d blocks, last hash %s\n", path, blocks, lastHash)
+		}),
+	}
+
+	var fromBlock uint64
+	var dryRun bool
+	replayCmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Submit the transactions of the local ledger to the Fabric network of the gateway config",
+		Long: "Submit the transactions of the local ledger to the Fabric network of the gateway config, in order and as their " +
+			"original caller when a wallet is set. The replay stops at the first failed transaction, rerun it with --from-block " +
+			"to resume. Values derived from the transaction ID or time, such as the default interaction ID, differ on Fabric.",
+		Args: cobra.NoArgs,
+		Run: humacli.WithOptions(func(cmd *cobra.Command, args []string, options *Options) {
+			path := filepath.Join(options.LedgerDir, utils.TxLogFile)
+			if !dryRun {
+				gatewayConfig, err := options.gatewayConfig()
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				if err := gatewayConfig.Validate(); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				if err := utils.InitGateway(gatewayConfig); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				defer utils.CloseGateway()
+			}
+
+			replayed := 0
+			var failed *utils.ReplayResult
+			err := utils.ReplayTxLog(context.Background(), path, fromBlock, dryRun, func(result utils.ReplayResult) bool {
+				status := "submitted"
+				if dryRun {
+					status = "would submit"
+				}
+				if result.Err != nil {
+					failed = &result
+					status = "failed: " + result.Err.Error()
+				} else {
+					replayed++
+				}
+				fmt.Printf("block %d %s(%d args) as %q: %s\n", result.Entry.Block, result.Entry.Function, len(result.Entry.Args), result.Entry.Caller, status)
+				return true
+			})
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			if failed != nil {
+				fmt.Printf("Replayed %d transactions, rerun with --from-block %d after fixing block %d\n", replayed, failed.Entry.Block, failed.Entry.Block)
+				os.Exit(1)
+			}
+			fmt.Printf("Replayed %d transactions\n", replayed)
+		}),
+	}
+	replayCmd.Flags().Uint64Var(&fromBlock, "from-block", 1, "First block of the transaction log to submit")
+	replayCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the transactions without submitting them")
+
+	ledgerCmd.AddCommand(verifyCmd, replayCmd)
+	cli.Root().AddCommand(ledgerCmd)
+}
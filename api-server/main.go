@@ -4,15 +4,20 @@ import (
 	"context"
 	"draglog_api/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/danielgtaylor/huma/v2/humacli"
 	"github.com/go-chi/chi/v5"
+	"github.com/spf13/cobra"
 )
 
 type LogRecord struct {
@@ -55,72 +60,368 @@ type Selector struct {
 	}
 }
 
-type Options struct {
-	Port int `help:"Port to listen on" short:"p" default:"8080"`
+// WriteResponse answers 202 when the write was queued until the ledger is reachable
+type WriteResponse struct {
+	Status int
 }
 
-var debug = true
-var debugLogFile *os.File
+type HealthResponse struct {
+	Status int
+	Body   utils.GatewayStatus
+}
 
-func initDebugLog() error {
-	if !debug {
-		return nil
-	}
+type Options struct {
+	Port            int           `doc:"Port to listen on" short:"p" default:"8080"`
+	ReadTimeout     time.Duration `doc:"Maximum duration for reading a request" default:"30s"`
+	WriteTimeout    time.Duration `doc:"Maximum duration for writing a response, longer than the commit status timeout" default:"2m"`
+	IdleTimeout     time.Duration `doc:"Maximum time to keep an idle connection open" default:"2m"`
+	ShutdownTimeout time.Duration `doc:"Maximum time to drain in-flight requests and pending commits on shutdown" default:"30s"`
+	CompactInterval time.Duration `doc:"Interval between compactions of the reliability score deltas, 0 to disable" default:"0"`
+	QueryMaxLimit   int           `doc:"Maximum number of records returned by a page of POST /v1/query" default:"1000"`
+	QueryTimeout    time.Duration `doc:"Maximum execution time of POST /v1/query" default:"10s"`
+	ScoreCacheTTL   time.Duration `name:"score-cache-ttl" doc:"Maximum age of the cached reliability records, kept up to date from the chaincode events, 0 to disable the cache" default:"0"`
+
+	// Ledger backend, the Fabric gateway or the chaincode run in-process against an in-memory or local state
+	Ledger    string `doc:"Ledger the transactions go to: fabric for the gateway peers, memory or local to run the chaincode in-process without a network, local keeping the state in --ledger-dir" default:"fabric"`
+	LedgerDir string `doc:"Directory of the transaction log of the local ledger" default:"data/ledger"`
+
+	// Write-ahead queue of the log and feedback writes accepted while the ledger is unreachable
+	WriteQueueDir      string        `doc:"Directory of the write queue, log and feedback writes are accepted with 202 and submitted once the ledger is back, disabled if empty"`
+	WriteQueueInterval time.Duration `doc:"Interval between drains of the write queue" default:"5s"`
+
+	// Hash-chained audit log of the requests that are not reads
+	AuditLog        string `doc:"Path of the audit log recording the caller, the request digest, the status and the transaction IDs of every request that is not a read, disabled if empty" default:"logs/audit.jsonl"`
+	AuditLogMaxSize int64  `doc:"Size in bytes at which the audit log is rotated, 0 to never rotate it" default:"104857600"`
+	AuditLogKey     string `doc:"Secret key of the HMAC chaining the audit log entries, plain SHA-256 if empty, better set with SERVICE_AUDIT_LOG_KEY"`
+	AuditLogBodies  string `doc:"Request bodies kept in the audit log next to their digest: none, redacted with the values of the --log-redact keys replaced by [REDACTED], or full for the replay command" default:"none"`
+
+	// Logs of the server, the API callers and the chaincode run in-process
+	LogLevel  string `doc:"Level of the logs: debug, info, warn or error" default:"info"`
+	LogFormat string `doc:"Format of the logs: text or json" default:"text"`
+	LogRedact string `doc:"Comma-separated keys of the log attributes whose values are replaced by [REDACTED], such as the transaction arguments" default:"args,result,input,output,reserved"`
+
+	// Rate limits per caller and per operation, and sizes of the request bodies
+	RateLimit      int    `doc:"Requests per second of every caller, refused with 429 and a Retry-After header beyond, 0 for no limit unless --limits-config sets one" default:"0"`
+	RateLimitBurst int    `doc:"Requests a caller can send at once before --rate-limit applies, the requests of one second if 0" default:"0"`
+	MaxBodySize    int64  `doc:"Maximum size in bytes of a request body, larger ones are refused with 413" default:"4194304"`
+	LimitsConfig   string `doc:"Path to the limits file with the rate limits per principal and per operation and the body sizes per operation, rate limits reloaded on SIGHUP"`
+
+	// Prometheus metrics of the requests, the chaincode calls, the queues and the data sources
+	MetricsPath string `doc:"Path serving the Prometheus metrics, without authentication, disabled if empty" default:"/metrics"`
+
+	// OpenTelemetry traces of the requests and of the endorse, submit and commit status steps of the chaincode calls
+	Tracing       string `doc:"Exporter of the traces: none, otlp to send them to --trace-endpoint or file to append them to --trace-file" default:"none"`
+	TraceEndpoint string `doc:"URL of the OTLP gRPC collector, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317 if empty"`
+	TraceFile     string `doc:"File the spans are appended to with --tracing file, one JSON object per line" default:"logs/traces.jsonl"`
+
+	// Gateway connection, empty values keep what the config file or the defaults set
+	Config              string        `doc:"Path to a YAML or JSON gateway config file" short:"c"`
+	MSPID               string        `name:"msp-id" doc:"MSP ID of the client identity"`
+	CryptoPath          string        `doc:"Path to the organization crypto materials"`
+	CertPath            string        `doc:"Directory holding the client certificate"`
+	KeyPath             string        `doc:"Directory holding the client private key"`
+	TLSCertPath         string        `name:"tls-cert-path" doc:"Path to the TLS CA certificate of the gateway peer"`
+	PeerEndpoint        string        `doc:"gRPC endpoint of the gateway peer"`
+	GatewayPeer         string        `doc:"TLS server name of the gateway peer"`
+	ChannelName         string        `doc:"Channel name"`
+	ChaincodeName       string        `doc:"Chaincode name"`
+	EvaluateTimeout     time.Duration `doc:"Timeout for evaluate requests"`
+	EndorseTimeout      time.Duration `doc:"Timeout for endorse requests"`
+	SubmitTimeout       time.Duration `doc:"Timeout for submit requests"`
+	CommitStatusTimeout time.Duration `doc:"Timeout for commit status requests"`
+	HealthCheckInterval time.Duration `doc:"Interval between health probes of the gateway peers"`
+	WalletPath          string        `doc:"Directory of the wallet holding the identities of the API callers"`
+	AuthConfig          string        `doc:"Path to the auth config file with the API keys and JWT keys, authentication is disabled without it"`
+	PolicyFile          string        `doc:"Path to the policy file binding principals to roles, reloaded on SIGHUP"`
+	AuthzDenialsLog     string        `doc:"Path of the log of the requests the policy denies, including the reads the audit log skips, disabled if empty" default:"logs/authz_denials.log"`
+	PrintConfig         bool          `doc:"Print the resolved gateway config and exit"`
+}
 
-	// Create logs directory if it doesn't exist
-	if err := os.MkdirAll("logs", 0755); err != nil {
-		return fmt.Errorf("failed to create logs directory: %w", err)
+// openLedger sends the transactions to the ledger of the --ledger option: the peers of the validated gateway
+// config, or the chaincode run in-process against a memory or local ledger. CloseLedger closes it.
+func (o *Options) openLedger(gatewayConfig *utils.GatewayConfig) error {
+	switch o.Ledger {
+	case "fabric":
+		if err := gatewayConfig.Validate(); err != nil {
+			return err
+		}
+		if err := utils.InitGateway(gatewayConfig); err != nil {
+			return fmt.Errorf("failed to connect to the gateway: %w", err)
+		}
+	case "memory":
+		if _, err := utils.InitMemoryLedger(gatewayConfig); err != nil {
+			return fmt.Errorf("failed to start the memory ledger: %w", err)
+		}
+	case "local":
+		localLedger, err := utils.InitLocalLedger(gatewayConfig, o.LedgerDir)
+		if err != nil {
+			return fmt.Errorf("failed to restore the local ledger: %w", err)
+		}
+		slog.Info("Local ledger restored", "dir", o.LedgerDir, "block", localLedger.Height())
+	default:
+		return fmt.Errorf("unknown ledger %q, expected fabric, memory or local", o.Ledger)
 	}
+	return nil
+}
 
-	// Open log file with append mode
-	var err error
-	debugLogFile, err = os.OpenFile("logs/api_debug.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// gatewayConfig loads the gateway config file and applies the options that were set on top of it
+func (o *Options) gatewayConfig() (*utils.GatewayConfig, error) {
+	config, err := utils.LoadGatewayConfig(o.Config)
 	if err != nil {
-		return fmt.Errorf("failed to open debug log file: %w", err)
+		return nil, err
 	}
-	return nil
-}
 
-func logDebugData(operation string, data interface{}) error {
-	if !debug || debugLogFile == nil {
-		return nil
+	for _, override := range []struct {
+		value  string
+		target *string
+	}{
+		{o.MSPID, &config.MSPID},
+		{o.CryptoPath, &config.CryptoPath},
+		{o.CertPath, &config.CertPath},
+		{o.KeyPath, &config.KeyPath},
+		{o.TLSCertPath, &config.TLSCertPath},
+		{o.PeerEndpoint, &config.PeerEndpoint},
+		{o.GatewayPeer, &config.GatewayPeer},
+		{o.ChannelName, &config.ChannelName},
+		{o.ChaincodeName, &config.ChaincodeName},
+		{o.WalletPath, &config.WalletPath},
+	} {
+		if override.value != "" {
+			*override.target = override.value
+		}
 	}
 
-	logEntry := struct {
-		Timestamp string      `json:"timestamp"`
-		Operation string      `json:"operation"`
-		Data      interface{} `json:"data"`
+	for _, override := range []struct {
+		value  time.Duration
+		target *time.Duration
 	}{
-		Timestamp: time.Now().Format(time.RFC3339),
-		Operation: operation,
-		Data:      data,
+		{o.EvaluateTimeout, &config.EvaluateTimeout},
+		{o.EndorseTimeout, &config.EndorseTimeout},
+		{o.SubmitTimeout, &config.SubmitTimeout},
+		{o.CommitStatusTimeout, &config.CommitStatusTimeout},
+		{o.HealthCheckInterval, &config.HealthCheckInterval},
+	} {
+		if override.value != 0 {
+			*override.target = override.value
+		}
 	}
 
-	jsonData, err := json.Marshal(logEntry)
-	if err != nil {
-		return fmt.Errorf("failed to marshal debug data: %w", err)
+	config.Resolve()
+	return config, nil
+}
+
+// auditLog records the requests that are not reads, set when the server starts
+var auditLog *utils.AuditLog
+
+// auditBodies is how the audit log keeps the JSON request bodies, none, redacted or full, and auditRedact
+// the keys of the redacted fields, set when the server starts
+var (
+	auditBodies string
+	auditRedact map[string]bool
+)
+
+// addWalletCommands adds the commands managing the identities in the wallet
+func addWalletCommands(cli humacli.CLI) {
+	walletCmd := &cobra.Command{
+		Use:   "wallet",
+		Short: "Manage the identities in the wallet",
 	}
 
-	if _, err := debugLogFile.Write(append(jsonData, '\n')); err != nil {
-		return fmt.Errorf("failed to write to debug log: %w", err)
+	var mspID, certFile, keyFile string
+	importCmd := &cobra.Command{
+		Use:   "import <label>",
+		Short: "Import an X.509 certificate and private key into the wallet",
+		Args:  cobra.ExactArgs(1),
+		Run: humacli.WithOptions(func(cmd *cobra.Command, args []string, options *Options) {
+			wallet, err := openWallet(options)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			walletIdentity, err := utils.NewWalletIdentity(mspID, certFile, keyFile)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			if err := wallet.Put(args[0], walletIdentity); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Imported identity %s\n", args[0])
+		}),
+	}
+	importCmd.Flags().StringVar(&mspID, "msp", "Org1MSP", "MSP ID of the identity")
+	importCmd.Flags().StringVar(&certFile, "cert", "", "Path to the PEM certificate")
+	importCmd.Flags().StringVar(&keyFile, "key", "", "Path to the PEM private key")
+	importCmd.MarkFlagRequired("cert")
+	importCmd.MarkFlagRequired("key")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the identities in the wallet",
+		Args:  cobra.NoArgs,
+		Run: humacli.WithOptions(func(cmd *cobra.Command, args []string, options *Options) {
+			wallet, err := openWallet(options)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			labels, err := wallet.List()
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			for _, label := range labels {
+				fmt.Println(label)
+			}
+		}),
 	}
 
-	return nil
+	walletCmd.AddCommand(importCmd, listCmd)
+	cli.Root().AddCommand(walletCmd)
 }
 
-func main() {
-	utils.InitGateway()
+// openWallet opens the wallet set by the options or the gateway config file
+func openWallet(options *Options) (*utils.FileWallet, error) {
+	gatewayConfig, err := options.gatewayConfig()
+	if err != nil {
+		return nil, err
+	}
+	if gatewayConfig.WalletPath == "" {
+		return nil, fmt.Errorf("no wallet configured, set --wallet-path or walletPath in the config file")
+	}
+	return utils.NewFileWallet(gatewayConfig.WalletPath)
+}
 
-	// Initialize debug logging if enabled
-	if err := initDebugLog(); err != nil {
-		fmt.Printf("Warning: Failed to initialize debug logging: %v\n", err)
+// ledgerError converts an error of a ledger call into an HTTP error
+func ledgerError(err error) error {
+	if errors.Is(err, utils.ErrUnknownIdentity) || errors.Is(err, utils.ErrForbidden) {
+		return huma.Error403Forbidden(err.Error())
 	}
+	if errors.Is(err, utils.ErrNotFound) {
+		return huma.Error404NotFound(err.Error())
+	}
+	if errors.Is(err, utils.ErrTooLarge) {
+		return huma.NewError(http.StatusRequestEntityTooLarge, err.Error())
+	}
+	if errors.Is(err, utils.ErrQueuedWriteConflict) {
+		return huma.Error409Conflict(err.Error())
+	}
+	return huma.Error500InternalServerError(err.Error())
+}
 
+// fatal logs the error stopping the server and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func main() {
 	// create a huma cli app which takes a port option
 	cli := humacli.New(func(hooks humacli.Hooks, options *Options) {
 		// Create a new router & API
 		router := chi.NewMux()
-		api := humachi.New(router, huma.DefaultConfig("My API", "1.0.0"))
+		config := huma.DefaultConfig("My API", "1.0.0")
+
+		// Log with the level and the format of the options, request contexts add their request ID
+		if err := utils.SetupLogging(os.Stderr, utils.LoggingConfig{
+			Level:  options.LogLevel,
+			Format: options.LogFormat,
+			Redact: options.LogRedact,
+		}); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Authenticate every request, the principal also selects the wallet identity signing its transactions
+		var auth *utils.Authenticator
+		if options.AuthConfig != "" {
+			var err error
+			if auth, err = utils.LoadAuthenticator(options.AuthConfig); err != nil {
+				fatal("Failed to load the auth config", err)
+			}
+			configureSecurity(&config)
+		}
+
+		// Authorize every operation by the roles of the principal, unrestricted when authentication is disabled
+		policy, err := utils.LoadPolicy(options.PolicyFile, options.AuthzDenialsLog)
+		if err != nil {
+			fatal("Failed to load the policy", err)
+		}
+
+		// Limit the requests of every caller, and the request bodies of every operation
+		limiter, err := utils.LoadRateLimiter(options.LimitsConfig, utils.Limit{Rate: float64(options.RateLimit), Burst: options.RateLimitBurst})
+		if err != nil {
+			fatal("Failed to load the limits", err)
+		}
+
+		// Serve the Prometheus metrics next to the API, without authentication
+		if options.MetricsPath != "" {
+			router.Handle(options.MetricsPath, utils.MetricsHandler())
+		}
+
+		api := humachi.New(router, config)
+		api.OpenAPI().OnAddOperation = append(api.OpenAPI().OnAddOperation, limitBodySizes(limiter, options.MaxBodySize))
+		api.UseMiddleware(traceMiddleware)
+		api.UseMiddleware(requestIDMiddleware)
+		api.UseMiddleware(metricsMiddleware)
+		api.UseMiddleware(cacheControlMiddleware)
+		// limit the callers before authenticating and auditing them, so that credentials cannot be guessed
+		// at any rate and a flood of refused requests does not fill the audit log
+		api.UseMiddleware(addressRateLimitMiddleware(api, limiter, auth == nil))
+		if auth != nil {
+			api.UseMiddleware(authMiddleware(api, auth))
+			api.UseMiddleware(principalRateLimitMiddleware(api, limiter))
+		}
+		// audit the requests of known callers, including those the policy denies
+		api.UseMiddleware(auditMiddleware)
+		if auth != nil {
+			api.UseMiddleware(authzMiddleware(api, policy))
+		}
+
+		server := &http.Server{
+			Addr:              fmt.Sprintf(":%d", options.Port),
+			Handler:           router,
+			ReadHeaderTimeout: options.ReadTimeout,
+			ReadTimeout:       options.ReadTimeout,
+			WriteTimeout:      options.WriteTimeout,
+			IdleTimeout:       options.IdleTimeout,
+		}
+
+		// stops the background tasks started with the server
+		background, stopBackground := context.WithCancel(context.Background())
+		// flushes the spans not yet exported, set when the server starts
+		shutdownTracing := func(context.Context) error { return nil }
+
+		// Register GET /healthz
+		huma.Register(api, huma.Operation{
+			OperationID: "Healthz",
+			Method:      http.MethodGet,
+			Path:        "/healthz",
+			Summary:     "Liveness check",
+			Description: "Report that the server is alive, together with the state of the gateway peers",
+			Tags:        []string{"Health"},
+			Security:    publicOperation,
+		}, func(ctx context.Context, input *struct{}) (*HealthResponse, error) {
+			return &HealthResponse{Status: http.StatusOK, Body: utils.GetGatewayStatus()}, nil
+		})
+
+		// Register GET /readyz
+		huma.Register(api, huma.Operation{
+			OperationID: "Readyz",
+			Method:      http.MethodGet,
+			Path:        "/readyz",
+			Summary:     "Readiness check",
+			Description: "Report whether the active gateway peer answered the last health probe, 503 if it did not",
+			Tags:        []string{"Health"},
+			Security:    publicOperation,
+		}, func(ctx context.Context, input *struct{}) (*HealthResponse, error) {
+			resp := &HealthResponse{Status: http.StatusOK, Body: utils.GetGatewayStatus()}
+			if !resp.Body.Ready {
+				resp.Status = http.StatusServiceUnavailable
+			}
+			return resp, nil
+		})
 
 		// Register GET /init-ledger
 		huma.Register(api, huma.Operation{
@@ -131,7 +432,9 @@ func main() {
 			Description: "Init the ledger",
 			Tags:        []string{"Init"},
 		}, func(ctx context.Context, input *struct{}) (*struct{}, error) {
-			utils.InitLedgerTest()
+			if err := utils.InitLedgerTest(ctx); err != nil {
+				return nil, ledgerError(err)
+			}
 			return &struct{}{}, nil
 		})
 
@@ -145,21 +448,16 @@ func main() {
 			Tags:        []string{"Create"},
 		}, func(ctx context.Context, input *struct {
 			Body LogRecord `json:"body" doc:"Log record details"`
-		}) (*struct{}, error) {
-			if err := logDebugData("create-log-record", input.Body); err != nil {
-				fmt.Printf("Warning: Failed to log debug data: %v\n", err)
-			}
-			utils.CreateLogRecord(
-				input.Body.LogID,
-				input.Body.LoggerID,
-				input.Body.Input,
-				input.Body.InputFrom,
-				input.Body.Output,
-				input.Body.OutputTo,
-				input.Body.Timestamp,
-				input.Body.Reserved,
-			)
-			return &struct{}{}, nil
+		}) (*WriteResponse, error) {
+			queued, err := createLogRecord(ctx, policy, "CreateLogRecord", input.Body)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
+			resp := &WriteResponse{Status: http.StatusNoContent}
+			if queued {
+				resp.Status = http.StatusAccepted
+			}
+			return resp, nil
 		})
 
 		// Register POST /create-feedback-record
@@ -172,21 +470,16 @@ func main() {
 			Tags:        []string{"Create"},
 		}, func(ctx context.Context, input *struct {
 			Body LogRecord `json:"body" doc:"Log record details"`
-		}) (*struct{}, error) {
-			if err := logDebugData("create-feedback-record", input.Body); err != nil {
-				fmt.Printf("Warning: Failed to log debug data: %v\n", err)
-			}
-			utils.CreateFeedbackRecord(
-				input.Body.LogID,
-				input.Body.LoggerID,
-				input.Body.Input,
-				input.Body.InputFrom,
-				input.Body.Output,
-				input.Body.OutputTo,
-				input.Body.Timestamp,
-				input.Body.Reserved,
-			)
-			return &struct{}{}, nil
+		}) (*WriteResponse, error) {
+			queued, err := createFeedbackRecord(ctx, input.Body)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
+			resp := &WriteResponse{Status: http.StatusNoContent}
+			if queued {
+				resp.Status = http.StatusAccepted
+			}
+			return resp, nil
 		})
 
 		// Register POST /create-reliability-record
@@ -198,16 +491,11 @@ func main() {
 			Description: "Create a new reliability record",
 			Tags:        []string{"Create"},
 		}, func(ctx context.Context, input *struct {
-			Body struct {
-				DataSourceID string `json:"dataSourceID" doc:"Data source ID"`
-				Digest       string `json:"digest" doc:"Digest value"`
-				Reserved     string `json:"reserved" doc:"Reserved value"`
-			}
+			Body ReliabilityRecordInput
 		}) (*struct{}, error) {
-			if err := logDebugData("create-reliability-record", input.Body); err != nil {
-				fmt.Printf("Warning: Failed to log debug data: %v\n", err)
+			if err := createReliabilityRecord(ctx, policy, "CreateReliabilityRecord", input.Body, false); err != nil {
+				return nil, ledgerError(err)
 			}
-			utils.CreateReliabilityRecord(input.Body.DataSourceID, input.Body.Digest, input.Body.Reserved)
 			return &struct{}{}, nil
 		})
 
@@ -222,10 +510,9 @@ func main() {
 				RecordsJSON string `json:"recordsJSON" doc:"JSON string of log records"`
 			}
 		}) (*struct{}, error) {
-			if err := logDebugData("create-reliability-records-batch", input.Body); err != nil {
-				fmt.Printf("Warning: Failed to log debug data: %v\n", err)
+			if err := utils.CreateReliabilityRecordsBatch(ctx, input.Body.RecordsJSON); err != nil {
+				return nil, ledgerError(err)
 			}
-			utils.CreateReliabilityRecordsBatch(input.Body.RecordsJSON)
 			return &struct{}{}, nil
 		})
 
@@ -236,16 +523,11 @@ func main() {
 			Path:        "/create-reliability-record-async",
 			Summary:     "Create a reliability record asynchronously",
 		}, func(ctx context.Context, input *struct {
-			Body struct {
-				DataSourceID string `json:"dataSourceID" doc:"Data source ID"`
-				Digest       string `json:"digest" doc:"Digest value"`
-				Reserved     string `json:"reserved" doc:"Reserved value"`
-			}
+			Body ReliabilityRecordInput
 		}) (*struct{}, error) {
-			if err := logDebugData("create-reliability-record-async", input.Body); err != nil {
-				fmt.Printf("Warning: Failed to log debug data: %v\n", err)
+			if err := createReliabilityRecord(ctx, policy, "CreateReliabilityRecordAsync", input.Body, true); err != nil {
+				return nil, ledgerError(err)
 			}
-			utils.CreateReliabilityRecordAsync(input.Body.DataSourceID, input.Body.Digest, input.Body.Reserved)
 			return &struct{}{}, nil
 		})
 
@@ -258,10 +540,13 @@ func main() {
 			Description: "Get all log records from the ledger",
 			Tags:        []string{"Get"},
 		}, func(ctx context.Context, input *struct{}) (*LogRecordResponse, error) {
-			result := utils.GetAllLogRecords()
-			var records []LogRecord
-			if err := json.Unmarshal([]byte(result), &records); err != nil {
-				return nil, fmt.Errorf("failed to parse log records: %w", err)
+			result, err := utils.GetAllLogRecords(ctx)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
+			records, err := parseRecords(result, "log")
+			if err != nil {
+				return nil, err
 			}
 			resp := &LogRecordResponse{}
 			resp.Body.Message = fmt.Sprintf("Found %d log records", len(records))
@@ -278,10 +563,13 @@ func main() {
 			Description: "Get all reliability records from the ledger",
 			Tags:        []string{"Get"},
 		}, func(ctx context.Context, input *struct{}) (*LogRecordResponse, error) {
-			result := utils.GetAllReliabilityRecords()
-			var records []LogRecord
-			if err := json.Unmarshal([]byte(result), &records); err != nil {
-				return nil, fmt.Errorf("failed to parse reliability records: %w", err)
+			result, err := utils.GetAllReliabilityRecords(ctx)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
+			records, err := parseRecords(result, "reliability")
+			if err != nil {
+				return nil, err
 			}
 			resp := &LogRecordResponse{}
 			resp.Body.Message = fmt.Sprintf("Found %d reliability records", len(records))
@@ -298,10 +586,13 @@ func main() {
 			Description: "Get all feedback records from the ledger",
 			Tags:        []string{"Get"},
 		}, func(ctx context.Context, input *struct{}) (*LogRecordResponse, error) {
-			result := utils.GetAllFeedbackRecords()
-			var records []LogRecord
-			if err := json.Unmarshal([]byte(result), &records); err != nil {
-				return nil, fmt.Errorf("failed to parse feedback records: %w", err)
+			result, err := utils.GetAllFeedbackRecords(ctx)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
+			records, err := parseRecords(result, "feedback")
+			if err != nil {
+				return nil, err
 			}
 			resp := &LogRecordResponse{}
 			resp.Body.Message = fmt.Sprintf("Found %d feedback records", len(records))
@@ -320,14 +611,17 @@ func main() {
 		}, func(ctx context.Context, input *struct {
 			LogID string `path:"logID" doc:"Log record ID"`
 		}) (*LogRecordResponse, error) {
-			result := utils.GetLogRecord(input.LogID)
-			var record LogRecord
-			if err := json.Unmarshal([]byte(result), &record); err != nil {
-				return nil, fmt.Errorf("failed to parse log record: %w", err)
+			result, err := utils.GetLogRecord(ctx, input.LogID)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
+			record, err := parseRecord(result, "log")
+			if err != nil {
+				return nil, err
 			}
 			resp := &LogRecordResponse{}
 			resp.Body.Message = "Found log record"
-			resp.Body.Records = []LogRecord{record}
+			resp.Body.Records = []LogRecord{*record}
 			return resp, nil
 		})
 
@@ -342,14 +636,17 @@ func main() {
 		}, func(ctx context.Context, input *struct {
 			DataSourceID string `path:"dataSourceID" doc:"Data source ID"`
 		}) (*LogRecordResponse, error) {
-			result := utils.GetReliabilityRecord(input.DataSourceID)
-			var record LogRecord
-			if err := json.Unmarshal([]byte(result), &record); err != nil {
-				return nil, fmt.Errorf("failed to parse reliability record: %w", err)
+			result, err := utils.GetReliabilityRecord(ctx, input.DataSourceID)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
+			record, err := parseRecord(result, "reliability")
+			if err != nil {
+				return nil, err
 			}
 			resp := &LogRecordResponse{}
 			resp.Body.Message = "Found reliability record"
-			resp.Body.Records = []LogRecord{record}
+			resp.Body.Records = []LogRecord{*record}
 			return resp, nil
 		})
 
@@ -369,7 +666,32 @@ func main() {
 				Info             string  `json:"info" doc:"Info"`
 			}
 		}) (*struct{}, error) {
-			utils.UpdateReliabilityRecord(input.DataSourceID, input.Body.ReliabilityScore, input.Body.IsDelta, input.Body.Info)
+			if err := updateReliabilityScore(ctx, policy, "UpdateReliabilityRecord", input.DataSourceID, input.Body.ReliabilityScore, input.Body.IsDelta, input.Body.Info); err != nil {
+				return nil, ledgerError(err)
+			}
+			return &struct{}{}, nil
+		})
+
+		// Register POST /compact-reliability-records
+		huma.Register(api, huma.Operation{
+			OperationID: "CompactReliabilityRecords",
+			Method:      http.MethodPost,
+			Path:        "/compact-reliability-records",
+			Summary:     "Compact reliability records",
+			Description: "Fold the pending score deltas into the reliability records, for one data source or all of them",
+			Tags:        []string{"Update"},
+		}, func(ctx context.Context, input *struct {
+			DataSourceID string `query:"dataSourceID" doc:"Data source ID, all data sources if empty"`
+		}) (*struct{}, error) {
+			var err error
+			if input.DataSourceID != "" {
+				err = utils.CompactReliabilityRecord(ctx, input.DataSourceID)
+			} else {
+				err = utils.CompactAllReliabilityRecords(ctx)
+			}
+			if err != nil {
+				return nil, ledgerError(err)
+			}
 			return &struct{}{}, nil
 		})
 
@@ -382,14 +704,17 @@ func main() {
 		}, func(ctx context.Context, input *struct {
 			LogID string `path:"logID" doc:"Log record ID"`
 		}) (*LogRecordResponse, error) {
-			result := utils.GetFeedbackRecord(input.LogID)
-			var record LogRecord
-			if err := json.Unmarshal([]byte(result), &record); err != nil {
-				return nil, fmt.Errorf("failed to parse feedback record: %w", err)
+			result, err := utils.GetFeedbackRecord(ctx, input.LogID)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
+			record, err := parseRecord(result, "feedback")
+			if err != nil {
+				return nil, err
 			}
 			resp := &LogRecordResponse{}
 			resp.Body.Message = "Found feedback record"
-			resp.Body.Records = []LogRecord{record}
+			resp.Body.Records = []LogRecord{*record}
 			return resp, nil
 		})
 
@@ -404,7 +729,10 @@ func main() {
 				Selector string `json:"selector" doc:"Selector" default:"{\"selector\": {\"type\": \"log\"}}"`
 			}
 		}) (*LogRecordResponse, error) {
-			result := utils.GetRecordWithSelector(input.Body.Selector)
+			result, err := utils.GetRecordWithSelector(ctx, input.Body.Selector)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
 			var records []LogRecord
 			if err := json.Unmarshal([]byte(result), &records); err != nil {
 				return nil, fmt.Errorf("failed to parse records: %w", err)
@@ -426,7 +754,10 @@ func main() {
 		}, func(ctx context.Context, input *struct {
 			LogID string `path:"logID" doc:"Log record ID"`
 		}) (*LogRecordHistoryResponse, error) {
-			result := utils.GetHistoryForRecord(input.LogID)
+			result, err := utils.GetHistoryForRecord(ctx, input.LogID)
+			if err != nil {
+				return nil, ledgerError(err)
+			}
 			var history []LogRecordHistory
 			if err := json.Unmarshal([]byte(result), &history); err != nil {
 				return nil, fmt.Errorf("failed to parse record history: %w", err)
@@ -437,13 +768,151 @@ func main() {
 			return resp, nil
 		})
 
+		// Register the resource-oriented API, the routes above are kept for the existing clients
+		registerV1Routes(api, policy)
+		registerQueryRoute(api, options.QueryMaxLimit, options.QueryTimeout)
+		registerGraphRoute(api)
+		registerQueueRoutes(api)
+
 		// Start the server
 		hooks.OnStart(func() {
-			fmt.Printf("Starting server on port %d...\n", options.Port)
-			http.ListenAndServe(fmt.Sprintf(":%d", options.Port), router)
+			gatewayConfig, err := options.gatewayConfig()
+			if err != nil {
+				fatal("Failed to load the gateway config", err)
+			}
+			if options.PrintConfig {
+				fmt.Print(gatewayConfig)
+				return
+			}
+			shutdownTracing, err = utils.InitTracing(background, utils.TracingConfig{
+				Exporter: options.Tracing,
+				Endpoint: options.TraceEndpoint,
+				File:     options.TraceFile,
+			})
+			if err != nil {
+				fatal("Failed to start tracing", err)
+			}
+			if err := options.openLedger(gatewayConfig); err != nil {
+				fatal("Failed to open the ledger", err)
+			}
+			switch options.Ledger {
+			case "fabric":
+				utils.StartHealthChecks(background)
+			case "memory":
+				slog.Warn("The chaincode runs against an in-memory ledger, every record is lost when the server stops")
+			}
+			if options.WriteQueueDir != "" {
+				if err := utils.StartWriteQueue(background, options.WriteQueueDir, options.WriteQueueInterval); err != nil {
+					fatal("Failed to start the write queue", err)
+				}
+			}
+			if options.ScoreCacheTTL > 0 {
+				utils.StartScoreCache(background, options.ScoreCacheTTL)
+			}
+
+			if options.AuditLog != "" {
+				switch options.AuditLogBodies {
+				case "none", "redacted", "full":
+					auditBodies, auditRedact = options.AuditLogBodies, utils.RedactKeys(options.LogRedact)
+				default:
+					fatal("Failed to open the audit log", fmt.Errorf("unknown --audit-log-bodies %q, expected none, redacted or full", options.AuditLogBodies))
+				}
+				if auditLog, err = utils.OpenAuditLog(options.AuditLog, []byte(options.AuditLogKey), options.AuditLogMaxSize); err != nil {
+					fatal("Failed to open the audit log", err)
+				}
+			}
+
+			if options.CompactInterval > 0 {
+				utils.StartCompaction(background, options.CompactInterval)
+			}
+
+			if auth == nil {
+				slog.Warn("Authentication is disabled, set --auth-config to require API keys or JWTs")
+			}
+
+			// Reload the policy and the limits files on SIGHUP
+			reload := make(chan os.Signal, 1)
+			signal.Notify(reload, syscall.SIGHUP)
+			go func() {
+				for {
+					select {
+					case <-background.Done():
+						signal.Stop(reload)
+						return
+					case <-reload:
+						if err := policy.Reload(); err != nil {
+							slog.Warn("Failed to reload the policy, keeping the current one", "error", err)
+						} else {
+							slog.Info("Policy reloaded")
+						}
+						if err := limiter.Reload(); err != nil {
+							slog.Warn("Failed to reload the limits, keeping the current ones", "error", err)
+						} else {
+							slog.Info("Limits reloaded")
+						}
+					}
+				}
+			}()
+
+			slog.Info("Starting server", "port", options.Port)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Server failed", err)
+			}
+		})
+
+		// Stop the server, draining in-flight requests and pending commits before closing the gateway
+		hooks.OnStop(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), options.ShutdownTimeout)
+			defer cancel()
+
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Warn("Failed to drain in-flight requests", "error", err)
+			}
+			stopBackground()
+			if err := utils.WaitForPendingCommits(ctx); err != nil {
+				slog.Warn("Failed to wait for the pending commits", "error", err)
+			}
+
+			utils.CloseWriteQueue()
+			utils.CloseLedger()
+			policy.Close()
+			if auditLog != nil {
+				auditLog.Close()
+			}
+			if err := shutdownTracing(ctx); err != nil {
+				slog.Warn("Failed to export the remaining spans", "error", err)
+			}
+			slog.Info("Server stopped")
 		})
 	})
 
+	addWalletCommands(cli)
+	addGraphCommand(cli)
+	addLedgerCommands(cli)
+	addReplayCommand(cli)
+	addAuditCommands(cli)
+
 	// Run the CLI
 	cli.Run()
+	if commandFailed {
+		os.Exit(1)
+	}
+}
+
+// commandFailed is set when a command returned an error, which humacli does not turn into an exit status
+var commandFailed bool
+
+// withOptionsE returns the RunE of a command calling f with the parsed options. Its deferred calls run before
+// the command fails, unlike with os.Exit.
+func withOptionsE(f func(cmd *cobra.Command, args []string, options *Options) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		var err error
+		humacli.WithOptions(func(cmd *cobra.Command, args []string, options *Options) {
+			err = f(cmd, args, options)
+		})(cmd, args)
+		if err != nil {
+			commandFailed = true
+		}
+		return err
+	}
 }
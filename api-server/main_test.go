@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGatewayConfigFlagsOverrideEverything(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(configFile, []byte("mspID: Org2MSP\nchannelName: yamlchannel\nchaincodeName: yamlcc\nevaluateTimeout: 20s\n"), 0644); err != nil {
+		t.Fatalf("failed to write the config file: %v", err)
+	}
+	t.Setenv("CHANNEL_NAME", "envchannel")
+	t.Setenv("CHAINCODE_NAME", "envcc")
+
+	for _, test := range []struct {
+		name                              string
+		options                           Options
+		mspID, channelName, chaincodeName string
+		evaluateTimeout                   time.Duration
+	}{
+		{"no flags", Options{Config: configFile}, "Org2MSP", "envchannel", "envcc", 20 * time.Second},
+		{"flags", Options{Config: configFile, MSPID: "Org3MSP", ChannelName: "flagchannel", ChaincodeName: "flagcc", EvaluateTimeout: 30 * time.Second},
+			"Org3MSP", "flagchannel", "flagcc", 30 * time.Second},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := test.options.gatewayConfig()
+			if err != nil {
+				t.Fatalf("gatewayConfig: %v", err)
+			}
+			if config.MSPID != test.mspID || config.ChannelName != test.channelName || config.ChaincodeName != test.chaincodeName || config.EvaluateTimeout != test.evaluateTimeout {
+				t.Errorf("config = %s %s %s %v, want %s %s %s %v", config.MSPID, config.ChannelName, config.ChaincodeName, config.EvaluateTimeout,
+					test.mspID, test.channelName, test.chaincodeName, test.evaluateTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"draglog_api/utils"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// publicOperation marks an operation that needs no authentication
+var publicOperation = []map[string][]string{}
+
+// configureSecurity declares the API key and bearer token security schemes in the OpenAPI spec
+// and requires one of them on every operation that is not public
+func configureSecurity(config *huma.Config) {
+	if config.Components.SecuritySchemes == nil {
+		config.Components.SecuritySchemes = map[string]*huma.SecurityScheme{}
+	}
+	config.Components.SecuritySchemes["apiKey"] = &huma.SecurityScheme{
+		Type:        "apiKey",
+		In:          "header",
+		Name:        "X-API-Key",
+		Description: "Static API key bound to a principal in the auth config file",
+	}
+	config.Components.SecuritySchemes["bearer"] = &huma.SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description:  "HS256 or RS256 token whose subject is the principal ID and whose roles claim holds its roles",
+	}
+	config.Security = []map[string][]string{{"apiKey": {}}, {"bearer": {}}}
+}
+
+// authMiddleware resolves the principal of every request from its API key or bearer token
+// and attaches it to the request context. Public operations are let through without credentials.
+func authMiddleware(api huma.API, auth *utils.Authenticator) func(huma.Context, func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		if op := ctx.Operation(); op != nil && op.Security != nil && len(op.Security) == 0 {
+			next(ctx)
+			return
+		}
+
+		var principal *utils.Principal
+		var err error
+		if apiKey := ctx.Header("X-API-Key"); apiKey != "" {
+			principal, err = auth.AuthenticateAPIKey(apiKey)
+		} else if token, ok := strings.CutPrefix(ctx.Header("Authorization"), "Bearer "); ok {
+			principal, err = auth.AuthenticateJWT(strings.TrimSpace(token))
+		} else {
+			err = utils.ErrUnauthenticated
+		}
+
+		if err != nil {
+			ctx.SetHeader("WWW-Authenticate", `Bearer realm="draglog"`)
+			huma.WriteErr(api, ctx, http.StatusUnauthorized, "Authentication required, provide an X-API-Key header or a bearer token", err)
+			return
+		}
+
+		next(huma.WithContext(ctx, utils.WithPrincipal(ctx.Context(), principal)))
+	}
+}
+
+// cacheControlMiddleware lets a request read the latest committed state, bypassing the score cache,
+// with a Cache-Control: no-cache header
+func cacheControlMiddleware(ctx huma.Context, next func(huma.Context)) {
+	cacheControl := ctx.Header("Cache-Control")
+	if strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store") {
+		ctx = huma.WithContext(ctx, utils.WithCacheBypass(ctx.Context()))
+	}
+	next(ctx)
+}
+
+// authzMiddleware checks that the principal holds one of the roles allowed to call the operation
+func authzMiddleware(api huma.API, policy *utils.Policy) func(huma.Context, func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		op := ctx.Operation()
+		if op == nil || (op.Security != nil && len(op.Security) == 0) {
+			next(ctx)
+			return
+		}
+
+		if err := policy.AuthorizeOperation(ctx.Context(), op.OperationID); err != nil {
+			huma.WriteErr(api, ctx, http.StatusForbidden, "Operation not allowed", err)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// addressRateLimitMiddleware refuses with 429 the requests of a client address beyond its rate limit, before
+// authentication so that a caller guessing credentials is limited too. With perOperation, the limits of the
+// operations apply per address as well, for a server without authentication. The health checks are never limited.
+func addressRateLimitMiddleware(api huma.API, limiter *utils.RateLimiter, perOperation bool) func(huma.Context, func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		op := ctx.Operation()
+		if op == nil || slices.Contains(op.Tags, "Health") {
+			next(ctx)
+			return
+		}
+
+		address := ctx.RemoteAddr()
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = host
+		}
+		operationID := ""
+		if perOperation {
+			operationID = op.OperationID
+		}
+		if retryAfter, ok := limiter.AllowAddress(address, operationID); !ok {
+			writeRateLimited(api, ctx, retryAfter)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// principalRateLimitMiddleware refuses with 429 the requests of an authenticated principal beyond its rate limit
+// or the one of the operation. The requests without a principal are only limited by their address.
+func principalRateLimitMiddleware(api huma.API, limiter *utils.RateLimiter) func(huma.Context, func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		op := ctx.Operation()
+		principal := utils.PrincipalFromContext(ctx.Context())
+		if op == nil || principal == nil || slices.Contains(op.Tags, "Health") {
+			next(ctx)
+			return
+		}
+
+		if retryAfter, ok := limiter.Allow(principal.ID, op.OperationID); !ok {
+			writeRateLimited(api, ctx, retryAfter)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// limitBodySizes bounds the request body of every operation taking one to its size in the limits file,
+// defaultSize otherwise. Larger bodies are refused with 413.
+func limitBodySizes(limiter *utils.RateLimiter, defaultSize int64) func(*huma.OpenAPI, *huma.Operation) {
+	return func(oapi *huma.OpenAPI, op *huma.Operation) {
+		if op.MaxBodyBytes > 0 {
+			op.MaxBodyBytes = limiter.MaxBodySize(op.OperationID, defaultSize)
+		}
+	}
+}
+
+// writeRateLimited refuses the request with 429 and a Retry-After header in whole seconds
+func writeRateLimited(api huma.API, ctx huma.Context, retryAfter time.Duration) {
+	seconds := max(1, int(math.Ceil(retryAfter.Seconds())))
+	ctx.SetHeader("Retry-After", strconv.Itoa(seconds))
+	huma.WriteErr(api, ctx, http.StatusTooManyRequests, fmt.Sprintf("Rate limit exceeded, retry after %s", time.Duration(seconds)*time.Second))
+}
+
+// maxRequestIDLength bounds the request IDs taken from the X-Request-ID header of the callers
+const maxRequestIDLength = 128
+
+// requestIDMiddleware gives every request an ID, the one of its X-Request-ID header if set, returned in the
+// X-Request-ID response header and added to the logs of the request. Every request is logged once handled,
+// the health checks only at the debug level.
+func requestIDMiddleware(ctx huma.Context, next func(huma.Context)) {
+	requestID := ctx.Header("X-Request-ID")
+	if requestID == "" || len(requestID) > maxRequestIDLength || strings.ContainsFunc(requestID, func(r rune) bool {
+		return r < ' ' || r > '~'
+	}) {
+		requestID = utils.NewRequestID()
+	}
+	ctx.SetHeader("X-Request-ID", requestID)
+	ctx = huma.WithContext(ctx, utils.WithRequestID(ctx.Context(), requestID))
+
+	start := time.Now()
+	next(ctx)
+
+	level := slog.LevelInfo
+	operation := ""
+	if op := ctx.Operation(); op != nil {
+		operation = op.OperationID
+		if slices.Contains(op.Tags, "Health") {
+			level = slog.LevelDebug
+		}
+	}
+	slog.Log(ctx.Context(), level, "Request handled",
+		"method", ctx.Method(),
+		"path", ctx.URL().Path,
+		"operation", operation,
+		"status", ctx.Status(),
+		"duration", time.Since(start),
+		"remote", ctx.RemoteAddr(),
+	)
+}
+
+// metricsMiddleware counts the requests of every operation with their status and measures their latency
+func metricsMiddleware(ctx huma.Context, next func(huma.Context)) {
+	start := time.Now()
+	next(ctx)
+
+	operation := ""
+	if op := ctx.Operation(); op != nil {
+		operation = op.OperationID
+	}
+	utils.ObserveRequest(operation, ctx.Method(), ctx.Status(), time.Since(start))
+}
+
+// headerCarrier reads the trace context propagated by the caller from the request headers
+type headerCarrier struct {
+	ctx huma.Context
+}
+
+func (c headerCarrier) Get(key string) string {
+	return c.ctx.Header(key)
+}
+
+// Set is not used, the trace context is only extracted from the request
+func (c headerCarrier) Set(key string, value string) {}
+
+func (c headerCarrier) Keys() []string {
+	var keys []string
+	c.ctx.EachHeader(func(name string, value string) {
+		keys = append(keys, name)
+	})
+	return keys
+}
+
+// traceMiddleware runs every request in a server span, the child of the span of the caller
+// when the request carries a W3C traceparent header
+func traceMiddleware(ctx huma.Context, next func(huma.Context)) {
+	name := ctx.Method() + " " + ctx.URL().Path
+	route := ""
+	if op := ctx.Operation(); op != nil {
+		name = op.OperationID
+		route = op.Path
+	}
+
+	parent := otel.GetTextMapPropagator().Extract(ctx.Context(), headerCarrier{ctx})
+	spanCtx, span := otel.Tracer("draglog_api").Start(parent, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(ctx.Method()),
+			semconv.URLPath(ctx.URL().Path),
+			semconv.HTTPRoute(route),
+			semconv.ClientAddress(ctx.RemoteAddr()),
+		),
+	)
+	defer span.End()
+
+	next(huma.WithContext(ctx, spanCtx))
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(ctx.Status()))
+	if ctx.Status() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(ctx.Status()))
+	}
+}
+
+type (
+	humaContext huma.Context
+	// auditContext keeps the request body as the operation reads it
+	auditContext struct {
+		humaContext
+		body bytes.Buffer
+	}
+)
+
+func (c *auditContext) BodyReader() io.Reader {
+	return io.TeeReader(c.humaContext.BodyReader(), &c.body)
+}
+
+// auditMiddleware records every request that is not a read in the audit log, with its caller, the digest
+// of its body, its status and the transactions it submitted. Reads are recorded when they submit a
+// transaction, such as GET /init-ledger. A JSON body is only kept as set by auditBodies.
+func auditMiddleware(ctx huma.Context, next func(huma.Context)) {
+	if auditLog == nil {
+		next(ctx)
+		return
+	}
+
+	txCtx, txIDs := utils.WithTxIDs(ctx.Context())
+	audited := &auditContext{humaContext: huma.WithContext(ctx, txCtx)}
+	switch ctx.Method() {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		next(audited.humaContext)
+		if len(txIDs.List()) == 0 {
+			return
+		}
+	default:
+		next(audited)
+	}
+
+	body := audited.body.Bytes()
+	digest := sha256.Sum256(body)
+	entry := &utils.AuditEntry{
+		Time:          time.Now().UTC(),
+		Remote:        ctx.RemoteAddr(),
+		Method:        ctx.Method(),
+		Path:          ctx.URL().Path,
+		RequestDigest: hex.EncodeToString(digest[:]),
+		Status:        ctx.Status(),
+		TxIDs:         txIDs.List(),
+	}
+	if principal := utils.PrincipalFromContext(ctx.Context()); principal != nil {
+		entry.Caller = principal.ID
+	}
+	if op := ctx.Operation(); op != nil {
+		entry.Operation = op.OperationID
+	}
+	switch {
+	case auditBodies == "full" && json.Valid(body):
+		entry.Request = body
+	case auditBodies == "redacted":
+		if request, err := utils.RedactJSON(body, auditRedact); err == nil {
+			entry.Request = request
+		}
+	}
+	if err := auditLog.Append(entry); err != nil {
+		slog.WarnContext(ctx.Context(), "Failed to write the audit log", "error", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"draglog_api/utils"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2/humatest"
+)
+
+// newLimitedAPI registers the v1 routes behind the rate limits and body sizes of the limits file, and behind
+// API key authentication when auth is set, as the server does
+func newLimitedAPI(t *testing.T, limits string, auth *utils.AuthConfig) humatest.TestAPI {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "limits.yaml")
+	if err := os.WriteFile(path, []byte(limits), 0644); err != nil {
+		t.Fatalf("failed to write the limits file: %v", err)
+	}
+	limiter, err := utils.LoadRateLimiter(path, utils.Limit{})
+	if err != nil {
+		t.Fatalf("LoadRateLimiter: %v", err)
+	}
+
+	useMemoryLedger(t)
+	_, api := humatest.New(t)
+	api.OpenAPI().OnAddOperation = append(api.OpenAPI().OnAddOperation, limitBodySizes(limiter, 4096))
+	api.UseMiddleware(addressRateLimitMiddleware(api, limiter, auth == nil))
+	if auth != nil {
+		authenticator, err := utils.NewAuthenticator(auth)
+		if err != nil {
+			t.Fatalf("NewAuthenticator: %v", err)
+		}
+		api.UseMiddleware(authMiddleware(api, authenticator))
+		api.UseMiddleware(principalRateLimitMiddleware(api, limiter))
+	}
+	registerV1Routes(api, nil)
+	return api
+}
+
+// statuses returns the status of n GET requests of the path with the headers
+func statuses(api humatest.TestAPI, path string, n int, headers ...any) []int {
+	var codes []int
+	for range n {
+		codes = append(codes, api.Get(path, headers...).Code)
+	}
+	return codes
+}
+
+func TestRateLimitRefusesWithRetryAfter(t *testing.T) {
+	api := newLimitedAPI(t, `
+default: {rate: 0.01, burst: 2}
+`, nil)
+
+	if codes := statuses(api, "/v1/logs", 2); codes[0] != http.StatusOK || codes[1] != http.StatusOK {
+		t.Fatalf("first requests got %v, want 200", codes)
+	}
+	resp := api.Get("/v1/logs")
+	if resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request got %d, want 429", resp.Code)
+	}
+	if seconds, err := strconv.Atoi(resp.Header().Get("Retry-After")); err != nil || seconds < 99 || seconds > 100 {
+		t.Errorf("Retry-After = %q, want the 100 seconds of a token", resp.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitBeforeAuthentication(t *testing.T) {
+	auth := &utils.AuthConfig{APIKeys: []utils.APIKeyConfig{
+		{Key: "key0", ID: "default0"},
+		{Key: "key1", ID: "evaluator0"},
+	}}
+
+	t.Run("guessed keys", func(t *testing.T) {
+		api := newLimitedAPI(t, `
+default: {rate: 0.01, burst: 3}
+`, auth)
+		codes := statuses(api, "/v1/logs", 4, "X-API-Key: guess")
+		if codes[2] != http.StatusUnauthorized || codes[3] != http.StatusTooManyRequests {
+			t.Errorf("guesses got %v, want 401 until the limit of the address, then 429", codes)
+		}
+		if code := api.Get("/v1/logs", "X-API-Key: key0").Code; code != http.StatusTooManyRequests {
+			t.Errorf("the valid key got %d from the limited address, want 429", code)
+		}
+	})
+
+	t.Run("principals", func(t *testing.T) {
+		api := newLimitedAPI(t, `
+default: {rate: 0.01, burst: 2}
+addresses:
+  127.0.0.1: {rate: 0.01, burst: 100}
+`, auth)
+		if codes := statuses(api, "/v1/logs", 3, "X-API-Key: key0"); codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+			t.Errorf("default0 got %v, want 429 beyond its limit", codes)
+		}
+		// another principal behind the same address has its own bucket
+		if code := api.Get("/v1/logs", "X-API-Key: key1").Code; code != http.StatusOK {
+			t.Errorf("evaluator0 got %d, want 200", code)
+		}
+	})
+}
+
+func TestBodySizeIsLimited(t *testing.T) {
+	api := newLimitedAPI(t, `
+operations:
+  CreateLog: {maxBodySize: 512}
+`, nil)
+
+	record := LogRecord{LogID: "log1", LoggerID: "LLM0", Type: "log", Input: "in", InputFrom: "reranker0",
+		Output: strings.Repeat("a", 600), OutputTo: "user", ReliabilityScore: -1, Timestamp: "2025-01-01"}
+	if resp := api.Post("/v1/logs", record); resp.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large log got %d, want 413", resp.Code)
+	}
+	// the other operations keep the default size
+	record.LogID, record.Type = "feedback1", "feedback"
+	if resp := api.Post("/v1/feedback", record); resp.Code != http.StatusCreated {
+		t.Errorf("feedback got %d, want 201: %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestAuditKeepsBodiesAsConfigured(t *testing.T) {
+	record := LogRecord{LogID: "log1", LoggerID: "LLM0", Type: "log", Input: "secret input", InputFrom: "reranker0",
+		Output: "secret output", OutputTo: "user", ReliabilityScore: -1, Timestamp: "2025-01-01"}
+
+	for _, test := range []struct {
+		bodies string
+		want   string
+	}{
+		{"none", ""},
+		{"redacted", `"input":"[REDACTED]"`},
+		{"full", `"input":"secret input"`},
+	} {
+		t.Run(test.bodies, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			log, err := utils.OpenAuditLog(path, nil, 0)
+			if err != nil {
+				t.Fatalf("OpenAuditLog: %v", err)
+			}
+			auditLog, auditBodies, auditRedact = log, test.bodies, utils.RedactKeys("input,output,reserved")
+			t.Cleanup(func() {
+				log.Close()
+				auditLog = nil
+			})
+
+			useMemoryLedger(t)
+			_, api := humatest.New(t)
+			api.UseMiddleware(auditMiddleware)
+			registerV1Routes(api, nil)
+			if resp := api.Post("/v1/logs", record); resp.Code != http.StatusCreated {
+				t.Fatalf("POST /v1/logs: %d %s", resp.Code, resp.Body.String())
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read the audit log: %v", err)
+			}
+			entry := string(data)
+			if !strings.Contains(entry, `"requestDigest":"`) || !strings.Contains(entry, `"operation":"CreateLog"`) {
+				t.Errorf("entry %s misses the request digest or operation", entry)
+			}
+			if test.want == "" && strings.Contains(entry, `"request":`) {
+				t.Errorf("entry %s keeps the request body", entry)
+			}
+			if test.want != "" && !strings.Contains(entry, test.want) {
+				t.Errorf("entry %s misses %s", entry, test.want)
+			}
+			if test.bodies != "full" && strings.Contains(entry, "secret") {
+				t.Errorf("entry %s keeps the record contents", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"draglog_api/utils"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// recordFields are the fields of a record a query can select, sort and project on, with their JSON type
+var recordFields = map[string]string{
+	"logID":            "string",
+	"loggerID":         "string",
+	"type":             "string",
+	"input":            "string",
+	"inputFrom":        "string",
+	"output":           "string",
+	"outputTo":         "string",
+	"reliabilityScore": "number",
+	"timestamp":        "string",
+	"reserved":         "string",
+}
+
+// sortFields are the fields with a CouchDB index, the only ones a query can be sorted by
+var sortFields = []string{"logID", "loggerID", "reliabilityScore", "timestamp"}
+
+// QueryRequest is a rich query over the records
+type QueryRequest struct {
+	Selector map[string]any      `json:"selector" doc:"CouchDB selector over the record fields, for example {\"type\": \"log\", \"reliabilityScore\": {\"$lt\": 50}}"`
+	Sort     []map[string]string `json:"sort,omitempty" doc:"Fields to sort by, each as {\"field\": \"asc\"} or {\"field\": \"desc\"}, in the same direction. Sortable fields are logID, loggerID, reliabilityScore and timestamp."`
+	Fields   []string            `json:"fields,omitempty" doc:"Fields to return, every field if empty"`
+	Limit    int                 `json:"limit,omitempty" minimum:"1" default:"100" doc:"Maximum number of records to return, capped by the server"`
+	Bookmark string              `json:"bookmark,omitempty" doc:"Bookmark returned by the previous page"`
+}
+
+type QueryResponse struct {
+	Body struct {
+		Records  []map[string]any `json:"records" doc:"Matching records, with the requested fields only"`
+		Count    int              `json:"count" doc:"Number of records in this page"`
+		Bookmark string           `json:"bookmark,omitempty" doc:"Bookmark of the next page"`
+	}
+}
+
+// queryValidator collects the problems of a query
+type queryValidator struct {
+	errs []error
+}
+
+func (v *queryValidator) fail(location string, value any, format string, args ...any) {
+	v.errs = append(v.errs, &huma.ErrorDetail{Location: location, Message: fmt.Sprintf(format, args...), Value: value})
+}
+
+// validateSelector checks that a selector only uses the record fields and the supported operators
+func (v *queryValidator) validateSelector(location string, selector map[string]any) {
+	for _, key := range slices.Sorted(maps.Keys(selector)) {
+		value := selector[key]
+		switch key {
+		case "$and", "$or", "$nor":
+			selectors, ok := value.([]any)
+			if !ok || len(selectors) == 0 {
+				v.fail(location+"."+key, value, "%s expects a non-empty array of selectors", key)
+				continue
+			}
+			for i, item := range selectors {
+				sub, ok := item.(map[string]any)
+				if !ok {
+					v.fail(fmt.Sprintf("%s.%s[%d]", location, key, i), item, "expected a selector object")
+					continue
+				}
+				v.validateSelector(fmt.Sprintf("%s.%s[%d]", location, key, i), sub)
+			}
+		case "$not":
+			sub, ok := value.(map[string]any)
+			if !ok {
+				v.fail(location+"."+key, value, "$not expects a selector object")
+				continue
+			}
+			v.validateSelector(location+"."+key, sub)
+		default:
+			fieldType, ok := recordFields[key]
+			if !ok {
+				v.fail(location+"."+key, value, "unknown field or operator %s", key)
+				continue
+			}
+			v.validateCondition(location+"."+key, fieldType, value)
+		}
+	}
+}
+
+// validateCondition checks the condition on a field, either a value or an object of operators
+func (v *queryValidator) validateCondition(location string, fieldType string, condition any) {
+	operators, ok := condition.(map[string]any)
+	if !ok {
+		v.validateValue(location, fieldType, condition)
+		return
+	}
+
+	for _, operator := range slices.Sorted(maps.Keys(operators)) {
+		operand := operators[operator]
+		opLocation := location + "." + operator
+		switch operator {
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			v.validateValue(opLocation, fieldType, operand)
+		case "$in", "$nin":
+			values, ok := operand.([]any)
+			if !ok {
+				v.fail(opLocation, operand, "%s expects an array", operator)
+				continue
+			}
+			for i, value := range values {
+				v.validateValue(fmt.Sprintf("%s[%d]", opLocation, i), fieldType, value)
+			}
+		case "$exists":
+			if _, ok := operand.(bool); !ok {
+				v.fail(opLocation, operand, "$exists expects a boolean")
+			}
+		case "$regex":
+			if _, ok := operand.(string); !ok || fieldType != "string" {
+				v.fail(opLocation, operand, "$regex expects a string pattern on a string field")
+			}
+		case "$not":
+			v.validateCondition(opLocation, fieldType, operand)
+		default:
+			v.fail(opLocation, operand, "unsupported operator %s", operator)
+		}
+	}
+}
+
+// validateValue checks that a value has the type of the field
+func (v *queryValidator) validateValue(location string, fieldType string, value any) {
+	switch value.(type) {
+	case string:
+		if fieldType == "string" {
+			return
+		}
+	case float64, json.Number:
+		if fieldType == "number" {
+			return
+		}
+	}
+	v.fail(location, value, "expected a %s", fieldType)
+}
+
+// buildQuery validates the request and returns the CouchDB query string
+func buildQuery(request *QueryRequest) (string, error) {
+	v := &queryValidator{}
+	if len(request.Selector) == 0 {
+		v.fail("body.selector", request.Selector, "selector must not be empty")
+	}
+	v.validateSelector("body.selector", request.Selector)
+
+	direction := ""
+	for i, sort := range request.Sort {
+		location := fmt.Sprintf("body.sort[%d]", i)
+		if len(sort) != 1 {
+			v.fail(location, sort, "expected a single field")
+			continue
+		}
+		for field, dir := range sort {
+			if !slices.Contains(sortFields, field) {
+				v.fail(location, sort, "cannot sort by %s, sortable fields are %v", field, sortFields)
+			}
+			if dir != "asc" && dir != "desc" {
+				v.fail(location, sort, "direction must be asc or desc")
+			}
+			if direction != "" && dir != direction {
+				v.fail(location, sort, "every field must be sorted in the same direction")
+			}
+			direction = dir
+		}
+	}
+
+	for i, field := range request.Fields {
+		if _, ok := recordFields[field]; !ok {
+			v.fail(fmt.Sprintf("body.fields[%d]", i), field, "unknown field %s", field)
+		}
+	}
+
+	if len(v.errs) > 0 {
+		return "", huma.Error422UnprocessableEntity("invalid query", v.errs...)
+	}
+
+	// CouchDB only sorts with an index on the field, which is only used when the selector constrains the field
+	selector := make(map[string]any, len(request.Selector)+len(request.Sort))
+	for key, value := range request.Selector {
+		selector[key] = value
+	}
+	for _, sort := range request.Sort {
+		for field := range sort {
+			if _, ok := selector[field]; !ok {
+				selector[field] = map[string]any{"$gt": nil}
+			}
+		}
+	}
+
+	query := map[string]any{"selector": selector}
+	if len(request.Sort) > 0 {
+		query["sort"] = request.Sort
+	}
+	data, err := json.Marshal(query)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal query: %w", err)
+	}
+	return string(data), nil
+}
+
+// projectRecords keeps the requested fields of the records, every field when none is requested
+func projectRecords(records []LogRecord, fields []string) ([]map[string]any, error) {
+	projected := make([]map[string]any, 0, len(records))
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]any
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			projected = append(projected, all)
+			continue
+		}
+
+		selected := make(map[string]any, len(fields))
+		for _, field := range fields {
+			selected[field] = all[field]
+		}
+		projected = append(projected, selected)
+	}
+	return projected, nil
+}
+
+// registerQueryRoute registers POST /v1/query
+func registerQueryRoute(api huma.API, maxLimit int, timeout time.Duration) {
+	huma.Register(api, huma.Operation{
+		OperationID: "Query",
+		Method:      http.MethodPost,
+		Path:        "/v1/query",
+		Summary:     "Query records",
+		Description: fmt.Sprintf("Run a rich query over the records. At most %d records are returned per page and the query is cancelled after %s. Requires CouchDB as the state database.", maxLimit, timeout),
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct {
+		Body QueryRequest
+	}) (*QueryResponse, error) {
+		query, err := buildQuery(&input.Body)
+		if err != nil {
+			return nil, err
+		}
+		limit := min(input.Body.Limit, maxLimit)
+
+		queryCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		result, err := utils.QueryRecordsPage(queryCtx, query, limit, input.Body.Bookmark)
+		if queryCtx.Err() == context.DeadlineExceeded {
+			return nil, huma.Error504GatewayTimeout(fmt.Sprintf("query did not complete within %s", timeout))
+		}
+		if err != nil {
+			return nil, ledgerError(err)
+		}
+
+		var page struct {
+			Records             []LogRecord `json:"records"`
+			FetchedRecordsCount int         `json:"fetchedRecordsCount"`
+			Bookmark            string      `json:"bookmark"`
+		}
+		if err := json.Unmarshal([]byte(result), &page); err != nil {
+			return nil, fmt.Errorf("failed to parse query result: %w", err)
+		}
+
+		resp := &QueryResponse{}
+		if resp.Body.Records, err = projectRecords(page.Records, input.Body.Fields); err != nil {
+			return nil, fmt.Errorf("failed to project records: %w", err)
+		}
+		resp.Body.Count = len(resp.Body.Records)
+		// a page that is not full is the last one
+		if page.FetchedRecordsCount == limit {
+			resp.Body.Bookmark = page.Bookmark
+		}
+		return resp, nil
+	})
+}
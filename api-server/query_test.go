@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"draglog_api/utils"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/humatest"
+)
+
+// newQueryAPI registers the query route, returning at most maxLimit records per page, against a new
+// memory ledger holding five log records
+func newQueryAPI(t *testing.T, maxLimit int) humatest.TestAPI {
+	t.Helper()
+	useMemoryLedger(t)
+	_, api := humatest.New(t)
+	registerQueryRoute(api, maxLimit, time.Minute)
+
+	for i := range 5 {
+		timestamp := fmt.Sprintf("2025-01-0%d", i+1)
+		if _, err := utils.CreateLogRecord(context.Background(), fmt.Sprintf("log%d", i), "reranker0", "in", "source0", "out", "LLM0", timestamp, ""); err != nil {
+			t.Fatalf("CreateLogRecord: %v", err)
+		}
+	}
+	return api
+}
+
+// query posts the query and decodes the page
+func query(t *testing.T, api humatest.TestAPI, body map[string]any) QueryResponse {
+	t.Helper()
+	resp := api.Post("/v1/query", body)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("POST /v1/query %v: %d %s", body, resp.Code, resp.Body.String())
+	}
+	var page QueryResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &page.Body); err != nil {
+		t.Fatalf("failed to parse the page: %v", err)
+	}
+	return page
+}
+
+func TestQueryRejectsInvalidQueries(t *testing.T) {
+	api := newQueryAPI(t, 100)
+
+	for _, test := range []struct {
+		name     string
+		body     string
+		location string
+	}{
+		{"empty selector", `{"selector": {}}`, "body.selector"},
+		{"unknown field", `{"selector": {"color": "red"}}`, "body.selector.color"},
+		{"unknown operator", `{"selector": {"reliabilityScore": {"$near": 1}}}`, "body.selector.reliabilityScore.$near"},
+		{"unknown nested operator", `{"selector": {"$or": [{"type": "log"}, {"$where": "1"}]}}`, "body.selector.$or[1].$where"},
+		{"value of the wrong type", `{"selector": {"reliabilityScore": {"$lt": "high"}}}`, "body.selector.reliabilityScore.$lt"},
+		{"regex on a number", `{"selector": {"reliabilityScore": {"$regex": "^1"}}}`, "body.selector.reliabilityScore.$regex"},
+		{"unindexed sort field", `{"selector": {"type": "log"}, "sort": [{"input": "asc"}]}`, "body.sort[0]"},
+		{"mixed sort directions", `{"selector": {"type": "log"}, "sort": [{"logID": "asc"}, {"timestamp": "desc"}]}`, "body.sort[1]"},
+		{"unknown projected field", `{"selector": {"type": "log"}, "fields": ["logID", "color"]}`, "body.fields[1]"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var body map[string]any
+			if err := json.Unmarshal([]byte(test.body), &body); err != nil {
+				t.Fatalf("invalid test body: %v", err)
+			}
+			resp := api.Post("/v1/query", body)
+			if resp.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("POST /v1/query: %d %s, want 422", resp.Code, resp.Body.String())
+			}
+			var model huma.ErrorModel
+			if err := json.Unmarshal(resp.Body.Bytes(), &model); err != nil {
+				t.Fatalf("failed to parse the error: %v", err)
+			}
+			if !slices.ContainsFunc(model.Errors, func(detail *huma.ErrorDetail) bool { return detail.Location == test.location }) {
+				t.Errorf("errors = %s, want one at %s", resp.Body.String(), test.location)
+			}
+		})
+	}
+}
+
+func TestQueryClampsTheLimit(t *testing.T) {
+	api := newQueryAPI(t, 2)
+
+	var ids []string
+	bookmark := ""
+	for pages := 1; ; pages++ {
+		if pages > 5 {
+			t.Fatal("too many pages")
+		}
+		body := map[string]any{"selector": map[string]any{"type": "log"}, "sort": []map[string]string{{"timestamp": "desc"}}, "limit": 100}
+		if bookmark != "" {
+			body["bookmark"] = bookmark
+		}
+		page := query(t, api, body)
+		if page.Body.Count > 2 || page.Body.Count != len(page.Body.Records) {
+			t.Fatalf("page %d holds %d records, count %d, want at most the server limit of 2", pages, len(page.Body.Records), page.Body.Count)
+		}
+		for _, record := range page.Body.Records {
+			ids = append(ids, record["logID"].(string))
+		}
+		if page.Body.Bookmark == "" {
+			break
+		}
+		bookmark = page.Body.Bookmark
+	}
+	if want := []string{"log4", "log3", "log2", "log1", "log0"}; !slices.Equal(ids, want) {
+		t.Errorf("records = %v, want %v", ids, want)
+	}
+}
+
+func TestQueryProjectsFields(t *testing.T) {
+	api := newQueryAPI(t, 100)
+
+	page := query(t, api, map[string]any{"selector": map[string]any{"logID": map[string]any{"$in": []string{"log1", "log2"}}}, "fields": []string{"logID", "timestamp"}})
+	if page.Body.Count != 2 {
+		t.Fatalf("count = %d, want 2", page.Body.Count)
+	}
+	for _, record := range page.Body.Records {
+		if keys := slices.Sorted(maps.Keys(record)); !slices.Equal(keys, []string{"logID", "timestamp"}) {
+			t.Errorf("record %v has the fields %v, want logID and timestamp", record, keys)
+		}
+	}
+
+	// every field without a projection
+	page = query(t, api, map[string]any{"selector": map[string]any{"logID": "log1"}})
+	if page.Body.Count != 1 || len(page.Body.Records[0]) != len(recordFields) {
+		t.Errorf("records = %v, want log1 with every field", page.Body.Records)
+	}
+}
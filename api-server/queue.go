@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"draglog_api/utils"
+	"errors"
+	"net/http"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+type WriteQueueResponse struct {
+	Body struct {
+		Stats  utils.WriteQueueStats `json:"stats"`
+		Writes []utils.QueuedWrite   `json:"writes"`
+	}
+}
+
+// queueError maps the write queue errors to HTTP errors
+func queueError(err error) error {
+	if errors.Is(err, utils.ErrWriteQueueDisabled) || errors.Is(err, utils.ErrQueuedWriteNotFound) {
+		return huma.Error404NotFound(err.Error())
+	}
+	return huma.Error500InternalServerError(err.Error())
+}
+
+// registerQueueRoutes registers the admin routes inspecting and draining the write queue
+func registerQueueRoutes(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "GetWriteQueue",
+		Method:      http.MethodGet,
+		Path:        "/v1/admin/write-queue",
+		Summary:     "List the queued writes",
+		Description: "List the log and feedback writes accepted while the ledger was unreachable, in the order they are submitted, with the counters of the queue. Failed writes were rejected by the ledger and wait to be retried or deleted.",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct{}) (*WriteQueueResponse, error) {
+		resp := &WriteQueueResponse{}
+		resp.Body.Writes, resp.Body.Stats = utils.GetWriteQueue()
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "DrainWriteQueue",
+		Method:      http.MethodPost,
+		Path:        "/v1/admin/write-queue/drain",
+		Summary:     "Submit the queued writes now",
+		Description: "Submit the queued writes in order without waiting for the next background drain. The drain stops at the first write the ledger cannot be reached for.",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body utils.DrainResult
+	}, error) {
+		result, err := utils.DrainWriteQueue(ctx)
+		if err != nil {
+			return nil, queueError(err)
+		}
+		return &struct {
+			Body utils.DrainResult
+		}{Body: result}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "RetryQueuedWrite",
+		Method:        http.MethodPost,
+		Path:          "/v1/admin/write-queue/{seq}/retry",
+		Summary:       "Retry a failed write",
+		Description:   "Put a write rejected by the ledger back in the queue at its original position, for instance once the record it refers to exists.",
+		Tags:          []string{"v1"},
+		DefaultStatus: http.StatusNoContent,
+	}, func(ctx context.Context, input *struct {
+		Seq uint64 `path:"seq" doc:"Position of the write in the queue"`
+	}) (*struct{}, error) {
+		if err := utils.RetryQueuedWrite(input.Seq); err != nil {
+			return nil, queueError(err)
+		}
+		return nil, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "DeleteQueuedWrite",
+		Method:        http.MethodDelete,
+		Path:          "/v1/admin/write-queue/{seq}",
+		Summary:       "Drop a queued write",
+		Description:   "Remove a write from the queue, it is never submitted.",
+		Tags:          []string{"v1"},
+		DefaultStatus: http.StatusNoContent,
+	}, func(ctx context.Context, input *struct {
+		Seq uint64 `path:"seq" doc:"Position of the write in the queue"`
+	}) (*struct{}, error) {
+		if err := utils.DeleteQueuedWrite(input.Seq); err != nil {
+			return nil, queueError(err)
+		}
+		return nil, nil
+	})
+}
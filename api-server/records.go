@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"draglog_api/utils"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// The record types stored by the chaincode
+const (
+	recordTypeLog         = "log"
+	recordTypeFeedback    = "feedback"
+	recordTypeReliability = "reliability"
+)
+
+// parseRecords decodes the records returned by a ledger query
+func parseRecords(result string, kind string) ([]LogRecord, error) {
+	var records []LogRecord
+	if err := json.Unmarshal([]byte(result), &records); err != nil {
+		return nil, fmt.Errorf("failed to parse %s records: %w", kind, err)
+	}
+	return records, nil
+}
+
+// parseRecord decodes the record returned by a ledger read
+func parseRecord(result string, kind string) (*LogRecord, error) {
+	var record LogRecord
+	if err := json.Unmarshal([]byte(result), &record); err != nil {
+		return nil, fmt.Errorf("failed to parse %s record: %w", kind, err)
+	}
+	return &record, nil
+}
+
+// etag returns a strong entity tag for the JSON encoding of a response body
+func etag(body any) string {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// createLogRecord writes a log record and reports whether it was queued, a data source can only write logs in its own name
+func createLogRecord(ctx context.Context, policy *utils.Policy, operationID string, record LogRecord) (bool, error) {
+	if err := policy.AuthorizeOwner(ctx, operationID, record.LoggerID); err != nil {
+		return false, err
+	}
+	return utils.CreateLogRecord(
+		ctx,
+		record.LogID,
+		record.LoggerID,
+		record.Input,
+		record.InputFrom,
+		record.Output,
+		record.OutputTo,
+		record.Timestamp,
+		record.Reserved,
+	)
+}
+
+// createFeedbackRecord writes a feedback record and reports whether it was queued
+func createFeedbackRecord(ctx context.Context, record LogRecord) (bool, error) {
+	return utils.CreateFeedbackRecord(
+		ctx,
+		record.LogID,
+		record.LoggerID,
+		record.Input,
+		record.InputFrom,
+		record.Output,
+		record.OutputTo,
+		record.Timestamp,
+		record.Reserved,
+	)
+}
+
+// ReliabilityRecordInput is the body of the requests creating a reliability record
+type ReliabilityRecordInput struct {
+	DataSourceID string `json:"dataSourceID" doc:"Data source ID"`
+	Digest       string `json:"digest" doc:"Digest value"`
+	Reserved     string `json:"reserved" doc:"Reserved value"`
+}
+
+// createReliabilityRecord writes the reliability record of a data source, waiting for the commit unless async is set.
+// A data source can only create its own record.
+func createReliabilityRecord(ctx context.Context, policy *utils.Policy, operationID string, input ReliabilityRecordInput, async bool) error {
+	if err := policy.AuthorizeOwner(ctx, operationID, input.DataSourceID); err != nil {
+		return err
+	}
+	if async {
+		return utils.CreateReliabilityRecordAsync(ctx, input.DataSourceID, input.Digest, input.Reserved)
+	}
+	return utils.CreateReliabilityRecord(ctx, input.DataSourceID, input.Digest, input.Reserved)
+}
+
+// updateReliabilityScore applies a delta to the score of a data source or sets it, only admins can set absolute scores
+func updateReliabilityScore(ctx context.Context, policy *utils.Policy, operationID string, dataSourceID string, score float32, isDelta bool, info string) error {
+	if !isDelta {
+		if err := policy.RequireRole(ctx, operationID, "only admins can set absolute scores", utils.RoleAdmin); err != nil {
+			return err
+		}
+	}
+	return utils.UpdateReliabilityRecord(ctx, dataSourceID, score, isDelta, info)
+}
+
+// InteractionSource is a document retrieved from a data source during an interaction
+type InteractionSource struct {
+	DataSourceID string `json:"dataSourceID" minLength:"1" doc:"Data source ID"`
+	Digest       string `json:"digest" doc:"Digest of the retrieved document"`
+}
+
+// InteractionFeedback is the evaluation of the answer of an interaction
+type InteractionFeedback struct {
+	Evaluation string `json:"evaluation" doc:"Evaluation of the answer"`
+	Reserved   string `json:"reserved,omitempty" doc:"Reserved value, such as the per-source scores"`
+}
+
+// InteractionInput is a whole RAG interaction, written as one transaction
+type InteractionInput struct {
+	InteractionID  string               `json:"interactionID,omitempty" doc:"Prefix of the log IDs, the transaction ID if empty"`
+	RerankerID     string               `json:"rerankerID" minLength:"1" doc:"Re-ranker ID"`
+	LLMID          string               `json:"llmID" minLength:"1" doc:"LLM ID"`
+	UserID         string               `json:"userID,omitempty" default:"user" doc:"User ID"`
+	Sources        []InteractionSource  `json:"sources" minItems:"1" doc:"Documents retrieved from the data sources"`
+	RerankerOutput string               `json:"rerankerOutput" doc:"Re-ranked content sent to the LLM"`
+	LLMOutput      string               `json:"llmOutput" doc:"Answer of the LLM"`
+	Timestamp      string               `json:"timestamp" doc:"Timestamp of the interaction"`
+	Feedback       *InteractionFeedback `json:"feedback,omitempty" doc:"Feedback of the user, written as a feedback record"`
+}
+
+// InteractionResult lists the records written for an interaction
+type InteractionResult struct {
+	InteractionID string   `json:"interactionID" doc:"Interaction ID"`
+	LogIDs        []string `json:"logIDs" doc:"IDs of the written records, in pipeline order"`
+}
+
+// createInteraction writes every record of an interaction in one transaction. A data source can only write
+// interactions in the name of its re-ranker, and only evaluators can include the feedback.
+func createInteraction(ctx context.Context, policy *utils.Policy, operationID string, input InteractionInput) (*InteractionResult, error) {
+	if err := policy.AuthorizeOwner(ctx, operationID, input.RerankerID); err != nil {
+		return nil, err
+	}
+	if input.Feedback != nil {
+		if err := policy.RequireRole(ctx, operationID, "only evaluators can write feedback", utils.RoleEvaluator, utils.RoleAdmin); err != nil {
+			return nil, err
+		}
+	}
+
+	interactionJSON, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal interaction: %w", err)
+	}
+	result, err := utils.CreateInteraction(ctx, string(interactionJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	var created InteractionResult
+	if err := json.Unmarshal([]byte(result), &created); err != nil {
+		return nil, fmt.Errorf("failed to parse interaction result: %w", err)
+	}
+	return &created, nil
+}
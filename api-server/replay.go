@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"draglog_api/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2/humacli"
+	"github.com/spf13/cobra"
+)
+
+// The writes a replay can submit
+const (
+	replayCreateLog          = "create-log-record"
+	replayCreateFeedback     = "create-feedback-record"
+	replayCreateReliability  = "create-reliability-record"
+	replayCreateReliabilityA = "create-reliability-record-async"
+	replayCreateBatch        = "create-reliability-records-batch"
+	replayCreateInteraction  = "create-interaction"
+	replayUpdateReliability  = "update-reliability-record"
+)
+
+// replayOperations maps the operation IDs of the audit log, the operations of the former debug log and those
+// of the client log (the method and the path of the request with / replaced by _) to the write they replay
+var replayOperations = map[string]string{
+	"CreateLogRecord":                        replayCreateLog,
+	"CreateLog":                              replayCreateLog,
+	"CreateFeedbackRecord":                   replayCreateFeedback,
+	"CreateFeedback":                         replayCreateFeedback,
+	"CreateReliabilityRecord":                replayCreateReliability,
+	"CreateSource":                           replayCreateReliability,
+	"CreateReliabilityRecordAsync":           replayCreateReliabilityA,
+	"CreateReliabilityRecordsBatch":          replayCreateBatch,
+	"CreateInteraction":                      replayCreateInteraction,
+	"UpdateReliabilityRecord":                replayUpdateReliability,
+	replayCreateLog:                          replayCreateLog,
+	replayCreateFeedback:                     replayCreateFeedback,
+	replayCreateReliability:                  replayCreateReliability,
+	replayCreateReliabilityA:                 replayCreateReliabilityA,
+	replayCreateBatch:                        replayCreateBatch,
+	replayCreateInteraction:                  replayCreateInteraction,
+	"post__create-log-record":                replayCreateLog,
+	"post__create-feedback-record":           replayCreateFeedback,
+	"post__create-reliability-record":        replayCreateReliability,
+	"post__create-reliability-record-async":  replayCreateReliabilityA,
+	"post__create-reliability-records-batch": replayCreateBatch,
+	"post__v1_logs":                          replayCreateLog,
+	"post__v1_feedback":                      replayCreateFeedback,
+	"post__v1_sources":                       replayCreateReliability,
+	"post__v1_interactions":                  replayCreateInteraction,
+}
+
+// replayUpdatePrefix starts the client log operation of a score update, followed by the data source ID
+const replayUpdatePrefix = "put__update-reliability-record_"
+
+// replayLogEntry is a line of the audit log, which keeps the request body in request, of the former debug log,
+// which kept it in data, or of the client log in local mode, which keeps it in record
+type replayLogEntry struct {
+	Operation string          `json:"operation"`
+	Path      string          `json:"path"`
+	Status    int             `json:"status"`
+	Request   json.RawMessage `json:"request"`
+	Data      json.RawMessage `json:"data"`
+	Record    json.RawMessage `json:"record"`
+	TxIDs     []string        `json:"txIDs"`
+}
+
+// The outcomes of a replayed entry
+const (
+	replaySubmitted   = "submitted"
+	replayWouldSubmit = "would-submit"
+	replayExists      = "exists"
+	replaySkipped     = "skipped"
+	replayFailed      = "failed"
+)
+
+// ReplayOutcome is the outcome of an entry of a replayed log
+type ReplayOutcome struct {
+	Line      int    `json:"line"`
+	Offset    int64  `json:"offset"`
+	Operation string `json:"operation"`
+	ID        string `json:"id,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// replayer submits the writes of a log, renaming the IDs on the way
+type replayer struct {
+	// ids renames IDs wherever they appear
+	ids map[string]string
+	// prefix is prepended to the IDs of the log, feedback and interaction records that are not renamed
+	prefix string
+	dryRun bool
+	// stopOnError stops the replay at the first failed write
+	stopOnError bool
+	// throttle, if set, paces the writes
+	throttle <-chan time.Time
+}
+
+// id returns the new name of an ID
+func (r *replayer) id(id string) string {
+	if renamed, ok := r.ids[id]; ok {
+		return renamed
+	}
+	return id
+}
+
+// recordID returns the new name of the ID of a log, feedback or interaction record
+func (r *replayer) recordID(id string) string {
+	if renamed, ok := r.ids[id]; ok {
+		return renamed
+	}
+	if id == "" {
+		return id
+	}
+	return r.prefix + id
+}
+
+// replay submits a write of the log and returns the ID of the record written.
+// target is the data source ID of a score update, taken from the request path.
+func (r *replayer) replay(ctx context.Context, operation string, target string, data []byte) (string, error) {
+	switch operation {
+	case replayCreateLog, replayCreateFeedback:
+		var record LogRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return "", fmt.Errorf("failed to parse the record: %w", err)
+		}
+		record.LogID = r.recordID(record.LogID)
+		record.LoggerID, record.InputFrom, record.OutputTo = r.id(record.LoggerID), r.id(record.InputFrom), r.id(record.OutputTo)
+		if r.dryRun {
+			return record.LogID, nil
+		}
+		create := utils.CreateLogRecord
+		if operation == replayCreateFeedback {
+			create = utils.CreateFeedbackRecord
+		}
+		_, err := create(ctx, record.LogID, record.LoggerID, record.Input, record.InputFrom, record.Output, record.OutputTo, record.Timestamp, record.Reserved)
+		return record.LogID, err
+
+	case replayCreateReliability, replayCreateReliabilityA:
+		var input ReliabilityRecordInput
+		if err := json.Unmarshal(data, &input); err != nil {
+			return "", fmt.Errorf("failed to parse the reliability record: %w", err)
+		}
+		input.DataSourceID = r.id(input.DataSourceID)
+		if r.dryRun {
+			return input.DataSourceID, nil
+		}
+		// async writes are replayed synchronously so that a failed commit is reported
+		return input.DataSourceID, utils.CreateReliabilityRecord(ctx, input.DataSourceID, input.Digest, input.Reserved)
+
+	case replayCreateBatch:
+		var input struct {
+			RecordsJSON string `json:"recordsJSON"`
+		}
+		if err := json.Unmarshal(data, &input); err != nil {
+			return "", fmt.Errorf("failed to parse the batch: %w", err)
+		}
+		var records []LogRecord
+		if err := json.Unmarshal([]byte(input.RecordsJSON), &records); err != nil {
+			return "", fmt.Errorf("failed to parse the records of the batch: %w", err)
+		}
+		for i := range records {
+			// the ID of a reliability record is a data source ID, which keeps its name unless mapped
+			if records[i].Type == "reliability" {
+				records[i].LogID = r.id(records[i].LogID)
+			} else {
+				records[i].LogID = r.recordID(records[i].LogID)
+			}
+			records[i].LoggerID, records[i].InputFrom, records[i].OutputTo = r.id(records[i].LoggerID), r.id(records[i].InputFrom), r.id(records[i].OutputTo)
+		}
+		id := fmt.Sprintf("%d records", len(records))
+		if r.dryRun {
+			return id, nil
+		}
+		recordsJSON, err := json.Marshal(records)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal the records of the batch: %w", err)
+		}
+		return id, utils.CreateReliabilityRecordsBatch(ctx, string(recordsJSON))
+
+	case replayCreateInteraction:
+		var input InteractionInput
+		if err := json.Unmarshal(data, &input); err != nil {
+			return "", fmt.Errorf("failed to parse the interaction: %w", err)
+		}
+		input.InteractionID = r.recordID(input.InteractionID)
+		input.RerankerID, input.LLMID, input.UserID = r.id(input.RerankerID), r.id(input.LLMID), r.id(input.UserID)
+		if input.UserID == "" {
+			input.UserID = "user"
+		}
+		for i := range input.Sources {
+			input.Sources[i].DataSourceID = r.id(input.Sources[i].DataSourceID)
+		}
+		if r.dryRun {
+			return input.InteractionID, nil
+		}
+		interactionJSON, err := json.Marshal(input)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal the interaction: %w", err)
+		}
+		_, err = utils.CreateInteraction(ctx, string(interactionJSON))
+		return input.InteractionID, err
+
+	case replayUpdateReliability:
+		var input struct {
+			ReliabilityScore float32 `json:"reliabilityScore"`
+			IsDelta          bool    `json:"isDelta"`
+			Info             string  `json:"info"`
+		}
+		if err := json.Unmarshal(data, &input); err != nil {
+			return "", fmt.Errorf("failed to parse the score update: %w", err)
+		}
+		dataSourceID := r.id(target)
+		if r.dryRun {
+			return dataSourceID, nil
+		}
+		return dataSourceID, utils.UpdateReliabilityRecord(ctx, dataSourceID, input.ReliabilityScore, input.IsDelta, input.Info)
+	}
+	return "", fmt.Errorf("unknown operation %s", operation)
+}
+
+// checkReplayBody fails on a write whose body the audit log did not keep in full
+func checkReplayBody(data []byte) error {
+	if data == nil {
+		return errors.New("the entry keeps only the digest of the request, audit with --audit-log-bodies full to replay it")
+	}
+	if bytes.Contains(data, []byte(`"`+utils.Redacted+`"`)) {
+		return errors.New("the request was redacted, audit with --audit-log-bodies full to replay it")
+	}
+	return nil
+}
+
+// parseReplayLine returns the write of a line of an audit, debug or client log, and the operation as logged.
+// The write is empty for the entries that are not writes.
+func parseReplayLine(line []byte) (write string, operation string, target string, data []byte, err error) {
+	var entry replayLogEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to parse the entry: %w", err)
+	}
+	if entry.Status != 0 && (entry.Status < 200 || entry.Status >= 300) {
+		return "", entry.Operation, "", nil, fmt.Errorf("the request failed with status %d", entry.Status)
+	}
+	switch {
+	case entry.Request != nil:
+		data = entry.Request
+	case entry.Data != nil:
+		data = entry.Data
+	default:
+		data = entry.Record
+	}
+
+	if target, ok := strings.CutPrefix(entry.Operation, replayUpdatePrefix); ok {
+		if err := checkReplayBody(data); err != nil {
+			return "", entry.Operation, "", nil, err
+		}
+		return replayUpdateReliability, entry.Operation, target, data, nil
+	}
+	write = replayOperations[entry.Operation]
+	if write != "" {
+		if err := checkReplayBody(data); err != nil {
+			return "", entry.Operation, "", nil, err
+		}
+	}
+	switch write {
+	case replayUpdateReliability:
+		// the data source ID is the last segment of the path of the audited request
+		if target, err = url.PathUnescape(path.Base(entry.Path)); err != nil {
+			return "", entry.Operation, "", nil, fmt.Errorf("invalid path %s: %w", entry.Path, err)
+		}
+	case replayCreateInteraction:
+		if data, err = withInteractionID(data, entry.TxIDs, line); err != nil {
+			return "", entry.Operation, "", nil, err
+		}
+	}
+	return write, entry.Operation, target, data, nil
+}
+
+// withInteractionID sets the interaction ID of an interaction logged without one, so that replaying it twice
+// writes the same records. The chaincode named it after the transaction, which the audit log records, the
+// interactions of the other logs are named after the digest of their line.
+func withInteractionID(data []byte, txIDs []string, line []byte) ([]byte, error) {
+	var interaction map[string]any
+	if err := json.Unmarshal(data, &interaction); err != nil {
+		return nil, fmt.Errorf("failed to parse the interaction: %w", err)
+	}
+	if id, _ := interaction["interactionID"].(string); id != "" {
+		return data, nil
+	}
+	if len(txIDs) > 0 {
+		interaction["interactionID"] = txIDs[0]
+	} else {
+		digest := sha256.Sum256(line)
+		interaction["interactionID"] = "replay-" + hex.EncodeToString(digest[:8])
+	}
+	return json.Marshal(interaction)
+}
+
+// readReplayLog calls fn with every non-empty line of the log from the byte offset, until fn returns false
+func readReplayLog(path string, from int64, fn func(line int, offset int64, data []byte) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open the log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read the log: %w", err)
+		}
+		start := offset
+		offset += int64(len(line))
+
+		data := bytes.TrimSpace(line)
+		if lineNumber == 1 {
+			// the client starts its log with an empty JSON array, without a newline
+			data = bytes.TrimPrefix(data, []byte("[]"))
+		}
+		if start >= from && len(data) > 0 && !fn(lineNumber, start, data) {
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+// replayLog replays the log from the byte offset, reporting the outcome of every entry. It returns the offset
+// to resume from when the replay stopped early, -1 otherwise.
+func (r *replayer) replayLog(ctx context.Context, path string, offset int64, report func(ReplayOutcome)) (int64, error) {
+	resumeOffset := int64(-1)
+	err := readReplayLog(path, offset, func(line int, lineOffset int64, data []byte) bool {
+		outcome := ReplayOutcome{Line: line, Offset: lineOffset}
+		write, operation, target, body, err := parseReplayLine(data)
+		outcome.Operation = operation
+		if err != nil || write == "" {
+			outcome.Status = replaySkipped
+			if err != nil {
+				outcome.Error = err.Error()
+			} else {
+				outcome.Error = "not a write"
+			}
+			report(outcome)
+			return true
+		}
+		outcome.Operation = write
+
+		if r.throttle != nil {
+			select {
+			case <-ctx.Done():
+			case <-r.throttle:
+			}
+		}
+		if ctx.Err() != nil {
+			resumeOffset = lineOffset
+			return false
+		}
+
+		outcome.ID, err = r.replay(ctx, write, target, body)
+		switch {
+		case err == nil && r.dryRun:
+			outcome.Status = replayWouldSubmit
+		case err == nil:
+			outcome.Status = replaySubmitted
+		case strings.Contains(err.Error(), "already exists"):
+			outcome.Status = replayExists
+		default:
+			outcome.Status, outcome.Error = replayFailed, err.Error()
+		}
+		report(outcome)
+		if outcome.Status == replayFailed && r.stopOnError {
+			resumeOffset = lineOffset
+			return false
+		}
+		return true
+	})
+	return resumeOffset, err
+}
+
+// addReplayCommand adds the command submitting the writes kept in the audit log of the API server
+// or in the log of a client in local mode
+func addReplayCommand(cli humacli.CLI) {
+	var dryRun, stopOnError, jsonOutput bool
+	var rate float64
+	var offset int64
+	var caller, prefix string
+	var ids map[string]string
+	replayCmd := &cobra.Command{
+		Use:   "replay <log file>",
+		Short: "Submit the writes of the audit log or of a client log to the ledger",
+		Long: "Submit the successful writes of the API server audit log (logs/audit.jsonl and its rotated files, kept " +
+			"with --audit-log-bodies full), of " +
+			"the former logs/api_debug.log, or of the log the Python and Go clients write in local mode " +
+			"(logs/draglog.jsonl) to the Fabric network of the gateway config, in order. " +
+			"Every entry is reported with its line, its byte offset and its outcome, records already on the ledger " +
+			"are reported as exists. Interrupt the replay or use --stop-on-error and rerun it with the printed " +
+			"--offset to resume. Score deltas are applied again when replayed twice.",
+		Args: cobra.ExactArgs(1),
+		Run: humacli.WithOptions(func(cmd *cobra.Command, args []string, options *Options) {
+			if !dryRun {
+				gatewayConfig, err := options.gatewayConfig()
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				if err := gatewayConfig.Validate(); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				if err := utils.InitGateway(gatewayConfig); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				defer utils.CloseGateway()
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			ctx = utils.WithCaller(ctx, caller)
+
+			r := &replayer{ids: ids, prefix: prefix, dryRun: dryRun, stopOnError: stopOnError}
+			counts := map[string]int{}
+			if rate > 0 && !dryRun {
+				ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+				defer ticker.Stop()
+				r.throttle = ticker.C
+			}
+			report := func(outcome ReplayOutcome) {
+				counts[outcome.Status]++
+				if jsonOutput {
+					data, _ := json.Marshal(outcome)
+					fmt.Println(string(data))
+					return
+				}
+				status := outcome.Status
+				if outcome.Error != "" {
+					status += ": " + outcome.Error
+				}
+				subject := strings.TrimSpace(outcome.Operation + " " + outcome.ID)
+				if subject == "" {
+					subject = "entry"
+				}
+				fmt.Printf("line %d (offset %d) %s: %s\n", outcome.Line, outcome.Offset, subject, status)
+			}
+
+			resumeOffset, err := r.replayLog(ctx, args[0], offset, report)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if !jsonOutput {
+				fmt.Printf("%d submitted, %d would be submitted, %d already on the ledger, %d skipped, %d failed\n",
+					counts[replaySubmitted], counts[replayWouldSubmit], counts[replayExists], counts[replaySkipped], counts[replayFailed])
+			}
+			if resumeOffset >= 0 {
+				fmt.Printf("Replay stopped, rerun with --offset %d to resume\n", resumeOffset)
+			}
+			if resumeOffset >= 0 || counts[replayFailed] > 0 {
+				os.Exit(1)
+			}
+		}),
+	}
+	replayCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report the writes without submitting them")
+	replayCmd.Flags().BoolVar(&stopOnError, "stop-on-error", false, "Stop at the first failed write instead of reporting it and going on")
+	replayCmd.Flags().BoolVar(&jsonOutput, "json", false, "Report the outcomes as JSON lines")
+	replayCmd.Flags().Float64Var(&rate, "rate", 0, "Maximum number of writes submitted per second, 0 for no limit")
+	replayCmd.Flags().Int64Var(&offset, "offset", 0, "Byte offset of the first entry to replay, as reported by a previous replay")
+	replayCmd.Flags().StringVar(&caller, "caller", "", "Wallet identity submitting the writes, the gateway identity if empty")
+	replayCmd.Flags().StringVar(&prefix, "id-prefix", "", "Prefix added to the IDs of the log, feedback and interaction records")
+	replayCmd.Flags().StringToStringVar(&ids, "map-id", nil, "Rename an ID wherever it appears, as old=new, repeatable")
+
+	cli.Root().AddCommand(replayCmd)
+}
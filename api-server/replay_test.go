@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"draglog_api/utils"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReplayedInteractionKeepsItsID(t *testing.T) {
+	useMemoryLedger(t)
+	ctx := context.Background()
+	if err := utils.CreateReliabilityRecord(ctx, "source0", "digest0", ""); err != nil {
+		t.Fatalf("CreateReliabilityRecord: %v", err)
+	}
+
+	interaction := `{"rerankerID": "reranker0", "llmID": "LLM0", "sources": [{"dataSourceID": "source0", "digest": "digest0"}],` +
+		` "rerankerOutput": "context", "llmOutput": "answer", "timestamp": "2025-01-01"}`
+	for _, test := range []struct {
+		name string
+		line string
+		want string
+	}{
+		{"audit log", `{"operation": "CreateInteraction", "status": 200, "request": ` + interaction + `, "txIDs": ["tx1"]}`, "tx1"},
+		{"client log", `{"operation": "post__v1_interactions", "record": ` + interaction + `}`, "replay-"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			write, _, target, data, err := parseReplayLine([]byte(test.line))
+			if err != nil {
+				t.Fatalf("parseReplayLine: %v", err)
+			}
+			r := &replayer{}
+			id, err := r.replay(ctx, write, target, data)
+			if err != nil {
+				t.Fatalf("first replay: %v", err)
+			}
+			if !strings.HasPrefix(id, test.want) {
+				t.Errorf("interaction ID = %q, want it to start with %q", id, test.want)
+			}
+
+			// the same line gets the same ID, which the ledger already holds
+			_, _, _, data, err = parseReplayLine([]byte(test.line))
+			if err != nil {
+				t.Fatalf("parseReplayLine: %v", err)
+			}
+			again, err := r.replay(ctx, write, target, data)
+			if again != id {
+				t.Errorf("second replay wrote %q, want %q", again, id)
+			}
+			if err == nil || !strings.Contains(err.Error(), "already exists") {
+				t.Errorf("second replay: %v, want an already exists error", err)
+			}
+		})
+	}
+}
+
+func TestReplayKeepsTheLoggedInteractionID(t *testing.T) {
+	line := `{"operation": "CreateInteraction", "request": {"interactionID": "q42", "rerankerID": "reranker0"}, "txIDs": ["tx1"]}`
+	_, _, _, data, err := parseReplayLine([]byte(line))
+	if err != nil {
+		t.Fatalf("parseReplayLine: %v", err)
+	}
+	var input InteractionInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		t.Fatalf("failed to parse the interaction: %v", err)
+	}
+	if input.InteractionID != "q42" {
+		t.Errorf("interaction ID = %q, want q42", input.InteractionID)
+	}
+}
+
+func TestReplayNeedsFullBodies(t *testing.T) {
+	for _, line := range []string{
+		`{"operation": "CreateLog", "status": 201, "requestDigest": "e3b0"}`,
+		`{"operation": "CreateLog", "status": 201, "request": {"logID": "log1", "input": "[REDACTED]"}}`,
+	} {
+		if write, _, _, _, err := parseReplayLine([]byte(line)); err == nil || !strings.Contains(err.Error(), "--audit-log-bodies full") {
+			t.Errorf("parseReplayLine(%s) = %q, %v, want an error asking for full bodies", line, write, err)
+		}
+	}
+}
+
+func TestReplayMapsTheLoggedOperations(t *testing.T) {
+	for _, test := range []struct {
+		line   string
+		write  string
+		target string
+	}{
+		// audit log
+		{`{"operation": "CreateLogRecord", "status": 200, "request": {"logID": "log1"}}`, replayCreateLog, ""},
+		{`{"operation": "CreateReliabilityRecordsBatch", "status": 200, "request": {"recordsJSON": "[]"}}`, replayCreateBatch, ""},
+		{`{"operation": "UpdateReliabilityRecord", "path": "/update-reliability-record/source%2F1", "status": 200, "request": {"reliabilityScore": 0.5}}`, replayUpdateReliability, "source/1"},
+		// former debug log
+		{`{"operation": "CreateLog", "data": {"logID": "log1"}}`, replayCreateLog, ""},
+		{`{"operation": "CreateSource", "data": {"dataSourceID": "source1"}}`, replayCreateReliability, ""},
+		{`{"operation": "create-feedback-record", "data": {"logID": "feedback1"}}`, replayCreateFeedback, ""},
+		// client log
+		{`{"operation": "post__create-reliability-record-async", "record": {"dataSourceID": "source1"}}`, replayCreateReliabilityA, ""},
+		{`{"operation": "post__v1_feedback", "record": {"logID": "feedback1"}}`, replayCreateFeedback, ""},
+		{`{"operation": "put__update-reliability-record_source1", "record": {"reliabilityScore": 0.5}}`, replayUpdateReliability, "source1"},
+		// reads and failed writes are not replayed
+		{`{"operation": "GetLogRecord", "status": 200, "request": {}}`, "", ""},
+		{`{"operation": "get__v1_logs", "record": {}}`, "", ""},
+	} {
+		write, _, target, _, err := parseReplayLine([]byte(test.line))
+		if err != nil {
+			t.Errorf("parseReplayLine(%s): %v", test.line, err)
+			continue
+		}
+		if write != test.write || target != test.target {
+			t.Errorf("parseReplayLine(%s) = %q, %q, want %q, %q", test.line, write, target, test.write, test.target)
+		}
+	}
+
+	if _, _, _, _, err := parseReplayLine([]byte(`{"operation": "CreateLogRecord", "status": 409, "request": {"logID": "log1"}}`)); err == nil {
+		t.Error("parseReplayLine accepted a failed request")
+	}
+}
+
+// writeReplayLog writes the lines to a log in a temporary directory and returns its path
+func writeReplayLog(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write the log: %v", err)
+	}
+	return path
+}
+
+// replayAll replays the log from the offset and returns the outcomes and the offset to resume from
+func replayAll(t *testing.T, r *replayer, path string, offset int64) ([]ReplayOutcome, int64) {
+	t.Helper()
+	var outcomes []ReplayOutcome
+	resume, err := r.replayLog(context.Background(), path, offset, func(outcome ReplayOutcome) {
+		outcomes = append(outcomes, outcome)
+	})
+	if err != nil {
+		t.Fatalf("replayLog: %v", err)
+	}
+	return outcomes, resume
+}
+
+func TestReplayDryRunSubmitsNothing(t *testing.T) {
+	useMemoryLedger(t)
+	path := writeReplayLog(t,
+		`{"operation": "CreateSource", "data": {"dataSourceID": "source1", "digest": "digest1"}}`,
+		`{"operation": "CreateLog", "data": {"logID": "log1", "loggerID": "reranker0", "input": "query", "output": "context"}}`,
+		`{"operation": "GetLogRecord", "status": 200, "request": {}}`,
+	)
+
+	outcomes, resume := replayAll(t, &replayer{dryRun: true}, path, 0)
+	if resume != -1 {
+		t.Errorf("dry run stopped at offset %d", resume)
+	}
+	var statuses []string
+	for _, outcome := range outcomes {
+		statuses = append(statuses, outcome.Status)
+	}
+	if want := []string{replayWouldSubmit, replayWouldSubmit, replaySkipped}; !slices.Equal(statuses, want) {
+		t.Errorf("outcomes = %v, want %v", statuses, want)
+	}
+	if _, err := utils.GetReliabilityRecord(context.Background(), "source1"); err == nil {
+		t.Error("the dry run created source1")
+	}
+	if _, err := utils.GetLogRecord(context.Background(), "log1"); err == nil {
+		t.Error("the dry run created log1")
+	}
+}
+
+func TestReplayResumesFromTheOffset(t *testing.T) {
+	useMemoryLedger(t)
+	ctx := context.Background()
+	path := writeReplayLog(t,
+		`{"operation": "CreateLog", "data": {"logID": "log1", "loggerID": "reranker0", "input": "query", "output": "context"}}`,
+		`{"operation": "put__update-reliability-record_source1", "record": {"reliabilityScore": 0.5}}`,
+		`{"operation": "CreateLog", "data": {"logID": "log2", "loggerID": "reranker0", "input": "query", "output": "context"}}`,
+	)
+
+	// the score update of the unknown source fails and stops the replay
+	r := &replayer{stopOnError: true}
+	outcomes, resume := replayAll(t, r, path, 0)
+	if len(outcomes) != 2 || outcomes[1].Status != replayFailed || resume != outcomes[1].Offset {
+		t.Fatalf("replay = %+v, resume at %d, want it to stop at the failed line 2", outcomes, resume)
+	}
+	if _, err := utils.GetLogRecord(ctx, "log2"); err == nil {
+		t.Error("the replay went on after the failed write")
+	}
+
+	if err := utils.CreateReliabilityRecord(ctx, "source1", "digest1", ""); err != nil {
+		t.Fatalf("CreateReliabilityRecord: %v", err)
+	}
+	outcomes, resume = replayAll(t, r, path, resume)
+	if resume != -1 || len(outcomes) != 2 || outcomes[0].Line != 2 {
+		t.Fatalf("resumed replay = %+v, stopped at %d, want lines 2 and 3", outcomes, resume)
+	}
+	for _, outcome := range outcomes {
+		if outcome.Status != replaySubmitted {
+			t.Errorf("line %d: %s %s, want submitted", outcome.Line, outcome.Status, outcome.Error)
+		}
+	}
+	if _, err := utils.GetLogRecord(ctx, "log2"); err != nil {
+		t.Errorf("GetLogRecord(log2): %v", err)
+	}
+}
+
+func TestReplayRenamesIDs(t *testing.T) {
+	useMemoryLedger(t)
+	ctx := context.Background()
+	batch, _ := json.Marshal([]LogRecord{
+		{LogID: "source1", LoggerID: "admin", Type: "reliability", ReliabilityScore: 0.5},
+		{LogID: "log2", LoggerID: "reranker0", Type: "log", InputFrom: "source1", Output: "context"},
+	})
+	batchLine, _ := json.Marshal(map[string]any{"operation": "CreateReliabilityRecordsBatch", "status": 200, "request": map[string]string{"recordsJSON": string(batch)}})
+	path := writeReplayLog(t,
+		`{"operation": "CreateLog", "data": {"logID": "log1", "loggerID": "reranker0", "input": "query", "inputFrom": "source0", "output": "context"}}`,
+		string(batchLine),
+	)
+
+	r := &replayer{ids: map[string]string{"reranker0": "reranker1", "source1": "source2"}, prefix: "copy-"}
+	outcomes, _ := replayAll(t, r, path, 0)
+	for _, outcome := range outcomes {
+		if outcome.Status != replaySubmitted {
+			t.Fatalf("line %d: %s %s, want submitted", outcome.Line, outcome.Status, outcome.Error)
+		}
+	}
+
+	for _, test := range []struct {
+		id       string
+		loggerID string
+		from     string
+	}{
+		{"copy-log1", "reranker1", "source0"},
+		{"copy-log2", "reranker1", "source2"},
+	} {
+		recordJSON, err := utils.GetLogRecord(ctx, test.id)
+		if err != nil {
+			t.Errorf("GetLogRecord(%s): %v", test.id, err)
+			continue
+		}
+		var record LogRecord
+		if err := json.Unmarshal([]byte(recordJSON), &record); err != nil {
+			t.Fatalf("failed to parse %s: %v", test.id, err)
+		}
+		if record.LoggerID != test.loggerID || record.InputFrom != test.from {
+			t.Errorf("%s logged by %s from %s, want %s from %s", test.id, record.LoggerID, record.InputFrom, test.loggerID, test.from)
+		}
+	}
+	// the mapped data source keeps no prefix
+	if _, err := utils.GetReliabilityRecord(ctx, "source2"); err != nil {
+		t.Errorf("GetReliabilityRecord(source2): %v", err)
+	}
+	if _, err := utils.GetLogRecord(ctx, "log2"); err == nil {
+		t.Error("the batch record log2 was written without its prefix")
+	}
+}
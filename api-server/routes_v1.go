@@ -0,0 +1,641 @@
+package main
+
+import (
+	"context"
+	"draglog_api/utils"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/conditional"
+	"github.com/danielgtaylor/huma/v2/sse"
+)
+
+// OptionalParam is a query parameter that may be absent from the request
+type OptionalParam[T any] struct {
+	Value T
+	IsSet bool
+}
+
+func (o OptionalParam[T]) Schema(r huma.Registry) *huma.Schema {
+	return huma.SchemaFromType(r, reflect.TypeOf(o.Value))
+}
+
+func (o *OptionalParam[T]) Receiver() reflect.Value {
+	return reflect.ValueOf(o).Elem().Field(0)
+}
+
+func (o *OptionalParam[T]) OnParamSet(isSet bool, parsed any) {
+	o.IsSet = isSet
+}
+
+// RecordFilter selects log and feedback records by the query parameters of a list request
+type RecordFilter struct {
+	LoggerID  string `query:"loggerID" doc:"Only records written by this logger"`
+	InputFrom string `query:"inputFrom" doc:"Only records whose input comes from this component"`
+	OutputTo  string `query:"outputTo" doc:"Only records whose output goes to this component"`
+	Since     string `query:"since" doc:"Only records with a timestamp at or after this one, compared as strings"`
+	Until     string `query:"until" doc:"Only records with a timestamp before this one, compared as strings"`
+}
+
+// selector returns the CouchDB selector of the records of the type matching the filter
+func (f *RecordFilter) selector(kind string) map[string]any {
+	selector := map[string]any{"type": kind}
+	for field, value := range map[string]string{"loggerID": f.LoggerID, "inputFrom": f.InputFrom, "outputTo": f.OutputTo} {
+		if value != "" {
+			selector[field] = value
+		}
+	}
+	timestamp := map[string]any{}
+	if f.Since != "" {
+		timestamp["$gte"] = f.Since
+	}
+	if f.Until != "" {
+		timestamp["$lt"] = f.Until
+	}
+	if len(timestamp) > 0 {
+		selector["timestamp"] = timestamp
+	}
+	return selector
+}
+
+// ScoreFilter selects reliability records by score
+type ScoreFilter struct {
+	MinScore OptionalParam[float32] `query:"minScore" doc:"Only data sources with at least this score"`
+	MaxScore OptionalParam[float32] `query:"maxScore" doc:"Only data sources with at most this score"`
+}
+
+// selector returns the CouchDB selector of the reliability records matching the filter, the chaincode applies the pending deltas before comparing the scores
+func (f *ScoreFilter) selector() map[string]any {
+	selector := map[string]any{"type": recordTypeReliability}
+	score := map[string]any{}
+	if f.MinScore.IsSet {
+		score["$gte"] = f.MinScore.Value
+	}
+	if f.MaxScore.IsSet {
+		score["$lte"] = f.MaxScore.Value
+	}
+	if len(score) > 0 {
+		selector["reliabilityScore"] = score
+	}
+	return selector
+}
+
+// Page selects a window of a list
+type Page struct {
+	Limit    int    `query:"limit" minimum:"1" maximum:"1000" default:"100" doc:"Maximum number of records to return"`
+	Bookmark string `query:"bookmark" doc:"Bookmark returned by the previous page"`
+}
+
+// RecordList is a page of records
+type RecordList struct {
+	Records  []LogRecord `json:"records" doc:"Records of the page"`
+	Bookmark string      `json:"bookmark,omitempty" doc:"Bookmark of the next page, absent on the last page"`
+}
+
+type RecordListResponse struct {
+	ETag string `header:"ETag"`
+	Body RecordList
+}
+
+type RecordResponse struct {
+	ETag string `header:"ETag"`
+	Body LogRecord
+}
+
+type CreatedResponse struct {
+	Status   int
+	Location string `header:"Location"`
+}
+
+// writeStatus returns 202 for a write queued until the ledger is reachable, 201 for a committed one
+func writeStatus(queued bool) int {
+	if queued {
+		return http.StatusAccepted
+	}
+	return http.StatusCreated
+}
+
+// StreamError ends an event stream that failed
+type StreamError struct {
+	Message string `json:"message" doc:"Reason the stream ended"`
+}
+
+type InteractionResponse struct {
+	Body InteractionResult
+}
+
+// Score is the current reliability score of a data source, pending deltas included
+type Score struct {
+	DataSourceID     string  `json:"dataSourceID" doc:"Data source ID"`
+	ReliabilityScore float32 `json:"reliabilityScore" doc:"Reliability score"`
+}
+
+type ScoreResponse struct {
+	ETag string `header:"ETag"`
+	Body Score
+}
+
+// SourceScore is the score of one data source in a bulk lookup
+type SourceScore struct {
+	Status           string   `json:"status" enum:"known,unknown" doc:"Whether the data source has a reliability record"`
+	ReliabilityScore *float32 `json:"reliabilityScore,omitempty" doc:"Reliability score, pending deltas included, absent for unknown data sources"`
+}
+
+type SourceScoresResponse struct {
+	Body struct {
+		Scores map[string]SourceScore `json:"scores" doc:"Score of every requested data source, by ID"`
+	}
+}
+
+type HistoryResponse struct {
+	ETag string `header:"ETag"`
+	Body struct {
+		History []LogRecordHistory `json:"history" doc:"Changes of the record, oldest first"`
+	}
+}
+
+// LineageNode is a record of a lineage graph, Depth is its distance to the start
+type LineageNode struct {
+	Record LogRecord `json:"record" doc:"Record"`
+	Depth  int       `json:"depth" doc:"Number of edges between the record and the start"`
+}
+
+// LineageEdge links the record whose output is an input of another record
+type LineageEdge struct {
+	From string `json:"from" doc:"ID of the record whose output is the input"`
+	To   string `json:"to" doc:"ID of the record taking the input"`
+}
+
+// LineageSource is a data source whose documents are part of a lineage graph
+type LineageSource struct {
+	DataSourceID     string  `json:"dataSourceID" doc:"Data source ID"`
+	Found            bool    `json:"found" doc:"Whether the data source has a reliability record"`
+	ReliabilityScore float32 `json:"reliabilityScore" doc:"Reliability score, pending deltas included"`
+}
+
+// Lineage is the provenance graph around a record or an output digest
+type Lineage struct {
+	Start     []string        `json:"start" doc:"IDs of the records the traversal started from"`
+	Nodes     []LineageNode   `json:"nodes" doc:"Records reached, the start included"`
+	Edges     []LineageEdge   `json:"edges" doc:"Edges from the producing record to the consuming record"`
+	Sources   []LineageSource `json:"sources" doc:"Data sources whose documents were reached"`
+	Truncated bool            `json:"truncated" doc:"Whether the traversal stopped at the maximum number of records"`
+}
+
+// LineageParams are the bounds of a lineage traversal
+type LineageParams struct {
+	Direction string `query:"direction" enum:"upstream,downstream,both" default:"both" doc:"Follow the edges to the data sources, to the answers and feedback, or both"`
+	Depth     int    `query:"depth" minimum:"0" maximum:"20" default:"5" doc:"Maximum number of edges from the start"`
+}
+
+type LineageResponse struct {
+	ETag string `header:"ETag"`
+	Body Lineage
+}
+
+// readLineage walks the provenance graph from a record or an output digest
+func readLineage(ctx context.Context, recordIDOrDigest string, params LineageParams) (*Lineage, error) {
+	result, err := utils.GetLineage(ctx, recordIDOrDigest, params.Direction, params.Depth)
+	if err != nil {
+		return nil, ledgerError(err)
+	}
+
+	var lineage Lineage
+	if err := json.Unmarshal([]byte(result), &lineage); err != nil {
+		return nil, fmt.Errorf("failed to parse lineage: %w", err)
+	}
+	return &lineage, nil
+}
+
+// listRecords runs the paginated query of the selector and returns the requested page
+func listRecords(ctx context.Context, selector map[string]any, kind string, page Page) (*RecordList, error) {
+	query, err := json.Marshal(map[string]any{"selector": selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	result, err := utils.QueryRecordsPage(ctx, string(query), page.Limit, page.Bookmark)
+	if err != nil {
+		return nil, ledgerError(err)
+	}
+
+	var resultPage struct {
+		Records             []LogRecord `json:"records"`
+		FetchedRecordsCount int         `json:"fetchedRecordsCount"`
+		Bookmark            string      `json:"bookmark"`
+	}
+	if err := json.Unmarshal([]byte(result), &resultPage); err != nil {
+		return nil, fmt.Errorf("failed to parse %s records: %w", kind, err)
+	}
+
+	list := &RecordList{Records: resultPage.Records}
+	if list.Records == nil {
+		list.Records = []LogRecord{}
+	}
+	// a page that is not full is the last one, a full page may hold fewer records once the scores are filtered
+	if resultPage.FetchedRecordsCount == page.Limit {
+		list.Bookmark = resultPage.Bookmark
+	}
+	return list, nil
+}
+
+// readRecord reads a record and checks that it has the expected type, records of another type are not found
+func readRecord(ctx context.Context, kind string, id string) (*LogRecord, error) {
+	var result string
+	var err error
+	switch kind {
+	case recordTypeLog:
+		result, err = utils.GetLogRecord(ctx, id)
+	case recordTypeFeedback:
+		result, err = utils.GetFeedbackRecord(ctx, id)
+	default:
+		result, err = utils.GetReliabilityRecord(ctx, id)
+	}
+	if err != nil {
+		return nil, ledgerError(err)
+	}
+
+	record, err := parseRecord(result, kind)
+	if err != nil {
+		return nil, err
+	}
+	if record.Type != kind {
+		return nil, huma.Error404NotFound(fmt.Sprintf("no %s record %s", kind, id))
+	}
+	return record, nil
+}
+
+// registerV1Routes registers the resource-oriented API under /v1
+func registerV1Routes(api huma.API, policy *utils.Policy) {
+	// list registers the GET route listing the records of one type
+	list := func(operationID string, path string, kind string, summary string) {
+		huma.Register(api, huma.Operation{
+			OperationID: operationID,
+			Method:      http.MethodGet,
+			Path:        path,
+			Summary:     summary,
+			Tags:        []string{"v1"},
+		}, func(ctx context.Context, input *struct {
+			RecordFilter
+			Page
+			conditional.Params
+		}) (*RecordListResponse, error) {
+			list, err := listRecords(ctx, input.RecordFilter.selector(kind), kind, input.Page)
+			if err != nil {
+				return nil, err
+			}
+
+			resp := &RecordListResponse{Body: *list}
+			resp.ETag = etag(resp.Body)
+			if err := input.PreconditionFailed(resp.ETag, time.Time{}); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		})
+	}
+
+	// get registers the GET route reading one record
+	get := func(operationID string, path string, kind string, summary string) {
+		huma.Register(api, huma.Operation{
+			OperationID: operationID,
+			Method:      http.MethodGet,
+			Path:        path,
+			Summary:     summary,
+			Tags:        []string{"v1"},
+		}, func(ctx context.Context, input *struct {
+			ID string `path:"id" doc:"Record ID"`
+			conditional.Params
+		}) (*RecordResponse, error) {
+			record, err := readRecord(ctx, kind, input.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			resp := &RecordResponse{Body: *record, ETag: etag(record)}
+			if err := input.PreconditionFailed(resp.ETag, time.Time{}); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		})
+	}
+
+	list("ListLogs", "/v1/logs", recordTypeLog, "List log records")
+	get("GetLog", "/v1/logs/{id}", recordTypeLog, "Get a log record")
+	list("ListFeedback", "/v1/feedback", recordTypeFeedback, "List feedback records")
+	get("GetFeedback", "/v1/feedback/{id}", recordTypeFeedback, "Get a feedback record")
+	get("GetSource", "/v1/sources/{id}", recordTypeReliability, "Get the reliability record of a data source")
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "CreateLog",
+		Method:        http.MethodPost,
+		Path:          "/v1/logs",
+		Summary:       "Create a log record",
+		Description:   "Create a log record. With a write queue, the record is accepted with 202 while the ledger is unreachable and written once it is back.",
+		Tags:          []string{"v1"},
+		DefaultStatus: http.StatusCreated,
+	}, func(ctx context.Context, input *struct {
+		Body LogRecord
+	}) (*CreatedResponse, error) {
+		queued, err := createLogRecord(ctx, policy, "CreateLog", input.Body)
+		if err != nil {
+			return nil, ledgerError(err)
+		}
+		return &CreatedResponse{Status: writeStatus(queued), Location: "/v1/logs/" + url.PathEscape(input.Body.LogID)}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "CreateFeedback",
+		Method:        http.MethodPost,
+		Path:          "/v1/feedback",
+		Summary:       "Create a feedback record",
+		Description:   "Create a feedback record. With a write queue, the record is accepted with 202 while the ledger is unreachable and written once it is back.",
+		Tags:          []string{"v1"},
+		DefaultStatus: http.StatusCreated,
+	}, func(ctx context.Context, input *struct {
+		Body LogRecord
+	}) (*CreatedResponse, error) {
+		queued, err := createFeedbackRecord(ctx, input.Body)
+		if err != nil {
+			return nil, ledgerError(err)
+		}
+		return &CreatedResponse{Status: writeStatus(queued), Location: "/v1/feedback/" + url.PathEscape(input.Body.LogID)}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "CreateInteraction",
+		Method:        http.MethodPost,
+		Path:          "/v1/interactions",
+		Summary:       "Log a whole interaction",
+		Description:   "Write the records of every step of an interaction (data sources, re-ranker, LLM and the optional feedback) in a single transaction, so the interaction is either fully logged or not at all. The log IDs are generated as <interactionID>:<from>-<to>.",
+		Tags:          []string{"v1"},
+		DefaultStatus: http.StatusCreated,
+	}, func(ctx context.Context, input *struct {
+		Body InteractionInput
+	}) (*InteractionResponse, error) {
+		result, err := createInteraction(ctx, policy, "CreateInteraction", input.Body)
+		if err != nil {
+			return nil, ledgerError(err)
+		}
+		return &InteractionResponse{Body: *result}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "ListSources",
+		Method:      http.MethodGet,
+		Path:        "/v1/sources",
+		Summary:     "List the reliability records of the data sources",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct {
+		ScoreFilter
+		Page
+		conditional.Params
+	}) (*RecordListResponse, error) {
+		list, err := listRecords(ctx, input.ScoreFilter.selector(), recordTypeReliability, input.Page)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &RecordListResponse{Body: *list}
+		resp.ETag = etag(resp.Body)
+		if err := input.PreconditionFailed(resp.ETag, time.Time{}); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "CreateSource",
+		Method:        http.MethodPost,
+		Path:          "/v1/sources",
+		Summary:       "Create the reliability record of a data source",
+		Description:   "Create the reliability record of a data source with a score of 100. With async the request returns 202 before the transaction is committed.",
+		Tags:          []string{"v1"},
+		DefaultStatus: http.StatusCreated,
+	}, func(ctx context.Context, input *struct {
+		Async bool `query:"async" doc:"Return once the transaction is submitted, without waiting for the commit"`
+		Body  ReliabilityRecordInput
+	}) (*CreatedResponse, error) {
+		if err := createReliabilityRecord(ctx, policy, "CreateSource", input.Body, input.Async); err != nil {
+			return nil, ledgerError(err)
+		}
+		resp := &CreatedResponse{Status: http.StatusCreated, Location: "/v1/sources/" + url.PathEscape(input.Body.DataSourceID)}
+		if input.Async {
+			resp.Status = http.StatusAccepted
+		}
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetSourceScores",
+		Method:      http.MethodPost,
+		Path:        "/v1/sources/scores",
+		Summary:     "Get the reliability scores of many data sources",
+		Description: "Read the scores of a set of data sources with a single chaincode evaluate. Data sources without a reliability record are returned as unknown.",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct {
+		Body struct {
+			IDs []string `json:"ids" minItems:"1" maxItems:"1000" doc:"Data source IDs"`
+		}
+	}) (*SourceScoresResponse, error) {
+		result, err := utils.GetReliabilityScores(ctx, input.Body.IDs)
+		if err != nil {
+			return nil, ledgerError(err)
+		}
+
+		var scores []struct {
+			DataSourceID     string  `json:"dataSourceID"`
+			Found            bool    `json:"found"`
+			ReliabilityScore float32 `json:"reliabilityScore"`
+		}
+		if err := json.Unmarshal([]byte(result), &scores); err != nil {
+			return nil, fmt.Errorf("failed to parse reliability scores: %w", err)
+		}
+
+		resp := &SourceScoresResponse{}
+		resp.Body.Scores = make(map[string]SourceScore, len(scores))
+		for _, score := range scores {
+			if !score.Found {
+				resp.Body.Scores[score.DataSourceID] = SourceScore{Status: "unknown"}
+				continue
+			}
+			resp.Body.Scores[score.DataSourceID] = SourceScore{Status: "known", ReliabilityScore: &score.ReliabilityScore}
+		}
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetSourceScore",
+		Method:      http.MethodGet,
+		Path:        "/v1/sources/{id}/score",
+		Summary:     "Get the reliability score of a data source",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct {
+		ID string `path:"id" doc:"Data source ID"`
+		conditional.Params
+	}) (*ScoreResponse, error) {
+		record, err := readRecord(ctx, recordTypeReliability, input.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &ScoreResponse{Body: Score{DataSourceID: record.LogID, ReliabilityScore: record.ReliabilityScore}}
+		resp.ETag = etag(resp.Body)
+		if err := input.PreconditionFailed(resp.ETag, time.Time{}); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "SetSourceScore",
+		Method:      http.MethodPut,
+		Path:        "/v1/sources/{id}/score",
+		Summary:     "Set the reliability score of a data source",
+		Description: "Set the absolute reliability score of a data source. Send the ETag of the score in If-Match to only overwrite the score that was read.",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct {
+		ID string `path:"id" doc:"Data source ID"`
+		conditional.Params
+		Body struct {
+			ReliabilityScore float32 `json:"reliabilityScore" doc:"New reliability score"`
+			Info             string  `json:"info,omitempty" doc:"Reason of the change"`
+		}
+	}) (*struct{}, error) {
+		if input.HasConditionalParams() {
+			record, err := readRecord(ctx, recordTypeReliability, input.ID)
+			if err != nil {
+				return nil, err
+			}
+			current := Score{DataSourceID: record.LogID, ReliabilityScore: record.ReliabilityScore}
+			if err := input.PreconditionFailed(etag(current), time.Time{}); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := updateReliabilityScore(ctx, policy, "SetSourceScore", input.ID, input.Body.ReliabilityScore, false, input.Body.Info); err != nil {
+			return nil, ledgerError(err)
+		}
+		return &struct{}{}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "AddSourceScoreDelta",
+		Method:      http.MethodPost,
+		Path:        "/v1/sources/{id}/score/deltas",
+		Summary:     "Apply a delta to the reliability score of a data source",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct {
+		ID   string `path:"id" doc:"Data source ID"`
+		Body struct {
+			Delta float32 `json:"delta" doc:"Amount added to the reliability score, negative to lower it"`
+			Info  string  `json:"info,omitempty" doc:"Reason of the change"`
+		}
+	}) (*struct{}, error) {
+		if err := updateReliabilityScore(ctx, policy, "AddSourceScoreDelta", input.ID, input.Body.Delta, true, input.Body.Info); err != nil {
+			return nil, ledgerError(err)
+		}
+		return &struct{}{}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetCacheStats",
+		Method:      http.MethodGet,
+		Path:        "/v1/cache/stats",
+		Summary:     "Get the score cache counters",
+		Description: "Report the hits, misses and bypasses of the reliability record cache. Reads send Cache-Control: no-cache to bypass it.",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body utils.ScoreCacheStats
+	}, error) {
+		return &struct {
+			Body utils.ScoreCacheStats
+		}{Body: utils.GetScoreCacheStats()}, nil
+	})
+
+	sse.Register(api, huma.Operation{
+		OperationID: "StreamEvents",
+		Method:      http.MethodGet,
+		Path:        "/v1/events",
+		Summary:     "Stream the chaincode events",
+		Description: "Stream the events of the committed transactions as server-sent events, such as the ReliabilityScoreChanged events. The event ID is the block number, pass it in startBlock to resume a stream.",
+		Tags:        []string{"v1"},
+	}, map[string]any{
+		"chaincodeEvent": utils.ChaincodeEvent{},
+		"streamError":    StreamError{},
+	}, func(ctx context.Context, input *struct {
+		StartBlock OptionalParam[uint64] `query:"startBlock" doc:"Block to replay the events from, the next committed block if absent"`
+	}, send sse.Sender) {
+		var startBlock *uint64
+		if input.StartBlock.IsSet {
+			startBlock = &input.StartBlock.Value
+		}
+		events, err := utils.ChaincodeEvents(ctx, startBlock)
+		if err != nil {
+			send.Data(StreamError{Message: err.Error()})
+			return
+		}
+		for event := range events {
+			if err := send(sse.Message{ID: int(event.BlockNumber), Data: event}); err != nil {
+				return
+			}
+		}
+		if ctx.Err() == nil {
+			send.Data(StreamError{Message: "chaincode event stream closed"})
+		}
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetRecordHistory",
+		Method:      http.MethodGet,
+		Path:        "/v1/records/{id}/history",
+		Summary:     "Get the history of a record",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct {
+		ID string `path:"id" doc:"Record ID"`
+		conditional.Params
+	}) (*HistoryResponse, error) {
+		result, err := utils.GetHistoryForRecord(ctx, input.ID)
+		if err != nil {
+			return nil, ledgerError(err)
+		}
+
+		resp := &HistoryResponse{}
+		if err := json.Unmarshal([]byte(result), &resp.Body.History); err != nil {
+			return nil, fmt.Errorf("failed to parse record history: %w", err)
+		}
+		resp.ETag = etag(resp.Body)
+		if err := input.PreconditionFailed(resp.ETag, time.Time{}); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "GetRecordLineage",
+		Method:      http.MethodGet,
+		Path:        "/v1/records/{id}/lineage",
+		Summary:     "Get the provenance graph of a record",
+		Description: "Walk the inputFrom/outputTo edges from a record, or from the records with the given output digest, upstream to the data sources, downstream to the answers and feedback it influenced, or both. Each record is visited once and at most 1000 records are returned.",
+		Tags:        []string{"v1"},
+	}, func(ctx context.Context, input *struct {
+		ID string `path:"id" doc:"Record ID or output digest"`
+		LineageParams
+		conditional.Params
+	}) (*LineageResponse, error) {
+		lineage, err := readLineage(ctx, input.ID, input.LineageParams)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &LineageResponse{Body: *lineage}
+		resp.ETag = etag(resp.Body)
+		if err := input.PreconditionFailed(resp.ETag, time.Time{}); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"draglog_api/utils"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2/humatest"
+)
+
+// useMemoryLedger sends the transactions of the test to a new memory ledger
+func useMemoryLedger(t *testing.T) {
+	t.Helper()
+	if _, err := utils.InitMemoryLedger(utils.DefaultGatewayConfig()); err != nil {
+		t.Fatalf("InitMemoryLedger: %v", err)
+	}
+	t.Cleanup(utils.CloseLedger)
+}
+
+// newTestAPI registers the v1 routes, without authentication, against a new memory ledger
+func newTestAPI(t *testing.T) humatest.TestAPI {
+	t.Helper()
+	useMemoryLedger(t)
+	_, api := humatest.New(t)
+	registerV1Routes(api, nil)
+	return api
+}
+
+// listAll follows the bookmarks of a list and returns the IDs of every record
+func listAll(t *testing.T, api humatest.TestAPI, path string) []string {
+	t.Helper()
+	var ids []string
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("%s: too many pages", path)
+		}
+		url := path
+		if bookmark != "" {
+			url += "&bookmark=" + bookmark
+		}
+		resp := api.Get(url)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", url, resp.Code, resp.Body.String())
+		}
+		var list RecordList
+		if err := json.Unmarshal(resp.Body.Bytes(), &list); err != nil {
+			t.Fatalf("failed to parse the list: %v", err)
+		}
+		for _, record := range list.Records {
+			ids = append(ids, record.LogID)
+		}
+		if list.Bookmark == "" {
+			return ids
+		}
+		bookmark = list.Bookmark
+	}
+}
+
+func TestListLogsFiltersOnTheLedger(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	for i := range 5 {
+		loggerID := "reranker0"
+		if i%2 == 1 {
+			loggerID = "LLM0"
+		}
+		timestamp := fmt.Sprintf("2025-01-0%d", i+1)
+		if _, err := utils.CreateLogRecord(ctx, fmt.Sprintf("log%d", i), loggerID, "in", "source0", "out", "user", timestamp, ""); err != nil {
+			t.Fatalf("CreateLogRecord: %v", err)
+		}
+	}
+	if _, err := utils.CreateFeedbackRecord(ctx, "feedback0", "user", "in", "LLM0", "ok", "reranker0", "2025-01-02", ""); err != nil {
+		t.Fatalf("CreateFeedbackRecord: %v", err)
+	}
+
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{"limit=2", []string{"log0", "log1", "log2", "log3", "log4"}},
+		{"limit=2&loggerID=reranker0", []string{"log0", "log2", "log4"}},
+		{"limit=1&since=2025-01-02&until=2025-01-04", []string{"log1", "log2"}},
+		{"limit=2&loggerID=LLM0&since=2025-01-03", []string{"log3"}},
+		{"limit=2&outputTo=nobody", nil},
+	} {
+		ids := listAll(t, api, "/v1/logs?"+test.query)
+		slices.Sort(ids)
+		if !slices.Equal(ids, test.want) {
+			t.Errorf("/v1/logs?%s = %v, want %v", test.query, ids, test.want)
+		}
+	}
+	if ids := listAll(t, api, "/v1/feedback?limit=10"); !slices.Equal(ids, []string{"feedback0"}) {
+		t.Errorf("/v1/feedback = %v, want [feedback0]", ids)
+	}
+}
+
+func TestListSourcesSeesPendingDeltas(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	for i := range 4 {
+		if err := utils.CreateReliabilityRecord(ctx, fmt.Sprintf("source%d", i), "digest", ""); err != nil {
+			t.Fatalf("CreateReliabilityRecord: %v", err)
+		}
+	}
+	// the deltas are pending, the reliability records still hold a score of 100
+	for _, id := range []string{"source1", "source3"} {
+		if err := utils.UpdateReliabilityRecord(ctx, id, -60, true, "feedback"); err != nil {
+			t.Fatalf("UpdateReliabilityRecord: %v", err)
+		}
+	}
+
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{"limit=3", []string{"source0", "source1", "source2", "source3"}},
+		{"limit=1&maxScore=50", []string{"source1", "source3"}},
+		{"limit=2&minScore=50", []string{"source0", "source2"}},
+		{"limit=2&minScore=40&maxScore=40", []string{"source1", "source3"}},
+	} {
+		ids := listAll(t, api, "/v1/sources?"+test.query)
+		slices.Sort(ids)
+		if !slices.Equal(ids, test.want) {
+			t.Errorf("/v1/sources?%s = %v, want %v", test.query, ids, test.want)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package utils
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a request recorded in the audit log, one JSON line. Hash covers the entry with an empty Hash,
+// PrevHash is the hash of the previous entry across the rotated files, so editing, removing or reordering
+// entries breaks the chain.
+type AuditEntry struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Caller    string    `json:"caller,omitempty"`
+	Remote    string    `json:"remote,omitempty"`
+	Operation string    `json:"operation"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	// RequestDigest is the SHA-256 of the request body
+	RequestDigest string `json:"requestDigest"`
+	// Request is the request body when it is JSON and the server keeps the bodies, possibly redacted
+	Request json.RawMessage `json:"request,omitempty"`
+	Status  int             `json:"status"`
+	// TxIDs are the transactions the request submitted
+	TxIDs    []string `json:"txIDs,omitempty"`
+	PrevHash string   `json:"prevHash"`
+	Hash     string   `json:"hash,omitempty"`
+}
+
+// computeHash returns the hash of the entry with an empty Hash, an HMAC when a key is set
+func (e AuditEntry) computeHash(key []byte) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit entry %d: %w", e.Seq, err)
+	}
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// auditHead is the last entry of the audit log, kept next to it to detect a truncated log
+type auditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// ErrAuditLogCorrupted is returned when the audit log cannot be parsed, its hash chain is broken or it was truncated
+var ErrAuditLogCorrupted = errors.New("audit log is corrupted")
+
+// AuditLog appends the entries to the audit log file, rotating it when it grows past the maximum size.
+// The rotated files are named after the sequence number of their first entry, such as audit.jsonl.00000001.
+type AuditLog struct {
+	mu       sync.Mutex
+	path     string
+	key      []byte
+	maxSize  int64
+	file     *os.File
+	size     int64
+	firstSeq uint64
+	head     auditHead
+}
+
+// OpenAuditLog opens the audit log to append to it, continuing the hash chain of the existing entries.
+// An incomplete last line left by a crash is removed. maxSize is the size in bytes the file is rotated at,
+// 0 to never rotate it.
+func OpenAuditLog(path string, key []byte, maxSize int64) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the audit log directory: %w", err)
+	}
+
+	a := &AuditLog{path: path, key: key, maxSize: maxSize}
+	head, err := readAuditHead(path)
+	if err != nil {
+		return nil, err
+	}
+	if head != nil {
+		a.head = *head
+	}
+
+	var first, last *AuditEntry
+	size, err := readAuditFile(path, func(entry *AuditEntry) error {
+		if first == nil {
+			first = entry
+		}
+		last = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > size {
+		slog.Warn("Dropping the incomplete last entry of the audit log", "path", path)
+		if err := os.Truncate(path, size); err != nil {
+			return nil, fmt.Errorf("failed to truncate the audit log: %w", err)
+		}
+	}
+
+	// the head lags behind the log when the server stopped between the two writes
+	if last != nil {
+		if last.Seq >= a.head.Seq {
+			a.head = auditHead{Seq: last.Seq, Hash: last.Hash}
+		} else {
+			slog.Warn("The audit log ends before the entry recorded by its head, run audit verify", "path", path, "lastSeq", last.Seq, "headSeq", a.head.Seq)
+		}
+	}
+	a.firstSeq = a.head.Seq + 1
+	if first != nil {
+		a.firstSeq = first.Seq
+	}
+
+	if a.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+		return nil, fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	a.size = size
+	return a, nil
+}
+
+// Append chains the entry to the previous one and writes it to disk, setting its sequence number and hash
+func (a *AuditLog) Append(entry *AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return fmt.Errorf("the audit log is closed")
+	}
+
+	entry.Seq = a.head.Seq + 1
+	entry.PrevHash = a.head.Hash
+	hash, err := entry.computeHash(a.key)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry %d: %w", entry.Seq, err)
+	}
+	data = append(data, '\n')
+
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(data)) > a.maxSize {
+		if err := a.rotate(entry.Seq); err != nil {
+			return err
+		}
+	}
+	if _, err := a.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write audit entry %d: %w", entry.Seq, err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync the audit log: %w", err)
+	}
+	a.size += int64(len(data))
+	a.head = auditHead{Seq: entry.Seq, Hash: entry.Hash}
+	return writeAuditHead(a.path, a.head)
+}
+
+// rotate renames the current file after its first entry and starts a new one with the entry seq, a.mu must be held
+func (a *AuditLog) rotate(seq uint64) error {
+	if err := a.file.Close(); err != nil {
+		return fmt.Errorf("failed to close the audit log: %w", err)
+	}
+	a.file = nil
+	if err := os.Rename(a.path, fmt.Sprintf("%s.%08d", a.path, a.firstSeq)); err != nil {
+		return fmt.Errorf("failed to rotate the audit log: %w", err)
+	}
+	file, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	a.file, a.size, a.firstSeq = file, 0, seq
+	return nil
+}
+
+// Close closes the audit log file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// readAuditHead reads the head file of the audit log, nil if there is none
+func readAuditHead(path string) (*auditHead, error) {
+	data, err := os.ReadFile(path + ".head")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the audit log head: %w", err)
+	}
+	var head auditHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse the head: %v", ErrAuditLogCorrupted, err)
+	}
+	return &head, nil
+}
+
+// writeAuditHead replaces the head file of the audit log
+func writeAuditHead(path string, head auditHead) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the audit log head: %w", err)
+	}
+	tmpPath := path + ".head.tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write the audit log head: %w", err)
+	}
+	if err := os.Rename(tmpPath, path+".head"); err != nil {
+		return fmt.Errorf("failed to write the audit log head: %w", err)
+	}
+	return nil
+}
+
+// readAuditFile calls fn for every entry of a file of the audit log and returns the size of its complete lines.
+// An incomplete last line is not returned.
+func readAuditFile(path string, fn func(entry *AuditEntry) error) (int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var size int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		}
+		if err != nil {
+			return size, fmt.Errorf("failed to read the audit log: %w", err)
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return size, fmt.Errorf("%w: %s at byte %d: %v", ErrAuditLogCorrupted, filepath.Base(path), size, err)
+		}
+		if err := fn(&entry); err != nil {
+			return size, err
+		}
+		size += int64(len(line))
+	}
+}
+
+// AuditLogFiles returns the rotated files of the audit log, oldest first, followed by the current file
+func AuditLogFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	type rotated struct {
+		path     string
+		firstSeq uint64
+	}
+	var files []rotated
+	for _, match := range matches {
+		if firstSeq, err := strconv.ParseUint(strings.TrimPrefix(match, path+"."), 10, 64); err == nil {
+			files = append(files, rotated{match, firstSeq})
+		}
+	}
+	slices.SortFunc(files, func(a, b rotated) int { return cmp.Compare(a.firstSeq, b.firstSeq) })
+
+	paths := make([]string, 0, len(files)+1)
+	for _, file := range files {
+		paths = append(paths, file.path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// AuditVerification is the result of a successful check of the audit log
+type AuditVerification struct {
+	Files    int
+	Entries  uint64
+	FirstSeq uint64
+	LastSeq  uint64
+	LastHash string
+}
+
+// AuditStart anchors the verification of an audit log whose oldest rotated files were deleted. Seq is the
+// sequence number of the oldest retained entry and PrevHash the hash of the entry before it, such as the
+// last entry and hash reported by an earlier verification. A zero Seq or an empty PrevHash trusts the
+// first retained entry instead.
+type AuditStart struct {
+	Seq      uint64
+	PrevHash string
+}
+
+// VerifyAuditLog checks the hash chain of the audit log across its rotated files, the sequence numbers, and that
+// the log still ends at the entry recorded in its head. The key must be the one the entries were written with.
+// The log must start at entry 1 when start is nil, and at the start otherwise.
+func VerifyAuditLog(path string, key []byte, start *AuditStart) (AuditVerification, error) {
+	var result AuditVerification
+	files, err := AuditLogFiles(path)
+	if err != nil {
+		return result, err
+	}
+	head, err := readAuditHead(path)
+	if err != nil {
+		return result, err
+	}
+	if start == nil {
+		start = &AuditStart{Seq: 1}
+	}
+
+	var previous *AuditEntry
+	for _, file := range files {
+		_, err := readAuditFile(file, func(entry *AuditEntry) error {
+			wantSeq, wantPrevHash := start.Seq, start.PrevHash
+			if previous != nil {
+				wantSeq, wantPrevHash = previous.Seq+1, previous.Hash
+			} else {
+				if wantSeq == 0 {
+					wantSeq = entry.Seq
+				}
+				if wantPrevHash == "" && wantSeq > 1 {
+					wantPrevHash = entry.PrevHash
+				}
+				result.FirstSeq = entry.Seq
+			}
+			if entry.Seq != wantSeq {
+				return fmt.Errorf("%w: %s: expected entry %d, found entry %d", ErrAuditLogCorrupted, filepath.Base(file), wantSeq, entry.Seq)
+			}
+			if entry.PrevHash != wantPrevHash {
+				return fmt.Errorf("%w: entry %d does not follow entry %d", ErrAuditLogCorrupted, entry.Seq, wantSeq-1)
+			}
+			hash, err := entry.computeHash(key)
+			if err != nil {
+				return err
+			}
+			if entry.Hash != hash {
+				return fmt.Errorf("%w: entry %d was modified, or the key is wrong", ErrAuditLogCorrupted, entry.Seq)
+			}
+			previous = entry
+			result.Entries++
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Files++
+	}
+
+	var lastSeq uint64
+	if previous != nil {
+		lastSeq, result.LastHash = previous.Seq, previous.Hash
+	}
+	result.LastSeq = lastSeq
+	switch {
+	case head == nil && previous != nil:
+		return result, fmt.Errorf("%w: the head file is missing", ErrAuditLogCorrupted)
+	case head == nil:
+	case head.Seq > lastSeq:
+		return result, fmt.Errorf("%w: the log was truncated, it ends at entry %d but the head records entry %d", ErrAuditLogCorrupted, lastSeq, head.Seq)
+	case head.Seq == lastSeq && head.Hash != result.LastHash:
+		return result, fmt.Errorf("%w: entry %d does not match the head", ErrAuditLogCorrupted, lastSeq)
+	case head.Seq+1 == lastSeq && head.Hash != previous.PrevHash:
+		return result, fmt.Errorf("%w: entry %d does not follow the head", ErrAuditLogCorrupted, lastSeq)
+	case head.Seq+1 < lastSeq:
+		// the head may only miss the last entry, written right before a crash
+		return result, fmt.Errorf("%w: entries %d to %d were added after the head", ErrAuditLogCorrupted, head.Seq+2, lastSeq)
+	}
+	return result, nil
+}
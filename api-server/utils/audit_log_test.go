@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testAuditKey = []byte("audit key")
+
+// writeAuditLog appends n entries to a new audit log in a temporary directory, one per file, and returns its path
+func writeAuditLog(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	appendAuditEntries(t, path, n)
+	return path
+}
+
+// appendAuditEntries appends n entries to the audit log, rotating it after every entry
+func appendAuditEntries(t *testing.T, path string, n int) {
+	t.Helper()
+	log, err := OpenAuditLog(path, testAuditKey, 1)
+	if err != nil {
+		t.Fatalf("OpenAuditLog: %v", err)
+	}
+	defer log.Close()
+	for i := range n {
+		entry := &AuditEntry{Operation: "CreateLogRecord", Method: "POST", Path: fmt.Sprintf("/v1/logs/log%d", i), Status: 200}
+		if err := log.Append(entry); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+// auditEntry reads the entry seq from the audit log
+func auditEntry(t *testing.T, path string, seq uint64) *AuditEntry {
+	t.Helper()
+	files, err := AuditLogFiles(path)
+	if err != nil {
+		t.Fatalf("AuditLogFiles: %v", err)
+	}
+	var found *AuditEntry
+	for _, file := range files {
+		readAuditFile(file, func(entry *AuditEntry) error {
+			if entry.Seq == seq {
+				found = entry
+			}
+			return nil
+		})
+	}
+	if found == nil {
+		t.Fatalf("entry %d not found", seq)
+	}
+	return found
+}
+
+func TestAuditLogVerifies(t *testing.T) {
+	path := writeAuditLog(t, 5)
+	// a reopened log continues the chain
+	appendAuditEntries(t, path, 1)
+
+	result, err := VerifyAuditLog(path, testAuditKey, nil)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if result.Files != 6 || result.Entries != 6 || result.FirstSeq != 1 || result.LastSeq != 6 || result.LastHash != auditEntry(t, path, 6).Hash {
+		t.Errorf("verification = %+v, want entries 1 to 6 in 6 files", result)
+	}
+}
+
+func TestAuditLogDetectsTampering(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		key    []byte
+		tamper func(t *testing.T, path string)
+	}{
+		{"edited entry", testAuditKey, func(t *testing.T, path string) {
+			file := fmt.Sprintf("%s.%08d", path, 3)
+			data, _ := os.ReadFile(file)
+			os.WriteFile(file, bytes.Replace(data, []byte(`"status":200`), []byte(`"status":500`), 1), 0644)
+		}},
+		{"missing rotated file", testAuditKey, func(t *testing.T, path string) {
+			os.Remove(fmt.Sprintf("%s.%08d", path, 3))
+		}},
+		{"truncated log", testAuditKey, func(t *testing.T, path string) {
+			os.Truncate(path, 0)
+		}},
+		{"missing head", testAuditKey, func(t *testing.T, path string) {
+			os.Remove(path + ".head")
+		}},
+		{"wrong key", []byte("another key"), func(t *testing.T, path string) {}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeAuditLog(t, 5)
+			test.tamper(t, path)
+			if _, err := VerifyAuditLog(path, test.key, nil); !errors.Is(err, ErrAuditLogCorrupted) {
+				t.Errorf("VerifyAuditLog: %v, want ErrAuditLogCorrupted", err)
+			}
+		})
+	}
+}
+
+func TestAuditLogVerifiesPrunedLogs(t *testing.T) {
+	path := writeAuditLog(t, 5)
+	prevHash := auditEntry(t, path, 2).Hash
+	for _, seq := range []uint64{1, 2} {
+		if err := os.Remove(fmt.Sprintf("%s.%08d", path, seq)); err != nil {
+			t.Fatalf("failed to prune the audit log: %v", err)
+		}
+	}
+
+	if _, err := VerifyAuditLog(path, testAuditKey, nil); !errors.Is(err, ErrAuditLogCorrupted) {
+		t.Errorf("VerifyAuditLog from entry 1: %v, want ErrAuditLogCorrupted", err)
+	}
+	for _, start := range []AuditStart{{}, {Seq: 3}, {Seq: 3, PrevHash: prevHash}} {
+		result, err := VerifyAuditLog(path, testAuditKey, &start)
+		if err != nil {
+			t.Errorf("VerifyAuditLog from %+v: %v", start, err)
+			continue
+		}
+		if result.Entries != 3 || result.FirstSeq != 3 || result.LastSeq != 5 {
+			t.Errorf("verification from %+v = %+v, want entries 3 to 5", start, result)
+		}
+	}
+	for _, start := range []AuditStart{{Seq: 2}, {Seq: 4}, {Seq: 3, PrevHash: auditEntry(t, path, 3).Hash}} {
+		if _, err := VerifyAuditLog(path, testAuditKey, &start); !errors.Is(err, ErrAuditLogCorrupted) {
+			t.Errorf("VerifyAuditLog from %+v: %v, want ErrAuditLogCorrupted", start, err)
+		}
+	}
+}
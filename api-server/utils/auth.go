@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnauthenticated is returned when a request carries no valid credentials
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// minHS256SecretSize is the minimum size in bytes of the HS256 secret, the size of the SHA-256 hash it keys
+const minHS256SecretSize = 32
+
+// Principal is an authenticated API caller
+type Principal struct {
+	ID     string   `json:"id"`
+	Roles  []string `json:"roles"`
+	Method string   `json:"method"`
+}
+
+// APIKeyConfig binds a static API key to a principal. Either the key itself or its
+// hex-encoded SHA-256 hash is given, the hash keeps the key out of the config file.
+type APIKeyConfig struct {
+	Key     string   `yaml:"key" json:"key"`
+	KeyHash string   `yaml:"keyHash" json:"keyHash"`
+	ID      string   `yaml:"id" json:"id"`
+	Roles   []string `yaml:"roles" json:"roles"`
+}
+
+// JWTConfig configures the verification of bearer tokens. The subject claim is the
+// principal ID and the roles claim holds its roles.
+type JWTConfig struct {
+	HS256SecretFile    string `yaml:"hs256SecretFile" json:"hs256SecretFile"`
+	RS256PublicKeyFile string `yaml:"rs256PublicKeyFile" json:"rs256PublicKeyFile"`
+	Issuer             string `yaml:"issuer" json:"issuer"`
+	Audience           string `yaml:"audience" json:"audience"`
+}
+
+// AuthConfig is the content of the authentication config file
+type AuthConfig struct {
+	APIKeys []APIKeyConfig `yaml:"apiKeys" json:"apiKeys"`
+	JWT     JWTConfig      `yaml:"jwt" json:"jwt"`
+}
+
+// Authenticator resolves the principal of a request from an API key or a JWT
+type Authenticator struct {
+	apiKeys  map[string]Principal
+	jwtKeys  map[string]any
+	issuer   string
+	audience string
+}
+
+// jwtClaims are the claims read from a bearer token
+type jwtClaims struct {
+	Roles []string `json:"roles"`
+	jwt.RegisteredClaims
+}
+
+// LoadAuthenticator reads the authentication config file and the JWT key files it refers to
+func LoadAuthenticator(configFile string) (*Authenticator, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth config file: %w", err)
+	}
+
+	var config AuthConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse auth config file %s: %w", configFile, err)
+	}
+
+	return NewAuthenticator(&config)
+}
+
+// NewAuthenticator creates an authenticator for the API keys and JWT keys of the config
+func NewAuthenticator(config *AuthConfig) (*Authenticator, error) {
+	auth := &Authenticator{
+		apiKeys:  make(map[string]Principal),
+		jwtKeys:  make(map[string]any),
+		issuer:   config.JWT.Issuer,
+		audience: config.JWT.Audience,
+	}
+
+	for i, apiKey := range config.APIKeys {
+		if apiKey.ID == "" {
+			return nil, fmt.Errorf("apiKeys[%d]: id must be set", i)
+		}
+		keyHash := strings.ToLower(apiKey.KeyHash)
+		if apiKey.Key != "" {
+			keyHash = hashAPIKey(apiKey.Key)
+		}
+		if keyHash == "" {
+			return nil, fmt.Errorf("apiKeys[%d]: key or keyHash must be set", i)
+		}
+		auth.apiKeys[keyHash] = Principal{ID: apiKey.ID, Roles: apiKey.Roles, Method: "apiKey"}
+	}
+
+	if config.JWT.HS256SecretFile != "" {
+		secret, err := os.ReadFile(config.JWT.HS256SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HS256 secret file: %w", err)
+		}
+		secret = []byte(strings.TrimSpace(string(secret)))
+		if len(secret) < minHS256SecretSize {
+			return nil, fmt.Errorf("HS256 secret file %s holds %d bytes, at least %d are required", config.JWT.HS256SecretFile, len(secret), minHS256SecretSize)
+		}
+		auth.jwtKeys[jwt.SigningMethodHS256.Alg()] = secret
+	}
+	if config.JWT.RS256PublicKeyFile != "" {
+		publicKeyPEM, err := os.ReadFile(config.JWT.RS256PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RS256 public key file: %w", err)
+		}
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RS256 public key: %w", err)
+		}
+		auth.jwtKeys[jwt.SigningMethodRS256.Alg()] = publicKey
+	}
+
+	return auth, nil
+}
+
+// AuthenticateAPIKey returns the principal the API key is bound to
+func (a *Authenticator) AuthenticateAPIKey(key string) (*Principal, error) {
+	principal, ok := a.apiKeys[hashAPIKey(key)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthenticated)
+	}
+	return &principal, nil
+}
+
+// AuthenticateJWT verifies the token signature, expiry, issuer and audience and returns its principal
+func (a *Authenticator) AuthenticateJWT(token string) (*Principal, error) {
+	if len(a.jwtKeys) == 0 {
+		return nil, fmt.Errorf("%w: bearer tokens are not accepted", ErrUnauthenticated)
+	}
+
+	var methods []string
+	for alg := range a.jwtKeys {
+		methods = append(methods, alg)
+	}
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if a.issuer != "" {
+		options = append(options, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		options = append(options, jwt.WithAudience(a.audience))
+	}
+
+	var claims jwtClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		return a.jwtKeys[t.Method.Alg()], nil
+	}, options...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+
+	return &Principal{ID: claims.Subject, Roles: claims.Roles, Method: "jwt"}, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated caller, whose ID also selects the wallet identity
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+	return WithCaller(ctx, principal.ID)
+}
+
+// PrincipalFromContext returns the authenticated caller, nil when authentication is disabled
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testHS256Secret = []byte("0123456789abcdef0123456789abcdef")
+
+// writeFile writes the content to a file of a temporary directory and returns its path
+func writeFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// signToken signs the claims with the method and key
+func signToken(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign the token: %v", err)
+	}
+	return token
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	auth, err := NewAuthenticator(&AuthConfig{APIKeys: []APIKeyConfig{
+		{Key: "key0", ID: "default0", Roles: []string{"datasource"}},
+		{KeyHash: hashAPIKey("key1"), ID: "evaluator0", Roles: []string{"evaluator"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+
+	for _, test := range []struct {
+		key  string
+		want string
+	}{
+		{"key0", "default0"},
+		{"key1", "evaluator0"},
+		{"key2", ""},
+		{"", ""},
+	} {
+		principal, err := auth.AuthenticateAPIKey(test.key)
+		switch {
+		case test.want == "" && !errors.Is(err, ErrUnauthenticated):
+			t.Errorf("AuthenticateAPIKey(%q): %v, want ErrUnauthenticated", test.key, err)
+		case test.want != "" && (err != nil || principal.ID != test.want || principal.Method != "apiKey"):
+			t.Errorf("AuthenticateAPIKey(%q) = %+v, %v, want %s", test.key, principal, err, test.want)
+		}
+	}
+
+	if _, err := NewAuthenticator(&AuthConfig{APIKeys: []APIKeyConfig{{ID: "default0"}}}); err == nil {
+		t.Error("NewAuthenticator accepted an API key without a key")
+	}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the RSA key: %v", err)
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal the RSA public key: %v", err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+
+	hs256, err := NewAuthenticator(&AuthConfig{JWT: JWTConfig{
+		HS256SecretFile: writeFile(t, "jwt-secret.key", append(testHS256Secret, '\n')),
+		Issuer:          "draglog",
+		Audience:        "draglog-api",
+	}})
+	if err != nil {
+		t.Fatalf("NewAuthenticator(HS256): %v", err)
+	}
+	rs256, err := NewAuthenticator(&AuthConfig{JWT: JWTConfig{RS256PublicKeyFile: writeFile(t, "jwt-public.pem", publicKeyPEM)}})
+	if err != nil {
+		t.Fatalf("NewAuthenticator(RS256): %v", err)
+	}
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "admin0", "roles": []string{"admin"}, "iss": "draglog", "aud": "draglog-api",
+			"exp": time.Now().Add(time.Hour).Unix()}
+	}
+	with := func(key string, value any) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	for _, test := range []struct {
+		name  string
+		auth  *Authenticator
+		token string
+		ok    bool
+	}{
+		{"valid HS256", hs256, signToken(t, jwt.SigningMethodHS256, testHS256Secret, valid()), true},
+		{"valid RS256", rs256, signToken(t, jwt.SigningMethodRS256, rsaKey, valid()), true},
+		{"bad signature", hs256, signToken(t, jwt.SigningMethodHS256, []byte("another secret of at least 32 bytes"), valid()), false},
+		{"missing exp", hs256, signToken(t, jwt.SigningMethodHS256, testHS256Secret, with("exp", nil)), false},
+		{"expired", hs256, signToken(t, jwt.SigningMethodHS256, testHS256Secret, with("exp", time.Now().Add(-time.Minute).Unix())), false},
+		{"wrong issuer", hs256, signToken(t, jwt.SigningMethodHS256, testHS256Secret, with("iss", "another")), false},
+		{"wrong audience", hs256, signToken(t, jwt.SigningMethodHS256, testHS256Secret, with("aud", "another")), false},
+		{"empty subject", hs256, signToken(t, jwt.SigningMethodHS256, testHS256Secret, with("sub", "")), false},
+		// an HS256 token keyed with the RS256 public key must not pass for an RS256 one
+		{"alg swap", rs256, signToken(t, jwt.SigningMethodHS256, publicKeyPEM, valid()), false},
+		{"unsigned", hs256, signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid()), false},
+	} {
+		principal, err := test.auth.AuthenticateJWT(test.token)
+		if test.ok && (err != nil || principal.ID != "admin0" || principal.Method != "jwt" || len(principal.Roles) != 1) {
+			t.Errorf("%s: AuthenticateJWT = %+v, %v, want admin0", test.name, principal, err)
+		}
+		if !test.ok && !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: AuthenticateJWT = %+v, %v, want ErrUnauthenticated", test.name, principal, err)
+		}
+	}
+
+	noJWT, err := NewAuthenticator(&AuthConfig{})
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	if _, err := noJWT.AuthenticateJWT(signToken(t, jwt.SigningMethodHS256, testHS256Secret, valid())); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("AuthenticateJWT without JWT keys: %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestShortHS256SecretsAreRejected(t *testing.T) {
+	for _, secret := range []string{"", " \n", "short secret", "0123456789abcdef0123456789abcde \n"} {
+		config := &AuthConfig{JWT: JWTConfig{HS256SecretFile: writeFile(t, "jwt-secret.key", []byte(secret))}}
+		if _, err := NewAuthenticator(config); err == nil {
+			t.Errorf("NewAuthenticator accepted the secret %q", secret)
+		}
+	}
+}
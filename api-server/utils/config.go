@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PeerConfig is one gateway peer the API server can connect to
+type PeerConfig struct {
+	Endpoint    string `yaml:"endpoint" json:"endpoint"`
+	GatewayPeer string `yaml:"gatewayPeer" json:"gatewayPeer"`
+	TLSCertPath string `yaml:"tlsCertPath" json:"tlsCertPath"`
+}
+
+// GatewayConfig holds everything needed to connect to the Fabric Gateway.
+// Empty certificate, key and TLS paths are resolved from CryptoPath using the test-network layout.
+// When Peers is empty, the single peer given by PeerEndpoint, GatewayPeer and TLSCertPath is used.
+type GatewayConfig struct {
+	MSPID               string        `yaml:"mspID" json:"mspID"`
+	CryptoPath          string        `yaml:"cryptoPath" json:"cryptoPath"`
+	CertPath            string        `yaml:"certPath" json:"certPath"`
+	KeyPath             string        `yaml:"keyPath" json:"keyPath"`
+	TLSCertPath         string        `yaml:"tlsCertPath" json:"tlsCertPath"`
+	PeerEndpoint        string        `yaml:"peerEndpoint" json:"peerEndpoint"`
+	GatewayPeer         string        `yaml:"gatewayPeer" json:"gatewayPeer"`
+	Peers               []PeerConfig  `yaml:"peers" json:"peers"`
+	ChannelName         string        `yaml:"channelName" json:"channelName"`
+	ChaincodeName       string        `yaml:"chaincodeName" json:"chaincodeName"`
+	EvaluateTimeout     time.Duration `yaml:"evaluateTimeout" json:"evaluateTimeout"`
+	EndorseTimeout      time.Duration `yaml:"endorseTimeout" json:"endorseTimeout"`
+	SubmitTimeout       time.Duration `yaml:"submitTimeout" json:"submitTimeout"`
+	CommitStatusTimeout time.Duration `yaml:"commitStatusTimeout" json:"commitStatusTimeout"`
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval" json:"healthCheckInterval"`
+	HealthCheckTimeout  time.Duration `yaml:"healthCheckTimeout" json:"healthCheckTimeout"`
+
+	// WalletPath enables per-caller identities: transactions are signed by the wallet identity
+	// labelled with the caller ID, or with the label Identities maps the caller ID to.
+	WalletPath       string            `yaml:"walletPath" json:"walletPath"`
+	Identities       map[string]string `yaml:"identities" json:"identities"`
+	IdentityPoolSize int               `yaml:"identityPoolSize" json:"identityPoolSize"`
+}
+
+// DefaultGatewayConfig returns the configuration for User1 of Org1 in the Fabric test network
+func DefaultGatewayConfig() *GatewayConfig {
+	return &GatewayConfig{
+		MSPID:               "Org1MSP",
+		CryptoPath:          "../fabric-samples/test-network/organizations/peerOrganizations/org1.example.com",
+		PeerEndpoint:        "dns:///localhost:7051",
+		GatewayPeer:         "peer0.org1.example.com",
+		ChannelName:         "mychannel",
+		ChaincodeName:       "basic",
+		EvaluateTimeout:     10 * time.Second,
+		EndorseTimeout:      30 * time.Second,
+		SubmitTimeout:       10 * time.Second,
+		CommitStatusTimeout: 1 * time.Minute,
+		HealthCheckInterval: 5 * time.Second,
+		HealthCheckTimeout:  3 * time.Second,
+		IdentityPoolSize:    64,
+	}
+}
+
+// LoadGatewayConfig starts from the defaults, then applies the config file (YAML or JSON) if one is given,
+// then the CHANNEL_NAME and CHAINCODE_NAME environment variables.
+func LoadGatewayConfig(configFile string) (*GatewayConfig, error) {
+	config := DefaultGatewayConfig()
+
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+		}
+	}
+
+	// Override default values for chaincode and channel name as they may differ in testing contexts.
+	if ccname := os.Getenv("CHAINCODE_NAME"); ccname != "" {
+		config.ChaincodeName = ccname
+	}
+	if cname := os.Getenv("CHANNEL_NAME"); cname != "" {
+		config.ChannelName = cname
+	}
+
+	return config, nil
+}
+
+// Resolve fills the empty certificate, key and TLS paths from CryptoPath,
+// and the peer list from the single peer settings
+func (c *GatewayConfig) Resolve() {
+	if c.CertPath == "" {
+		c.CertPath = path.Join(c.CryptoPath, "users/User1@org1.example.com/msp/signcerts")
+	}
+	if c.KeyPath == "" {
+		c.KeyPath = path.Join(c.CryptoPath, "users/User1@org1.example.com/msp/keystore")
+	}
+	if c.TLSCertPath == "" {
+		c.TLSCertPath = path.Join(c.CryptoPath, "peers/peer0.org1.example.com/tls/ca.crt")
+	}
+	if len(c.Peers) == 0 {
+		c.Peers = []PeerConfig{{Endpoint: c.PeerEndpoint, GatewayPeer: c.GatewayPeer}}
+	}
+	for i := range c.Peers {
+		if c.Peers[i].TLSCertPath == "" {
+			c.Peers[i].TLSCertPath = c.TLSCertPath
+		}
+	}
+}
+
+// Validate checks that the configuration is complete and that the credential files exist
+func (c *GatewayConfig) Validate() error {
+	var problems []string
+
+	for _, field := range []struct{ name, value string }{
+		{"mspID", c.MSPID},
+		{"channelName", c.ChannelName},
+		{"chaincodeName", c.ChaincodeName},
+	} {
+		if field.value == "" {
+			problems = append(problems, fmt.Sprintf("%s must be set", field.name))
+		}
+	}
+
+	for _, dir := range []struct{ name, path string }{{"certPath", c.CertPath}, {"keyPath", c.KeyPath}} {
+		if info, err := os.Stat(dir.path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", dir.name, err))
+		} else if !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%s: %s is not a directory", dir.name, dir.path))
+		}
+	}
+	if len(c.Peers) == 0 {
+		problems = append(problems, "at least one peer must be set")
+	}
+	for i, peer := range c.Peers {
+		if peer.Endpoint == "" {
+			problems = append(problems, fmt.Sprintf("peers[%d].endpoint must be set", i))
+		}
+		if peer.GatewayPeer == "" {
+			problems = append(problems, fmt.Sprintf("peers[%d].gatewayPeer must be set", i))
+		}
+		if _, err := os.Stat(peer.TLSCertPath); err != nil {
+			problems = append(problems, fmt.Sprintf("peers[%d].tlsCertPath: %v", i, err))
+		}
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"evaluateTimeout", c.EvaluateTimeout},
+		{"endorseTimeout", c.EndorseTimeout},
+		{"submitTimeout", c.SubmitTimeout},
+		{"commitStatusTimeout", c.CommitStatusTimeout},
+		{"healthCheckInterval", c.HealthCheckInterval},
+		{"healthCheckTimeout", c.HealthCheckTimeout},
+	} {
+		if timeout.value <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive", timeout.name))
+		}
+	}
+
+	if c.WalletPath != "" && c.IdentityPoolSize <= 0 {
+		problems = append(problems, "identityPoolSize must be positive")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid gateway config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// String dumps the configuration as YAML
+func (c *GatewayConfig) String() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("failed to marshal config: %v", err)
+	}
+	return string(data)
+}
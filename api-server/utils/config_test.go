@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadGatewayConfigPrecedence(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "gateway.yaml")
+	yaml := "mspID: Org2MSP\nchannelName: yamlchannel\nchaincodeName: yamlcc\nevaluateTimeout: 20s\n"
+	if err := os.WriteFile(configFile, []byte(yaml), 0644); err != nil {
+		t.Fatalf("failed to write the config file: %v", err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		configFile string
+		env        map[string]string
+		want       GatewayConfig
+	}{
+		{"defaults", "", nil, GatewayConfig{MSPID: "Org1MSP", ChannelName: "mychannel", ChaincodeName: "basic", EvaluateTimeout: 10 * time.Second}},
+		{"config file over defaults", configFile, nil, GatewayConfig{MSPID: "Org2MSP", ChannelName: "yamlchannel", ChaincodeName: "yamlcc", EvaluateTimeout: 20 * time.Second}},
+		{"environment over defaults", "", map[string]string{"CHANNEL_NAME": "envchannel", "CHAINCODE_NAME": "envcc"},
+			GatewayConfig{MSPID: "Org1MSP", ChannelName: "envchannel", ChaincodeName: "envcc", EvaluateTimeout: 10 * time.Second}},
+		{"environment over config file", configFile, map[string]string{"CHANNEL_NAME": "envchannel", "CHAINCODE_NAME": "envcc"},
+			GatewayConfig{MSPID: "Org2MSP", ChannelName: "envchannel", ChaincodeName: "envcc", EvaluateTimeout: 20 * time.Second}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("CHANNEL_NAME", test.env["CHANNEL_NAME"])
+			t.Setenv("CHAINCODE_NAME", test.env["CHAINCODE_NAME"])
+			config, err := LoadGatewayConfig(test.configFile)
+			if err != nil {
+				t.Fatalf("LoadGatewayConfig: %v", err)
+			}
+			got := GatewayConfig{MSPID: config.MSPID, ChannelName: config.ChannelName, ChaincodeName: config.ChaincodeName, EvaluateTimeout: config.EvaluateTimeout}
+			if got.MSPID != test.want.MSPID || got.ChannelName != test.want.ChannelName || got.ChaincodeName != test.want.ChaincodeName || got.EvaluateTimeout != test.want.EvaluateTimeout {
+				t.Errorf("config = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// testGatewayConfig returns a resolved configuration whose credential files exist in a temporary directory
+func testGatewayConfig(t *testing.T) *GatewayConfig {
+	t.Helper()
+	config := DefaultGatewayConfig()
+	config.CryptoPath = t.TempDir()
+	config.Resolve()
+	for _, dir := range []string{config.CertPath, config.KeyPath, filepath.Dir(config.TLSCertPath)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(config.TLSCertPath, []byte("certificate"), 0644); err != nil {
+		t.Fatalf("failed to write the TLS certificate: %v", err)
+	}
+	return config
+}
+
+func TestValidateGatewayConfig(t *testing.T) {
+	if err := testGatewayConfig(t).Validate(); err != nil {
+		t.Fatalf("Validate of a complete config: %v", err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		change func(c *GatewayConfig)
+		want   string
+	}{
+		{"missing certificate directory", func(c *GatewayConfig) { os.RemoveAll(c.CertPath) }, "certPath:"},
+		{"missing key directory", func(c *GatewayConfig) { os.RemoveAll(c.KeyPath) }, "keyPath:"},
+		{"key path is a file", func(c *GatewayConfig) { c.KeyPath = c.TLSCertPath }, "is not a directory"},
+		{"missing TLS certificate", func(c *GatewayConfig) { os.Remove(c.TLSCertPath) }, "peers[0].tlsCertPath:"},
+		{"missing channel name", func(c *GatewayConfig) { c.ChannelName = "" }, "channelName must be set"},
+		{"no gateway peer", func(c *GatewayConfig) { c.Peers[0].GatewayPeer = "" }, "peers[0].gatewayPeer must be set"},
+		{"zero timeout", func(c *GatewayConfig) { c.SubmitTimeout = 0 }, "submitTimeout must be positive"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config := testGatewayConfig(t)
+			test.change(config)
+			err := config.Validate()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Validate: %v, want an error reporting %q", err, test.want)
+			}
+		})
+	}
+}
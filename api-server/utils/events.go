@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// ChaincodeEvent is an event emitted by a committed transaction of the chaincode
+type ChaincodeEvent struct {
+	BlockNumber   uint64          `json:"blockNumber" doc:"Block of the transaction"`
+	TransactionID string          `json:"transactionID" doc:"ID of the transaction"`
+	EventName     string          `json:"eventName" doc:"Name of the event"`
+	Payload       json.RawMessage `json:"payload" doc:"Payload of the event"`
+}
+
+// chaincodeEvents opens a stream of the chaincode events of the ledger
+func chaincodeEvents(ctx context.Context, startBlock *uint64) (<-chan *client.ChaincodeEvent, error) {
+	l, err := activeLedger()
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := l.ChaincodeEvents(ctx, startBlock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen to chaincode events: %w", err)
+	}
+	return events, nil
+}
+
+// ChaincodeEvents streams the chaincode events from startBlock, or from the next committed block when
+// startBlock is nil. The channel is closed when the context is done or the stream fails.
+func ChaincodeEvents(ctx context.Context, startBlock *uint64) (<-chan ChaincodeEvent, error) {
+	events, err := chaincodeEvents(ctx, startBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan ChaincodeEvent)
+	go func() {
+		defer close(out)
+		for event := range events {
+			payload := json.RawMessage(event.Payload)
+			if !json.Valid(payload) {
+				// keep non-JSON payloads readable as a JSON string
+				payload, _ = json.Marshal(string(event.Payload))
+			}
+			select {
+			case out <- ChaincodeEvent{BlockNumber: event.BlockNumber, TransactionID: event.TransactionID, EventName: event.EventName, Payload: payload}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
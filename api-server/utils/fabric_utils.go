@@ -2,410 +2,357 @@ package utils
 
 import (
 	"bytes"
-	"crypto/x509"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/hyperledger/fabric-gateway/pkg/client"
-	"github.com/hyperledger/fabric-gateway/pkg/identity"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"drag_log/chaincode"
 )
 
-const (
-	mspID        = "Org1MSP"
-	cryptoPath   = "../fabric-samples/test-network/organizations/peerOrganizations/org1.example.com"
-	certPath     = cryptoPath + "/users/User1@org1.example.com/msp/signcerts"
-	keyPath      = cryptoPath + "/users/User1@org1.example.com/msp/keystore"
-	tlsCertPath  = cryptoPath + "/peers/peer0.org1.example.com/tls/ca.crt"
-	peerEndpoint = "dns:///localhost:7051"
-	gatewayPeer  = "peer0.org1.example.com"
-)
+// ErrNotFound is returned when the chaincode reports that a record does not exist
+var ErrNotFound = errors.New("record not found")
 
-var (
-	GatewayConn    *client.Gateway
-	ClientConn     *grpc.ClientConn
-	ClientContract *client.Contract
-)
+// ErrTooLarge is returned when an argument of a transaction is larger than the chaincode accepts
+var ErrTooLarge = errors.New("argument too large")
 
-// testing
-var now = time.Now()
-var assetId = fmt.Sprintf("asset%d", now.Unix()*1e3+int64(now.Nanosecond())/1e6)
-
-// InitGateway initializes the Gateway connection.
-func InitGateway() {
-	ClientConn = newGrpcConnection()
-
-	id := newIdentity()
-	sign := newSign()
-	gw, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithClientConnection(ClientConn),
-		client.WithEvaluateTimeout(10*time.Second),
-		client.WithEndorseTimeout(30*time.Second),
-		client.WithSubmitTimeout(10*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
-	if err != nil {
-		panic(err)
-	}
-	GatewayConn = gw
-
-	// Override default values for chaincode and channel name as they may differ in testing contexts.
-	chaincodeName := "basic"
-	if ccname := os.Getenv("CHAINCODE_NAME"); ccname != "" {
-		chaincodeName = ccname
+// checkRecordSizes checks the sizes the chaincode enforces, so an oversized record is neither submitted nor queued
+func checkRecordSizes(record *chaincode.LogRecord) error {
+	if err := chaincode.CheckRecordSizes(record); err != nil {
+		return fmt.Errorf("%w: %w", ErrTooLarge, err)
 	}
+	return nil
+}
 
-	channelName := "mychannel"
-	if cname := os.Getenv("CHANNEL_NAME"); cname != "" {
-		channelName = cname
+// checkSize checks the size of a transaction argument against the maximum the chaincode accepts
+func checkSize(field string, value string, max int) error {
+	if err := chaincode.CheckSize(field, value, max); err != nil {
+		return fmt.Errorf("%w: %w", ErrTooLarge, err)
 	}
-
-	network := gw.GetNetwork(channelName)
-	ClientContract = network.GetContract(chaincodeName)
+	return nil
 }
 
-// newGrpcConnection creates a gRPC connection to the Gateway server.
-func newGrpcConnection() *grpc.ClientConn {
-	certificatePEM, err := os.ReadFile(tlsCertPath)
-	if err != nil {
-		panic(fmt.Errorf("failed to read TLS certifcate file: %w", err))
-	}
+// isTooLarge reports whether the chaincode rejected a transaction because an argument exceeds its maximum size
+func isTooLarge(err error) bool {
+	return strings.Contains(err.Error(), "larger than the maximum")
+}
 
-	certificate, err := identity.CertificateFromPEM(certificatePEM)
-	if err != nil {
-		panic(err)
+// Format JSON data
+func formatJSON(data []byte) string {
+	// if the data is empty, return an empty string
+	if len(data) == 0 {
+		return "[]"
 	}
 
-	certPool := x509.NewCertPool()
-	certPool.AddCert(certificate)
-	transportCredentials := credentials.NewClientTLSFromCert(certPool, gatewayPeer)
-
-	connection, err := grpc.NewClient(peerEndpoint, grpc.WithTransportCredentials(transportCredentials))
-	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
+	var prettyJSON bytes.Buffer
+	if err := json.Indent(&prettyJSON, data, "", "  "); err != nil {
+		panic(fmt.Errorf("failed to parse JSON: %w", err))
 	}
-
-	return connection
+	return prettyJSON.String()
 }
 
-// newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
-func newIdentity() *identity.X509Identity {
-	certificatePEM, err := readFirstFile(certPath)
-	if err != nil {
-		panic(fmt.Errorf("failed to read certificate file: %w", err))
-	}
-
-	certificate, err := identity.CertificateFromPEM(certificatePEM)
-	if err != nil {
-		panic(err)
-	}
+func InitLedgerTest(ctx context.Context) error {
+	slog.InfoContext(ctx, "Creating the initial set of log records on the ledger", "function", "InitLedger")
 
-	id, err := identity.NewX509Identity(mspID, certificate)
-	if err != nil {
-		panic(err)
+	if err := submit(ctx, "InitLedger"); err != nil {
+		return err
 	}
 
-	return id
+	slog.InfoContext(ctx, "Ledger initialized")
+	return nil
 }
 
-// newSign creates a function that generates a digital signature from a message digest using a private key.
-func newSign() identity.Sign {
-	privateKeyPEM, err := readFirstFile(keyPath)
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
-	}
-
-	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
-	if err != nil {
-		panic(err)
+// CreateLogRecord writes a log record, or queues it when the ledger is unreachable and reports that it was queued
+func CreateLogRecord(ctx context.Context, logID string, loggerID string, input string, inputFrom string, output string, outputTo string, timestamp string, reserved string) (bool, error) {
+	if err := checkRecordSizes(&chaincode.LogRecord{LogID: logID, LoggerID: loggerID, Input: input, InputFrom: inputFrom, Output: output, OutputTo: outputTo, Timestamp: timestamp, Reserved: reserved}); err != nil {
+		return false, err
 	}
+	return submitOrQueue(ctx, logID, "CreateLogRecord", logID, loggerID, input, inputFrom, output, outputTo, timestamp, reserved)
+}
 
-	sign, err := identity.NewPrivateKeySign(privateKey)
-	if err != nil {
-		panic(err)
+func CreateReliabilityRecord(ctx context.Context, dataSourceID string, digest string, reserved string) error {
+	if err := checkRecordSizes(&chaincode.LogRecord{LogID: dataSourceID, LoggerID: dataSourceID, Input: digest, Reserved: reserved}); err != nil {
+		return err
 	}
-
-	return sign
+	return submit(ctx, "CreateReliabilityRecord", dataSourceID, digest, reserved)
 }
 
-func readFirstFile(dirPath string) ([]byte, error) {
-	dir, err := os.Open(dirPath)
-	if err != nil {
-		return nil, err
+func CreateReliabilityRecordsBatch(ctx context.Context, recordsJSON string) error {
+	if err := checkSize("batch of records", recordsJSON, chaincode.MaxPayloadSize); err != nil {
+		return err
 	}
+	return submit(ctx, "CreateReliabilityRecordsBatch", recordsJSON)
+}
 
-	fileNames, err := dir.Readdirnames(1)
-	if err != nil {
-		return nil, err
+// CreateInteraction writes every record of an interaction in one transaction and returns the created log IDs
+func CreateInteraction(ctx context.Context, interactionJSON string) (string, error) {
+	if err := checkSize("interaction", interactionJSON, chaincode.MaxPayloadSize); err != nil {
+		return "", err
 	}
-
-	return os.ReadFile(path.Join(dirPath, fileNames[0]))
+	return submitWithResult(ctx, "CreateInteraction", interactionJSON)
 }
 
-// Format JSON data
-func formatJSON(data []byte) string {
-	// if the data is empty, return an empty string
-	if len(data) == 0 {
-		return "[]"
-	}
+// pendingCommits tracks the asynchronous submissions still waiting for their commit status
+var pendingCommits sync.WaitGroup
 
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, data, "", "  "); err != nil {
-		panic(fmt.Errorf("failed to parse JSON: %w", err))
+// CreateReliabilityRecordAsync returns once the transaction is submitted and waits for the commit in the background
+func CreateReliabilityRecordAsync(ctx context.Context, dataSourceID string, digest string, reserved string) error {
+	if err := checkRecordSizes(&chaincode.LogRecord{LogID: dataSourceID, LoggerID: dataSourceID, Input: digest, Reserved: reserved}); err != nil {
+		return err
 	}
-	return prettyJSON.String()
-}
-
-func InitLedgerTest() {
-	fmt.Printf("\n--> Submit Transaction: InitLedger, function creates the initial set of log records on the ledger \n")
 
-	_, err := ClientContract.SubmitTransaction("InitLedger")
+	l, err := activeLedger()
 	if err != nil {
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
+		return err
 	}
 
-	fmt.Printf("*** Transaction committed successfully\n")
-}
-
-// Evaluate a transaction to query ledger state.
-func testGetAllLogRecord() {
-	fmt.Println("\n--> Evaluate Transaction: GetAllLogRecord, function returns all the current log records on the ledger")
-
-	evaluateResult, err := ClientContract.EvaluateTransaction("GetAllRecords")
+	submitResult, commit, err := l.SubmitAsync(ctx, "CreateReliabilityRecord", dataSourceID, digest, reserved)
 	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+		slog.WarnContext(ctx, "Transaction failed", "function", "CreateReliabilityRecord", "error", err)
+		if isTooLarge(err) {
+			return fmt.Errorf("failed to submit transaction asynchronously: %w: %w", ErrTooLarge, err)
+		}
+		return fmt.Errorf("failed to submit transaction asynchronously: %w", err)
 	}
-	result := formatJSON(evaluateResult)
 
-	fmt.Printf("*** Result:%s\n", result)
-}
+	slog.DebugContext(ctx, "Transaction submitted, waiting for the commit", "function", "CreateReliabilityRecord", "dataSourceID", dataSourceID, "result", string(submitResult))
 
-// Submit a transaction synchronously, blocking until it has been committed to the ledger.
-func testCreateLogRecord() {
-	fmt.Printf("\n--> Submit Transaction: CreateLogRecord, creates new log record with logID, loggerID, input, inputFrom, output, outputTo, timestamp and reserved arguments \n")
+	pendingCommits.Add(1)
+	pendingCommitCount.Add(1)
+	go func() {
+		defer pendingCommits.Done()
+		defer pendingCommitCount.Add(-1)
 
-	_, err := ClientContract.SubmitTransaction("CreateLogRecord", "test_log_id", "test_logger_id", "test_input", "test_input_from", "test_output", "test_output_to", "test_timestamp", "test_reserved")
-	if err != nil {
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
-	}
+		if err := commit(); err != nil {
+			slog.ErrorContext(ctx, "Asynchronous transaction failed to commit", "function", "CreateReliabilityRecord", "dataSourceID", dataSourceID, "error", err)
+		}
+	}()
 
-	fmt.Printf("*** Transaction committed successfully\n")
+	return nil
 }
 
-func testGetAllReliabilityRecords() {
-	fmt.Println("\n--> Evaluate Transaction: GetAllReliabilityRecords, function returns all the current reliability records on the ledger")
+// WaitForPendingCommits blocks until every asynchronous submission has its commit status, or the context is done
+func WaitForPendingCommits(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		pendingCommits.Wait()
+		close(done)
+	}()
 
-	evaluateResult, err := ClientContract.EvaluateTransaction("GetAllReliabilityRecords")
-	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for pending commits: %w", ctx.Err())
 	}
-	result := formatJSON(evaluateResult)
-
-	fmt.Printf("*** Result:%s\n", result)
 }
 
-func testCreateReliabilityRecord() {
-	fmt.Printf("\n--> Submit Transaction: CreateReliabilityRecord, creates new reliability record with datasourceID and test_digest\n")
-
-	_, err := ClientContract.SubmitTransaction("CreateReliabilityRecord", "test_data_source_id", "test_digest")
-	if err != nil {
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
+// CreateFeedbackRecord writes a feedback record, or queues it when the ledger is unreachable and reports that it was queued
+func CreateFeedbackRecord(ctx context.Context, logID string, loggerID string, input string, inputFrom string, output string, outputTo string, timestamp string, reserved string) (bool, error) {
+	if err := checkRecordSizes(&chaincode.LogRecord{LogID: logID, LoggerID: loggerID, Input: input, InputFrom: inputFrom, Output: output, OutputTo: outputTo, Timestamp: timestamp, Reserved: reserved}); err != nil {
+		return false, err
 	}
-
-	fmt.Printf("*** Transaction committed successfully\n")
+	return submitOrQueue(ctx, logID, "CreateFeedbackRecord", logID, loggerID, input, inputFrom, output, outputTo, timestamp, reserved)
 }
 
-func CreateLogRecord(logID string, loggerID string, input string, inputFrom string, output string, outputTo string, timestamp string, reserved string) {
-	_, err := ClientContract.SubmitTransaction("CreateLogRecord", logID, loggerID, input, inputFrom, output, outputTo, timestamp, reserved)
-	if err != nil {
-		fmt.Printf("failed to submit transaction: %v\n", err)
-		return
-	}
+func GetAllLogRecords(ctx context.Context) (string, error) {
+	selector := `{"selector": {"type": "log"}}`
+	return GetRecordWithSelector(ctx, selector)
+}
 
-	fmt.Printf("*** Transaction committed successfully\n")
+func GetAllReliabilityRecords(ctx context.Context) (string, error) {
+	selector := `{"selector": {"type": "reliability"}}`
+	return GetRecordWithSelector(ctx, selector)
 }
 
-func CreateReliabilityRecord(dataSourceID string, digest string, reserved string) {
-	_, err := ClientContract.SubmitTransaction("CreateReliabilityRecord", dataSourceID, digest, reserved)
-	if err != nil {
-		fmt.Printf("failed to submit transaction: %v\n", err)
-		return
-	}
+func GetAllFeedbackRecords(ctx context.Context) (string, error) {
+	selector := `{"selector": {"type": "feedback"}}`
+	return GetRecordWithSelector(ctx, selector)
 }
 
-func CreateReliabilityRecordsBatch(recordsJSON string) {
-	_, err := ClientContract.SubmitTransaction("CreateReliabilityRecordsBatch", recordsJSON)
-	if err != nil {
-		fmt.Printf("failed to submit transaction: %v\n", err)
-		return
-	}
+func GetLogRecord(ctx context.Context, logID string) (string, error) {
+	return evaluate(ctx, "ReadLogRecord", logID)
 }
 
-// TODO: add async version, not working yet
-func CreateReliabilityRecordAsync(dataSourceID string, digest string, reserved string) {
-	submitResult, commit, err := ClientContract.SubmitAsync("CreateReliabilityRecord", client.WithArguments(dataSourceID, digest, reserved))
-	if err != nil {
-		fmt.Printf("failed to submit transaction asynchronously: %v\n", err)
-		return
+// GetReliabilityRecord reads the reliability record of a data source, from the score cache when it is enabled
+func GetReliabilityRecord(ctx context.Context, dataSourceID string) (string, error) {
+	if record, ok := cache.get(ctx, dataSourceID); ok {
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal cached reliability record: %w", err)
+		}
+		return formatJSON(recordJSON), nil
 	}
 
-	fmt.Printf("\n*** Successfully submitted transaction to store the record: %s. Info: %s\n", dataSourceID, string(submitResult))
-	fmt.Println("*** Waiting for transaction commit.")
-
-	commitStatus, err := commit.Status()
+	generation := cache.currentGeneration()
+	result, err := evaluate(ctx, "ReadReliabilityRecord", dataSourceID)
 	if err != nil {
-		panic(fmt.Errorf("failed to get commit status: %w", err))
+		return "", err
 	}
-
-	if !commitStatus.Successful {
-		panic(fmt.Errorf("transaction %s failed to commit with status: %d", commitStatus.TransactionID, int32(commitStatus.Code)))
+	var record reliabilityRecord
+	if err := json.Unmarshal([]byte(result), &record); err == nil {
+		cache.fill(generation, record)
 	}
+	return result, nil
 }
 
-func CreateFeedbackRecord(logID string, loggerID string, input string, inputFrom string, output string, outputTo string, timestamp string, reserved string) {
-	_, err := ClientContract.SubmitTransaction("CreateFeedbackRecord", logID, loggerID, input, inputFrom, output, outputTo, timestamp, reserved)
+// GetReliabilityScores reads the effective scores of many data sources, the ones missing from the score cache in a single evaluate
+func GetReliabilityScores(ctx context.Context, dataSourceIDs []string) (string, error) {
+	scores := make([]reliabilityScore, len(dataSourceIDs))
+	var missing []string
+	for i, dataSourceID := range dataSourceIDs {
+		if record, ok := cache.get(ctx, dataSourceID); ok {
+			scores[i] = reliabilityScore{DataSourceID: dataSourceID, Found: true, ReliabilityScore: record.ReliabilityScore, Record: &record}
+			continue
+		}
+		missing = append(missing, dataSourceID)
+	}
+
+	if len(missing) > 0 {
+		missingJSON, err := json.Marshal(missing)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal data source IDs: %w", err)
+		}
+		generation := cache.currentGeneration()
+		result, err := evaluate(ctx, "ReadReliabilityRecords", string(missingJSON))
+		if err != nil {
+			return "", err
+		}
+		var fetched []reliabilityScore
+		if err := json.Unmarshal([]byte(result), &fetched); err != nil {
+			return "", fmt.Errorf("failed to parse reliability scores: %w", err)
+		}
+
+		byID := make(map[string]reliabilityScore, len(fetched))
+		for _, score := range fetched {
+			byID[score.DataSourceID] = score
+			if score.Record != nil {
+				cache.fill(generation, *score.Record)
+			}
+		}
+		for i, dataSourceID := range dataSourceIDs {
+			if !scores[i].Found {
+				scores[i] = byID[dataSourceID]
+				scores[i].DataSourceID = dataSourceID
+			}
+		}
+	}
+
+	scoresJSON, err := json.Marshal(scores)
 	if err != nil {
-		fmt.Printf("failed to submit transaction: %v\n", err)
-		return
+		return "", fmt.Errorf("failed to marshal reliability scores: %w", err)
 	}
+	return formatJSON(scoresJSON), nil
 }
 
-// func CreateLogRecordAsync(logID string, loggerID string, input string, inputFrom string, output string, outputTo string, timestamp string, reserved string) {
-// 	// fmt.Printf("\n--> Submit Transaction: CreateRecord, creates new record with droneID, zip, flytime, flyrecord and reserved arguments \n")
-
-// 	submitResult, commit, err := ClientContract.SubmitAsync("CreateLogRecord", client.WithArguments(logID, loggerID, input, inputFrom, output, outputTo, timestamp, reserved))
-// 	if err != nil {
-// 		// panic(fmt.Errorf("failed to submit transaction asynchronously: %w", err))
-// 		fmt.Printf("failed to submit transaction asynchronously: %v\n", err)
-// 		// fmt.Printf("Please check if the record %s, %s, %s already exists\n", logID, loggerID, input)
-// 		return
-// 	}
-
-// 	fmt.Printf("\n*** Successfully submitted transaction to store the record: %s_%s. Info: %s\n", logID, timestamp, string(submitResult))
-// 	// fmt.Println("*** Waiting for transaction commit.")
+func GetFeedbackRecord(ctx context.Context, logID string) (string, error) {
+	return evaluate(ctx, "ReadFeedbackRecord", logID)
+}
 
-// 	if commitStatus, err := commit.Status(); err != nil {
-// 		panic(fmt.Errorf("failed to get commit status: %w", err))
-// 	} else if !commitStatus.Successful {
-// 		panic(fmt.Errorf("transaction %s failed to commit with status: %d", commitStatus.TransactionID, int32(commitStatus.Code)))
-// 	}
+func GetRecordWithSelector(ctx context.Context, selector string) (string, error) {
+	return evaluate(ctx, "QueryRecords", selector)
+}
 
-// 	// fmt.Printf("*** Transaction committed successfully\n")
-// }
+// QueryRecordsPage runs a rich query and returns one page of at most pageSize records with the bookmark of the next page
+func QueryRecordsPage(ctx context.Context, query string, pageSize int, bookmark string) (string, error) {
+	return evaluate(ctx, "QueryRecordsWithPagination", query, fmt.Sprintf("%d", pageSize), bookmark)
+}
 
-func GetAllLogRecords() string {
-	// fmt.Println("\n--> Evaluate Transaction: GetAllRecords, function returns all the current records on the ledger")
+func UpdateReliabilityRecord(ctx context.Context, dataSourceID string, reliabilityScore float32, isDelta bool, info string) error {
+	if err := checkSize("info field", info, chaincode.MaxReservedSize); err != nil {
+		return err
+	}
 
-	selector := `{"selector": {"type": "log"}}`
-	return GetRecordWithSelector(selector)
+	// the event of the transaction may arrive after the next read of the caller
+	defer cache.invalidate(dataSourceID)
+	return submit(ctx, "UpdateReliabilityScore", dataSourceID, fmt.Sprintf("%f", reliabilityScore), fmt.Sprintf("%t", isDelta), info)
 }
 
-func GetAllReliabilityRecords() string {
-	selector := `{"selector": {"type": "reliability"}}`
-	return GetRecordWithSelector(selector)
+// CompactReliabilityRecord folds the pending score deltas of a data source into its reliability record
+func CompactReliabilityRecord(ctx context.Context, dataSourceID string) error {
+	return submit(ctx, "CompactReliabilityScore", dataSourceID)
 }
 
-func GetAllFeedbackRecords() string {
-	selector := `{"selector": {"type": "feedback"}}`
-	return GetRecordWithSelector(selector)
+// CompactAllReliabilityRecords folds the pending score deltas of every data source into the reliability records
+func CompactAllReliabilityRecords(ctx context.Context) error {
+	return submit(ctx, "CompactReliabilityScores")
 }
 
-func GetLogRecord(logID string) string {
-	evaluateResult, err := ClientContract.EvaluateTransaction("ReadLogRecord", logID)
-	if err != nil {
-		fmt.Printf("failed to evaluate transaction: %v\n", err)
-		return ""
-	}
-	result := formatJSON(evaluateResult)
-	return result
+// StartCompaction compacts the reliability score deltas every interval until the context is done. A failed
+// compaction is logged and counted in the chaincode errors with the compaction phase.
+func StartCompaction(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := CompactAllReliabilityRecords(ctx); err != nil && ctx.Err() == nil {
+					slog.WarnContext(ctx, "Failed to compact the reliability score deltas", "error", err)
+					chaincodeErrors.WithLabelValues("CompactReliabilityScores", phaseCompaction, errorCode(err)).Inc()
+				}
+			}
+		}
+	}()
 }
 
-func GetReliabilityRecord(dataSourceID string) string {
-	evaluateResult, err := ClientContract.EvaluateTransaction("ReadReliabilityRecord", dataSourceID)
-	if err != nil {
-		fmt.Printf("failed to evaluate transaction: %v\n", err)
-		return ""
-	}
-	result := formatJSON(evaluateResult)
-	return result
+func GetHistoryForRecord(ctx context.Context, logID string) (string, error) {
+	return evaluate(ctx, "GetHistoryForRecord", logID)
 }
 
-func GetFeedbackRecord(logID string) string {
-	evaluateResult, err := ClientContract.EvaluateTransaction("ReadFeedbackRecord", logID)
-	if err != nil {
-		fmt.Printf("failed to evaluate transaction: %v\n", err)
-		return ""
-	}
-	result := formatJSON(evaluateResult)
-	return result
+// GetLineage walks the provenance graph from a record or an output digest, upstream, downstream or both
+func GetLineage(ctx context.Context, recordIDOrDigest string, direction string, maxDepth int) (string, error) {
+	return evaluate(ctx, "GetLineage", recordIDOrDigest, direction, strconv.Itoa(maxDepth))
 }
 
-// func getAllLogRecords() string {
-// 	selector := `{"selector": {"type": "log"}}`
-// 	return getRecordWithSelector(selector)
-// }
+// submit submits a transaction signed by the identity of the caller and waits for it to be committed
+func submit(ctx context.Context, name string, args ...string) error {
+	_, err := submitWithResult(ctx, name, args...)
+	return err
+}
 
-func GetRecordWithSelector(selector string) string {
-	evaluateResult, err := ClientContract.EvaluateTransaction("QueryRecords", selector)
+// submitWithResult submits a transaction as the caller and returns the formatted JSON result
+func submitWithResult(ctx context.Context, name string, args ...string) (string, error) {
+	l, err := activeLedger()
 	if err != nil {
-		fmt.Printf("failed to evaluate transaction: %v\n", err)
-		return ""
+		return "", err
 	}
-	result := formatJSON(evaluateResult)
-	return result
-}
 
-func UpdateReliabilityRecord(dataSourceID string, reliabilityScore float32, isDelta bool, info string) {
-	_, err := ClientContract.SubmitTransaction("UpdateReliabilityScore", dataSourceID, fmt.Sprintf("%f", reliabilityScore), fmt.Sprintf("%t", isDelta), info)
+	slog.DebugContext(ctx, "Submitting transaction", "function", name, "args", args)
+	submitResult, err := l.Submit(ctx, name, args...)
 	if err != nil {
-		fmt.Printf("failed to submit transaction: %v\n", err)
-		return
+		slog.WarnContext(ctx, "Transaction failed", "function", name, "error", err)
+		if isTooLarge(err) {
+			return "", fmt.Errorf("failed to submit transaction %s: %w: %w", name, ErrTooLarge, err)
+		}
+		return "", fmt.Errorf("failed to submit transaction %s: %w", name, err)
 	}
+	return formatJSON(submitResult), nil
 }
 
-func GetHistoryForRecord(logID string) string {
-	evaluateResult, err := ClientContract.EvaluateTransaction("GetHistoryForRecord", logID)
+// evaluate evaluates a transaction as the caller and returns the formatted JSON result
+func evaluate(ctx context.Context, name string, args ...string) (string, error) {
+	l, err := activeLedger()
 	if err != nil {
-		fmt.Printf("failed to evaluate transaction: %v\n", err)
-		return ""
+		return "", err
 	}
-	result := formatJSON(evaluateResult)
-	return result
-}
-
-// // main function
-func main() {
-	InitGateway()
-	fmt.Println("InitGateway")
-	// testInitLedger()
-	// fmt.Println("testInitLedger")
-	// testCreateLogRecord()
-	// fmt.Println("testCreateLogRecord done")
-
-	// selector := `{"selector": {"logID": "test_log_id"}}`
-	// getRecordWithSelector(selector)
-
-	// selector = `{"selector": {"logID": "default0", "type": "reliability"}}`
-	// getRecordWithSelector(selector)
-
-	// selector = `{"selector": {"logID": "default0", "type": "log"}}`
-	// getRecordWithSelector(selector)
-
-	GetAllReliabilityRecords()
-
-	GetAllLogRecords()
-
-	GetLogRecord("default0-reranker0")
-	GetReliabilityRecord("default0")
-
-	UpdateReliabilityRecord("default0", 0.9, true, "test_info")
-	GetReliabilityRecord("default0")
-	GetHistoryForRecord("default0")
 
+	slog.DebugContext(ctx, "Evaluating transaction", "function", name, "args", args)
+	evaluateResult, err := l.Evaluate(ctx, name, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "does not exist") {
+			slog.DebugContext(ctx, "Record not found", "function", name, "error", err)
+			return "", fmt.Errorf("failed to evaluate transaction %s: %w: %w", name, ErrNotFound, err)
+		}
+		slog.WarnContext(ctx, "Evaluation failed", "function", name, "error", err)
+		return "", fmt.Errorf("failed to evaluate transaction %s: %w", name, err)
+	}
+	return formatJSON(evaluateResult), nil
 }
@@ -0,0 +1,313 @@
+package utils
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// peerConnection is the Gateway connection to one of the configured peers
+type peerConnection struct {
+	config    PeerConfig
+	conn      *grpc.ClientConn
+	gateway   *client.Gateway
+	contract  *client.Contract
+	healthy   bool
+	lastError string
+	lastCheck time.Time
+}
+
+// PeerStatus is the health of one gateway peer as seen by the last probe
+type PeerStatus struct {
+	Endpoint    string `json:"endpoint" doc:"gRPC endpoint of the peer"`
+	GatewayPeer string `json:"gatewayPeer" doc:"TLS server name of the peer"`
+	Active      bool   `json:"active" doc:"Whether transactions are currently sent to this peer"`
+	Healthy     bool   `json:"healthy" doc:"Whether the last probe succeeded"`
+	LastError   string `json:"lastError,omitempty" doc:"Error of the last failed probe"`
+	LastCheck   string `json:"lastCheck,omitempty" doc:"Time of the last probe"`
+}
+
+// GatewayStatus is the state of the Gateway connections
+type GatewayStatus struct {
+	Ready      bool         `json:"ready" doc:"Whether the active peer answered the last probe"`
+	ActivePeer string       `json:"activePeer" doc:"Endpoint of the active peer"`
+	Peers      []PeerStatus `json:"peers" doc:"Status of every configured peer"`
+}
+
+// ErrNoPeer is returned when there is no gateway peer to send a transaction to, such as once the gateway is closed
+var ErrNoPeer = errors.New("no gateway peer")
+
+var (
+	gatewayMu     sync.RWMutex
+	gatewayConfig *GatewayConfig
+	peers         []*peerConnection
+	activePeer    int
+)
+
+// InitGateway creates a Gateway connection for every configured peer and selects the first healthy one.
+// An unreachable network is not an error, the health checks keep probing until a peer answers.
+func InitGateway(config *GatewayConfig) error {
+	id, err := newIdentity(config)
+	if err != nil {
+		return err
+	}
+	sign, err := newSign(config)
+	if err != nil {
+		return err
+	}
+
+	if config.WalletPath != "" {
+		if wallet, err = NewFileWallet(config.WalletPath); err != nil {
+			return err
+		}
+	}
+
+	var connections []*peerConnection
+	for _, peerConfig := range config.Peers {
+		conn, err := newGrpcConnection(peerConfig)
+		if err != nil {
+			return err
+		}
+
+		gw, err := client.Connect(
+			id,
+			client.WithSign(sign),
+			client.WithClientConnection(conn),
+			client.WithEvaluateTimeout(config.EvaluateTimeout),
+			client.WithEndorseTimeout(config.EndorseTimeout),
+			client.WithSubmitTimeout(config.SubmitTimeout),
+			client.WithCommitStatusTimeout(config.CommitStatusTimeout),
+		)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to connect to gateway %s: %w", peerConfig.Endpoint, err)
+		}
+
+		connections = append(connections, &peerConnection{
+			config:   peerConfig,
+			conn:     conn,
+			gateway:  gw,
+			contract: gw.GetNetwork(config.ChannelName).GetContract(config.ChaincodeName),
+		})
+	}
+
+	gatewayMu.Lock()
+	gatewayConfig = config
+	peers = connections
+	activePeer = 0
+	gatewayMu.Unlock()
+	ledger = gatewayLedger{}
+
+	CheckGatewayHealth()
+	return nil
+}
+
+// Contract returns the contract of the active peer, or ErrNoPeer once the gateway is closed
+func Contract() (*client.Contract, error) {
+	gatewayMu.RLock()
+	defer gatewayMu.RUnlock()
+	if activePeer >= len(peers) {
+		return nil, ErrNoPeer
+	}
+	return peers[activePeer].contract, nil
+}
+
+// StartHealthChecks probes the peers at the configured interval until the context is done
+func StartHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(gatewayConfig.HealthCheckInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				CheckGatewayHealth()
+			}
+		}
+	}()
+}
+
+// CheckGatewayHealth probes every peer with the Hello transaction and fails over
+// to the first healthy peer when the active one stops answering.
+func CheckGatewayHealth() {
+	gatewayMu.RLock()
+	connections := peers
+	timeout := gatewayConfig.HealthCheckTimeout
+	gatewayMu.RUnlock()
+	if len(connections) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	results := make([]error, len(connections))
+	for i, peer := range connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			_, results[i] = peer.contract.EvaluateWithContext(ctx, "Hello")
+		}()
+	}
+	wg.Wait()
+
+	gatewayMu.Lock()
+	defer gatewayMu.Unlock()
+	if len(peers) == 0 {
+		return
+	}
+
+	for i, peer := range connections {
+		peer.lastCheck = time.Now()
+		peer.healthy = results[i] == nil
+		peer.lastError = ""
+		if results[i] != nil {
+			peer.lastError = results[i].Error()
+			// leave the backoff so the next probe reconnects immediately
+			peer.conn.ResetConnectBackoff()
+		}
+	}
+
+	if connections[activePeer].healthy {
+		return
+	}
+	for i, peer := range connections {
+		if peer.healthy {
+			slog.Warn("Gateway peer is unavailable, failing over", "peer", connections[activePeer].config.Endpoint, "failover", peer.config.Endpoint)
+			activePeer = i
+			return
+		}
+	}
+}
+
+// CloseGateway closes the Gateway and gRPC connections of every peer and wallet identity
+func CloseGateway() {
+	closeWalletPool()
+
+	gatewayMu.Lock()
+	defer gatewayMu.Unlock()
+
+	for _, peer := range peers {
+		peer.gateway.Close()
+		peer.conn.Close()
+	}
+	peers = nil
+	activePeer = 0
+}
+
+// GetGatewayStatus returns the state of the ledger, for the Fabric gateway the state of the connections as of the last probe
+func GetGatewayStatus() GatewayStatus {
+	if ledger == nil {
+		return GatewayStatus{}
+	}
+	return ledger.Status()
+}
+
+// gatewayStatus returns the state of the Gateway connections as of the last probe
+func gatewayStatus() GatewayStatus {
+	gatewayMu.RLock()
+	defer gatewayMu.RUnlock()
+
+	status := GatewayStatus{}
+	if len(peers) == 0 {
+		return status
+	}
+
+	status.Ready = peers[activePeer].healthy
+	status.ActivePeer = peers[activePeer].config.Endpoint
+	for i, peer := range peers {
+		peerStatus := PeerStatus{
+			Endpoint:    peer.config.Endpoint,
+			GatewayPeer: peer.config.GatewayPeer,
+			Active:      i == activePeer,
+			Healthy:     peer.healthy,
+			LastError:   peer.lastError,
+		}
+		if !peer.lastCheck.IsZero() {
+			peerStatus.LastCheck = peer.lastCheck.Format(time.RFC3339)
+		}
+		status.Peers = append(status.Peers, peerStatus)
+	}
+	return status
+}
+
+// newGrpcConnection creates a gRPC connection to the Gateway server.
+func newGrpcConnection(peerConfig PeerConfig) (*grpc.ClientConn, error) {
+	certificatePEM, err := os.ReadFile(peerConfig.TLSCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS certifcate file: %w", err)
+	}
+
+	certificate, err := identity.CertificateFromPEM(certificatePEM)
+	if err != nil {
+		return nil, err
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(certificate)
+	transportCredentials := credentials.NewClientTLSFromCert(certPool, peerConfig.GatewayPeer)
+
+	connection, err := grpc.NewClient(peerConfig.Endpoint, grpc.WithTransportCredentials(transportCredentials))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC connection: %w", err)
+	}
+
+	return connection, nil
+}
+
+// newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
+func newIdentity(config *GatewayConfig) (*identity.X509Identity, error) {
+	certificatePEM, err := readFirstFile(config.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+
+	certificate, err := identity.CertificateFromPEM(certificatePEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return identity.NewX509Identity(config.MSPID, certificate)
+}
+
+// newSign creates a function that generates a digital signature from a message digest using a private key.
+func newSign(config *GatewayConfig) (identity.Sign, error) {
+	privateKeyPEM, err := readFirstFile(config.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return identity.NewPrivateKeySign(privateKey)
+}
+
+func readFirstFile(dirPath string) ([]byte, error) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	fileNames, err := dir.Readdirnames(1)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path.Join(dirPath, fileNames[0]))
+}
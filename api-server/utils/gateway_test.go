@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestClosedGatewayHasNoContract(t *testing.T) {
+	CloseGateway()
+
+	if _, err := Contract(); !errors.Is(err, ErrNoPeer) {
+		t.Errorf("Contract() error = %v, want ErrNoPeer", err)
+	}
+	ctx := WithCaller(context.Background(), "caller1")
+	if _, err := ContractFor(ctx); !errors.Is(err, ErrNoPeer) {
+		t.Errorf("ContractFor() error = %v, want ErrNoPeer", err)
+	}
+	if _, err := (gatewayLedger{}).Submit(ctx, "CreateLogRecord"); !errors.Is(err, ErrNoPeer) {
+		t.Errorf("Submit() error = %v, want ErrNoPeer", err)
+	}
+	// a queued write stays queued instead of failing
+	if !isUnreachable(ErrNoPeer) {
+		t.Error("ErrNoPeer is not reported as unreachable")
+	}
+}
@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Ledger executes the chaincode transactions of the API server. The Fabric gateway is the ledger of a
+// deployment, the memory ledger runs the chaincode in-process for local runs and tests.
+type Ledger interface {
+	// Submit submits a transaction as the caller of the context and waits for it to be committed
+	Submit(ctx context.Context, name string, args ...string) ([]byte, error)
+	// SubmitAsync submits a transaction as the caller and returns once it is endorsed,
+	// the returned function waits for the commit and reports a failed one
+	SubmitAsync(ctx context.Context, name string, args ...string) ([]byte, func() error, error)
+	// Evaluate runs a transaction as the caller without committing it
+	Evaluate(ctx context.Context, name string, args ...string) ([]byte, error)
+	// ChaincodeEvents streams the chaincode events from startBlock, or from the next committed block
+	// when startBlock is nil, until the context is done
+	ChaincodeEvents(ctx context.Context, startBlock *uint64) (<-chan *client.ChaincodeEvent, error)
+	// Status reports whether the ledger can take transactions
+	Status() GatewayStatus
+	// Close releases the connections of the ledger
+	Close()
+}
+
+// ledger is the ledger every transaction goes to, set by InitGateway, InitMemoryLedger or InitLocalLedger
+var ledger Ledger
+
+// UseLedger sends every transaction to the ledger, with the channel and chaincode names of the config
+func UseLedger(config *GatewayConfig, l Ledger) {
+	gatewayMu.Lock()
+	gatewayConfig = config
+	gatewayMu.Unlock()
+	ledger = l
+}
+
+// InitMemoryLedger runs the chaincode in-process against an empty in-memory state
+func InitMemoryLedger(config *GatewayConfig) (*MemoryLedger, error) {
+	memoryLedger, err := NewMemoryLedger(config.ChannelName, config.ChaincodeName)
+	if err != nil {
+		return nil, err
+	}
+	UseLedger(config, memoryLedger)
+	return memoryLedger, nil
+}
+
+// InitLocalLedger runs the chaincode in-process against the state of the local ledger in the directory
+func InitLocalLedger(config *GatewayConfig, dir string) (*MemoryLedger, error) {
+	localLedger, err := OpenLocalLedger(dir, config.ChannelName, config.ChaincodeName)
+	if err != nil {
+		return nil, err
+	}
+	UseLedger(config, localLedger)
+	return localLedger, nil
+}
+
+// activeLedger returns the ledger transactions go to
+func activeLedger() (Ledger, error) {
+	if ledger == nil {
+		return nil, fmt.Errorf("no ledger, the gateway is not initialized")
+	}
+	return ledger, nil
+}
+
+// CloseLedger closes the ledger transactions go to
+func CloseLedger() {
+	if ledger != nil {
+		ledger.Close()
+	}
+}
+
+// TxIDs collects the IDs of the transactions submitted with a context
+type TxIDs struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+// List returns the collected transaction IDs in submission order
+func (t *TxIDs) List() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.ids)
+}
+
+type txIDsKey struct{}
+
+// WithTxIDs returns a context collecting the IDs of the transactions submitted with it
+func WithTxIDs(ctx context.Context) (context.Context, *TxIDs) {
+	txIDs := &TxIDs{}
+	return context.WithValue(ctx, txIDsKey{}, txIDs), txIDs
+}
+
+// recordTxID adds the ID of a submitted transaction to the collector of the context, if any
+func recordTxID(ctx context.Context, txID string) {
+	if txIDs, ok := ctx.Value(txIDsKey{}).(*TxIDs); ok {
+		txIDs.mu.Lock()
+		txIDs.ids = append(txIDs.ids, txID)
+		txIDs.mu.Unlock()
+	}
+}
+
+// gatewayLedger is the Fabric gateway, sending the transactions to the active peer
+type gatewayLedger struct{}
+
+func (gatewayLedger) Submit(ctx context.Context, name string, args ...string) (result []byte, err error) {
+	ctx, span := startChaincodeSpan(ctx, "Submit", name)
+	defer func() { endSpan(span, err) }()
+
+	contract, err := ContractFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the steps of SubmitWithContext, keeping the transaction ID
+	proposal, err := contract.NewProposal(name, client.WithArguments(args...))
+	if err != nil {
+		return nil, err
+	}
+	recordTxID(ctx, proposal.TransactionID())
+	span.SetAttributes(attribute.String("fabric.transaction.id", proposal.TransactionID()))
+	start := time.Now()
+	transaction, err := step(ctx, "endorse", func(ctx context.Context) (*client.Transaction, error) {
+		return proposal.EndorseWithContext(ctx)
+	})
+	if err != nil {
+		observeChaincode(name, phaseSubmit, start, err)
+		return nil, err
+	}
+	commit, err := step(ctx, "submit", func(ctx context.Context) (*client.Commit, error) {
+		return transaction.SubmitWithContext(ctx)
+	})
+	observeChaincode(name, phaseSubmit, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	start = time.Now()
+	commitStatus, err := step(ctx, "commit-status", func(ctx context.Context) (*client.Status, error) {
+		return commit.StatusWithContext(ctx)
+	})
+	observeChaincode(name, phaseCommit, start, err)
+	if err != nil {
+		return nil, err
+	}
+	if !commitStatus.Successful {
+		observeCommitFailure(name, commitStatus.Code.String())
+		return nil, fmt.Errorf("transaction %s failed to commit with status code %d (%s)", commitStatus.TransactionID, int32(commitStatus.Code), commitStatus.Code)
+	}
+	return transaction.Result(), nil
+}
+
+func (gatewayLedger) SubmitAsync(ctx context.Context, name string, args ...string) (result []byte, wait func() error, err error) {
+	ctx, span := startChaincodeSpan(ctx, "SubmitAsync", name)
+	defer func() { endSpan(span, err) }()
+
+	contract, err := ContractFor(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the steps of SubmitAsync, each in its own span
+	proposal, err := contract.NewProposal(name, client.WithArguments(args...))
+	if err != nil {
+		return nil, nil, err
+	}
+	span.SetAttributes(attribute.String("fabric.transaction.id", proposal.TransactionID()))
+	start := time.Now()
+	transaction, err := step(ctx, "endorse", func(context.Context) (*client.Transaction, error) {
+		return proposal.Endorse()
+	})
+	if err != nil {
+		observeChaincode(name, phaseSubmit, start, err)
+		return nil, nil, err
+	}
+	commit, err := step(ctx, "submit", func(context.Context) (*client.Commit, error) {
+		return transaction.Submit()
+	})
+	observeChaincode(name, phaseSubmit, start, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordTxID(ctx, commit.TransactionID())
+	return transaction.Result(), func() error {
+		start := time.Now()
+		// the commit is awaited after the request, in a span that outlives its parent
+		commitStatus, err := step(ctx, "commit-status", func(context.Context) (*client.Status, error) {
+			return commit.Status()
+		})
+		observeChaincode(name, phaseCommit, start, err)
+		if err != nil {
+			return fmt.Errorf("failed to get commit status: %w", err)
+		}
+		if !commitStatus.Successful {
+			observeCommitFailure(name, commitStatus.Code.String())
+			return fmt.Errorf("transaction %s failed to commit with status: %d", commitStatus.TransactionID, int32(commitStatus.Code))
+		}
+		return nil
+	}, nil
+}
+
+func (gatewayLedger) Evaluate(ctx context.Context, name string, args ...string) (result []byte, err error) {
+	ctx, span := startChaincodeSpan(ctx, "Evaluate", name)
+	defer func() { endSpan(span, err) }()
+
+	contract, err := ContractFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	result, err = contract.EvaluateWithContext(ctx, name, client.WithArguments(args...))
+	observeChaincode(name, phaseEvaluate, start, err)
+	return result, err
+}
+
+func (gatewayLedger) ChaincodeEvents(ctx context.Context, startBlock *uint64) (<-chan *client.ChaincodeEvent, error) {
+	gatewayMu.RLock()
+	if len(peers) == 0 {
+		gatewayMu.RUnlock()
+		return nil, ErrNoPeer
+	}
+	network := peers[activePeer].gateway.GetNetwork(gatewayConfig.ChannelName)
+	chaincodeName := gatewayConfig.ChaincodeName
+	gatewayMu.RUnlock()
+
+	var options []client.ChaincodeEventsOption
+	if startBlock != nil {
+		options = append(options, client.WithStartBlock(*startBlock))
+	}
+	return network.ChaincodeEvents(ctx, chaincodeName, options...)
+}
+
+func (gatewayLedger) Status() GatewayStatus {
+	return gatewayStatus()
+}
+
+func (gatewayLedger) Close() {
+	CloseGateway()
+}
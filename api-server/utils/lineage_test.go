@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"context"
+	"drag_log/chaincode"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// readLineage walks the lineage of a record on the ledger
+func readLineage(t *testing.T, recordIDOrDigest string, direction string, depth int) chaincode.Lineage {
+	t.Helper()
+	result, err := GetLineage(context.Background(), recordIDOrDigest, direction, depth)
+	if err != nil {
+		t.Fatalf("GetLineage(%s, %s, %d): %v", recordIDOrDigest, direction, depth, err)
+	}
+	var lineage chaincode.Lineage
+	if err := json.Unmarshal([]byte(result), &lineage); err != nil {
+		t.Fatalf("failed to parse the lineage %s: %v", result, err)
+	}
+	return lineage
+}
+
+// nodeDepths returns the depth of every node of a lineage, by record ID
+func nodeDepths(lineage chaincode.Lineage) map[string]int {
+	depths := make(map[string]int)
+	for _, node := range lineage.Nodes {
+		depths[node.Record.LogID] = node.Depth
+	}
+	return depths
+}
+
+// createChain writes records n0 to n{length-1}, each taking the output of the previous one as its input
+func createChain(t *testing.T, length int) {
+	t.Helper()
+	for i := range length {
+		inputFrom, input := "", ""
+		if i > 0 {
+			inputFrom, input = fmt.Sprintf("c%d", i-1), fmt.Sprintf("o%d", i-1)
+		}
+		id, loggerID, outputTo := fmt.Sprintf("n%d", i), fmt.Sprintf("c%d", i), fmt.Sprintf("c%d", i+1)
+		if _, err := CreateLogRecord(context.Background(), id, loggerID, input, inputFrom, fmt.Sprintf("o%d", i), outputTo, "2025-01-01", ""); err != nil {
+			t.Fatalf("CreateLogRecord(%s): %v", id, err)
+		}
+	}
+}
+
+func TestLineageFollowsTheDepth(t *testing.T) {
+	useMemoryLedger(t)
+	createChain(t, 4)
+	if err := CreateReliabilityRecord(context.Background(), "c0", "o0", ""); err != nil {
+		t.Fatalf("CreateReliabilityRecord: %v", err)
+	}
+
+	for _, test := range []struct {
+		start     string
+		direction string
+		depth     int
+		want      map[string]int
+	}{
+		{"n3", "upstream", 0, map[string]int{"n3": 0}},
+		{"n3", "upstream", 1, map[string]int{"n3": 0, "n2": 1}},
+		{"n3", "upstream", 5, map[string]int{"n3": 0, "n2": 1, "n1": 2, "n0": 3}},
+		{"n1", "downstream", 1, map[string]int{"n1": 0, "n2": 1}},
+		{"n1", "both", 5, map[string]int{"n1": 0, "n0": 1, "n2": 1, "n3": 2}},
+		// an output digest starts from the records that produced it
+		{"o2", "upstream", 1, map[string]int{"n2": 0, "n1": 1}},
+	} {
+		lineage := readLineage(t, test.start, test.direction, test.depth)
+		if depths := nodeDepths(lineage); fmt.Sprint(depths) != fmt.Sprint(test.want) {
+			t.Errorf("lineage of %s %s to depth %d = %v, want %v", test.start, test.direction, test.depth, depths, test.want)
+		}
+		if lineage.Truncated {
+			t.Errorf("lineage of %s is truncated", test.start)
+		}
+	}
+
+	lineage := readLineage(t, "n3", "upstream", 5)
+	if !slices.Contains(lineage.Edges, chaincode.LineageEdge{From: "n0", To: "n1"}) {
+		t.Errorf("edges %v miss n0 -> n1", lineage.Edges)
+	}
+	if len(lineage.Sources) != 1 || lineage.Sources[0].DataSourceID != "c0" || !lineage.Sources[0].Found {
+		t.Errorf("sources = %+v, want the reliability record of c0", lineage.Sources)
+	}
+}
+
+func TestLineageDepthIsCapped(t *testing.T) {
+	useMemoryLedger(t)
+	createChain(t, 25)
+
+	lineage := readLineage(t, "n24", "upstream", 100)
+	depths := nodeDepths(lineage)
+	if len(depths) != 21 || depths["n4"] != 20 {
+		t.Errorf("lineage reached %d records, n4 at depth %d, want 21 records up to n4 at depth 20", len(depths), depths["n4"])
+	}
+	if _, err := GetLineage(context.Background(), "n24", "upstream", -1); err == nil {
+		t.Error("GetLineage accepted a negative depth")
+	}
+	if _, err := GetLineage(context.Background(), "n24", "sideways", 1); err == nil {
+		t.Error("GetLineage accepted an unknown direction")
+	}
+}
+
+func TestLineageStopsOnCycles(t *testing.T) {
+	useMemoryLedger(t)
+	ctx := context.Background()
+	// a and b each take the output of the other as their input
+	if _, err := CreateLogRecord(ctx, "a", "A", "ob", "B", "oa", "B", "2025-01-01", ""); err != nil {
+		t.Fatalf("CreateLogRecord(a): %v", err)
+	}
+	if _, err := CreateLogRecord(ctx, "b", "B", "oa", "A", "ob", "A", "2025-01-01", ""); err != nil {
+		t.Fatalf("CreateLogRecord(b): %v", err)
+	}
+
+	lineage := readLineage(t, "a", "both", 20)
+	if depths := nodeDepths(lineage); len(depths) != 2 || depths["a"] != 0 || depths["b"] != 1 {
+		t.Errorf("nodes = %v, want a at depth 0 and b at depth 1", depths)
+	}
+	for _, edge := range []chaincode.LineageEdge{{From: "a", To: "b"}, {From: "b", To: "a"}} {
+		if !slices.Contains(lineage.Edges, edge) {
+			t.Errorf("edges %v miss %s -> %s", lineage.Edges, edge.From, edge.To)
+		}
+	}
+	if len(lineage.Edges) != 2 {
+		t.Errorf("%d edges, want 2", len(lineage.Edges))
+	}
+}
+
+func TestLineageIsTruncated(t *testing.T) {
+	useMemoryLedger(t)
+	ctx := context.Background()
+	if _, err := CreateLogRecord(ctx, "doc", "source", "", "", "document", "LLM", "2025-01-01", ""); err != nil {
+		t.Fatalf("CreateLogRecord: %v", err)
+	}
+	for i := range 1000 {
+		if _, err := CreateLogRecord(ctx, fmt.Sprintf("answer%d", i), "LLM", "document", "source", "answer", "user", "2025-01-01", ""); err != nil {
+			t.Fatalf("CreateLogRecord: %v", err)
+		}
+	}
+
+	lineage := readLineage(t, "doc", "downstream", 1)
+	if !lineage.Truncated || len(lineage.Nodes) != 1000 {
+		t.Errorf("lineage has %d records, truncated %v, want 1000 truncated records", len(lineage.Nodes), lineage.Truncated)
+	}
+}
+
+func TestLineageKeepsInteractionsWithoutOutputsApart(t *testing.T) {
+	useMemoryLedger(t)
+	ctx := context.Background()
+	if err := CreateReliabilityRecord(ctx, "source0", "digest0", ""); err != nil {
+		t.Fatalf("CreateReliabilityRecord: %v", err)
+	}
+	// the re-ranker and the LLM log no output, so only the interaction ties their records
+	for _, id := range []string{"q1", "q2"} {
+		interaction := fmt.Sprintf(`{"interactionID": %q, "rerankerID": "reranker0", "llmID": "LLM0",`+
+			` "sources": [{"dataSourceID": "source0", "digest": "digest-%s"}], "timestamp": "2025-01-01"}`, id, id)
+		if _, err := CreateInteraction(ctx, interaction); err != nil {
+			t.Fatalf("CreateInteraction(%s): %v", id, err)
+		}
+	}
+	if _, err := CreateLogRecord(ctx, "log1", "reranker0", "", "source0", "", "LLM0", "2025-01-01", ""); err != nil {
+		t.Fatalf("CreateLogRecord: %v", err)
+	}
+
+	lineage := readLineage(t, "q1:LLM0-user", "upstream", 5)
+	want := map[string]int{"q1:LLM0-user": 0, "q1:reranker0-LLM0": 1, "q1:source0-reranker0": 2}
+	if depths := nodeDepths(lineage); fmt.Sprint(depths) != fmt.Sprint(want) {
+		t.Errorf("lineage of q1 = %v, want %v", depths, want)
+	}
+	lineage = readLineage(t, "log1", "downstream", 5)
+	if depths := nodeDepths(lineage); len(depths) != 1 {
+		t.Errorf("lineage of log1 = %v, want no record of an interaction", depths)
+	}
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Redacted replaces the value of the redacted log attributes and JSON fields
+const Redacted = "[REDACTED]"
+
+// LoggingConfig sets the level, the format and the redacted attributes of the logs
+type LoggingConfig struct {
+	// Level is debug, info, warn or error
+	Level string
+	// Format is text or json
+	Format string
+	// Redact lists the attribute keys whose values are replaced by [REDACTED], separated by commas
+	Redact string
+}
+
+// SetupLogging makes a logger writing to w the default logger. Records logged with a request context
+// carry its request ID and trace ID.
+func SetupLogging(w io.Writer, config LoggingConfig) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn or error", config.Level)
+	}
+
+	redact := RedactKeys(config.Redact)
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if redact[strings.ToLower(attr.Key)] {
+				return slog.String(attr.Key, Redacted)
+			}
+			return attr
+		},
+	}
+
+	var handler slog.Handler
+	switch config.Format {
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", config.Format)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// RedactKeys returns the set of the lowercase keys of a comma-separated list
+func RedactKeys(keys string) map[string]bool {
+	redact := make(map[string]bool)
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			redact[strings.ToLower(key)] = true
+		}
+	}
+	return redact
+}
+
+// RedactJSON replaces by [REDACTED] the values of the fields of a JSON document whose lowercase key is in
+// redact, at any depth
+func RedactJSON(data []byte, redact map[string]bool) ([]byte, error) {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactValue(value, redact))
+}
+
+// redactValue replaces the redacted fields of the objects of a decoded JSON value
+func redactValue(value any, redact map[string]bool) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if redact[strings.ToLower(key)] {
+				value[key] = Redacted
+			} else {
+				value[key] = redactValue(field, redact)
+			}
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redactValue(item, redact)
+		}
+	}
+	return value
+}
+
+// contextHandler adds the request ID and the trace ID of the context to the records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(slog.String("trace_id", spanContext.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the ID of the request it serves
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the ID of the request served with the context, empty if none
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupTestLogging makes a JSON logger writing to the returned buffer the default logger for the test
+func setupTestLogging(t *testing.T, redact string) *bytes.Buffer {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	var buf bytes.Buffer
+	if err := SetupLogging(&buf, LoggingConfig{Level: "debug", Format: "json", Redact: redact}); err != nil {
+		t.Fatalf("SetupLogging: %v", err)
+	}
+	return &buf
+}
+
+// loggedRecord decodes the single record of the buffer
+func loggedRecord(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("failed to parse the logged record %q: %v", buf.String(), err)
+	}
+	return record
+}
+
+func TestLoggingRedactsAttributes(t *testing.T) {
+	buf := setupTestLogging(t, "args, Input,reserved")
+	slog.Info("Submitting transaction", "function", "CreateLogRecord", "args", []string{"log1", "query"}, "input", "query", "RESERVED", "{}")
+
+	record := loggedRecord(t, buf)
+	for _, key := range []string{"args", "input", "RESERVED"} {
+		if record[key] != Redacted {
+			t.Errorf("%s = %v, want %s", key, record[key], Redacted)
+		}
+	}
+	if record["function"] != "CreateLogRecord" {
+		t.Errorf("function = %v, want it logged as is", record["function"])
+	}
+}
+
+func TestLoggingAddsTheRequestAndTraceIDs(t *testing.T) {
+	buf := setupTestLogging(t, "")
+	traceID := trace.TraceID{1, 2, 3}
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: trace.SpanID{4}})
+	ctx := trace.ContextWithSpanContext(WithRequestID(context.Background(), "request1"), spanContext)
+
+	slog.With("component", "test").InfoContext(ctx, "Evaluating transaction")
+	record := loggedRecord(t, buf)
+	if record["request_id"] != "request1" || record["trace_id"] != traceID.String() || record["component"] != "test" {
+		t.Errorf("record = %v, want request_id request1 and trace_id %s", record, traceID)
+	}
+
+	buf.Reset()
+	slog.Info("Gateway initialized")
+	record = loggedRecord(t, buf)
+	if _, ok := record["request_id"]; ok {
+		t.Errorf("record without a request context = %v, want no request_id", record)
+	}
+	if _, ok := record["trace_id"]; ok {
+		t.Errorf("record without a request context = %v, want no trace_id", record)
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	data, err := RedactJSON([]byte(`{"logID": "log1", "Input": "query", "records": [{"reserved": {"score": 1}, "output": "context"}]}`), RedactKeys("input,reserved"))
+	if err != nil {
+		t.Fatalf("RedactJSON: %v", err)
+	}
+	if want := `{"Input":"[REDACTED]","logID":"log1","records":[{"output":"context","reserved":"[REDACTED]"}]}`; string(data) != want {
+		t.Errorf("RedactJSON = %s, want %s", data, want)
+	}
+}
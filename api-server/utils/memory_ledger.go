@@ -0,0 +1,345 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"drag_log/chaincode"
+
+	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/queryresult"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MemoryLedger runs the DRagLog chaincode in-process against an in-memory world state, with the history
+// of every key and an emulation of the CouchDB rich queries. Transactions are committed one at a time,
+// each in its own block, so the MVCC conflicts of a Fabric network never happen. A ledger opened with
+// OpenLocalLedger writes every transaction to a hash-chained log on disk and restores its state from it,
+// otherwise nothing is persisted.
+type MemoryLedger struct {
+	chaincode     *contractapi.ContractChaincode
+	channelName   string
+	chaincodeName string
+	// name is the endpoint reported by Status
+	name string
+
+	mu      sync.RWMutex
+	state   map[string][]byte
+	history map[string][]*queryresult.KeyModification
+	height  uint64
+	events  []*client.ChaincodeEvent
+	// notify is closed and replaced when an event is committed or the ledger is closed
+	notify chan struct{}
+	closed bool
+	// txLog persists the committed transactions of a local ledger
+	txLog *txLog
+}
+
+// NewMemoryLedger returns a ledger with an empty world state running the DRagLog chaincode,
+// which logs with the default logger
+func NewMemoryLedger(channelName string, chaincodeName string) (*MemoryLedger, error) {
+	chaincode.SetLogger(slog.Default().With("component", "chaincode"))
+	cc, err := contractapi.NewChaincode(&chaincode.SimpleChaincode{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the chaincode: %w", err)
+	}
+	return &MemoryLedger{
+		chaincode:     cc,
+		channelName:   channelName,
+		chaincodeName: chaincodeName,
+		name:          "memory",
+		state:         make(map[string][]byte),
+		history:       make(map[string][]*queryresult.KeyModification),
+		notify:        make(chan struct{}),
+	}, nil
+}
+
+// TxLogFile is the name of the transaction log in the directory of a local ledger
+const TxLogFile = "transactions.jsonl"
+
+// OpenLocalLedger restores the ledger from the transaction log of the directory, then appends the
+// next transactions to it. An incomplete last entry, left by a crash while it was written, is dropped.
+func OpenLocalLedger(dir string, channelName string, chaincodeName string) (*MemoryLedger, error) {
+	l, err := NewMemoryLedger(channelName, chaincodeName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the ledger directory: %w", err)
+	}
+
+	path := filepath.Join(dir, TxLogFile)
+	log := &txLog{}
+	size, err := ReadTxLog(path, func(entry *TxLogEntry) error {
+		l.apply(entry)
+		log.lastHash = entry.Hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if log.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+		return nil, fmt.Errorf("failed to open the transaction log: %w", err)
+	}
+	info, err := log.file.Stat()
+	if err != nil {
+		log.file.Close()
+		return nil, fmt.Errorf("failed to open the transaction log: %w", err)
+	}
+	if info.Size() > size {
+		slog.Warn("Dropping the incomplete last entry of the transaction log", "block", l.height)
+		if err := log.file.Truncate(size); err != nil {
+			log.file.Close()
+			return nil, fmt.Errorf("failed to truncate the transaction log: %w", err)
+		}
+	}
+	l.txLog = log
+	l.name = "local:" + path
+	return l, nil
+}
+
+// Submit executes a transaction and commits its writes in a new block
+func (l *MemoryLedger) Submit(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return l.execute(ctx, name, args, true)
+}
+
+// SubmitAsync executes and commits a transaction, the commit is done when it returns
+func (l *MemoryLedger) SubmitAsync(ctx context.Context, name string, args ...string) ([]byte, func() error, error) {
+	result, err := l.execute(ctx, name, args, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, func() error { return nil }, nil
+}
+
+// Evaluate executes a transaction and discards its writes
+func (l *MemoryLedger) Evaluate(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return l.execute(ctx, name, args, false)
+}
+
+// execute invokes the chaincode with a stub reading the committed state, and commits the writes of a successful submit
+func (l *MemoryLedger) execute(ctx context.Context, name string, args []string, submit bool) (result []byte, err error) {
+	kind := "Evaluate"
+	if submit {
+		kind = "Submit"
+	}
+	ctx, span := startChaincodeSpan(ctx, kind, name)
+	defer func() { endSpan(span, err) }()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if submit {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	} else {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+	}
+	if l.closed {
+		return nil, fmt.Errorf("the memory ledger is closed")
+	}
+
+	txID, err := newTxID()
+	if err != nil {
+		return nil, err
+	}
+	phase := phaseEvaluate
+	if submit {
+		phase = phaseSubmit
+	}
+	start := time.Now()
+	stub := newMemoryStub(l, txID, !submit, append([]string{name}, args...))
+	response := l.chaincode.Invoke(stub)
+	if response.Status >= shim.ERRORTHRESHOLD {
+		// the message the Fabric gateway reports for a failed endorsement
+		err := fmt.Errorf("chaincode response %d, %s", response.Status, response.Message)
+		observeChaincode(name, phase, start, err)
+		return nil, err
+	}
+	observeChaincode(name, phase, start, nil)
+
+	if submit {
+		recordTxID(ctx, txID)
+		span.SetAttributes(attribute.String("fabric.transaction.id", txID))
+		start = time.Now()
+		_, err := step(ctx, "commit", func(context.Context) (struct{}, error) {
+			return struct{}{}, l.commit(stub, CallerFromContext(ctx))
+		})
+		observeChaincode(name, phaseCommit, start, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to commit transaction %s: %w", name, err)
+		}
+	}
+	return response.Payload, nil
+}
+
+// commit writes the transaction to the transaction log, if any, then applies it in a new block. l.mu must be held for writing.
+func (l *MemoryLedger) commit(stub *memoryStub, caller string) error {
+	entry := &TxLogEntry{
+		Block:     l.height + 1,
+		TxID:      stub.txID,
+		Timestamp: stub.timestamp.AsTime(),
+		Caller:    caller,
+		Function:  stub.GetStringArgs()[0],
+		Args:      stub.GetStringArgs()[1:],
+		Writes:    []TxLogWrite{},
+	}
+	for _, key := range slices.Sorted(maps.Keys(stub.writes)) {
+		entry.Writes = append(entry.Writes, newTxLogWrite(key, stub.writes[key]))
+	}
+	if stub.event != nil {
+		entry.Event = &TxLogEvent{Name: stub.event.EventName}
+		entry.Event.Payload, entry.Event.RawPayload = splitJSON(stub.event.Payload)
+	}
+
+	if l.txLog != nil {
+		if err := l.txLog.append(entry); err != nil {
+			return err
+		}
+	}
+	l.apply(entry)
+	return nil
+}
+
+// apply applies the writes of a committed transaction and publishes its event
+func (l *MemoryLedger) apply(entry *TxLogEntry) {
+	l.height = entry.Block
+	timestamp := timestamppb.New(entry.Timestamp)
+	for _, write := range entry.Writes {
+		value := write.value()
+		if write.IsDelete {
+			delete(l.state, write.Key)
+		} else {
+			l.state[write.Key] = value
+		}
+		l.history[write.Key] = append(l.history[write.Key], &queryresult.KeyModification{
+			TxId:      entry.TxID,
+			Value:     value,
+			Timestamp: timestamp,
+			IsDelete:  write.IsDelete,
+		})
+	}
+
+	if entry.Event != nil {
+		l.events = append(l.events, &client.ChaincodeEvent{
+			BlockNumber:   entry.Block,
+			TransactionID: entry.TxID,
+			ChaincodeName: l.chaincodeName,
+			EventName:     entry.Event.Name,
+			Payload:       entry.Event.payload(),
+		})
+		close(l.notify)
+		l.notify = make(chan struct{})
+	}
+}
+
+// ChaincodeEvents replays the committed events from startBlock, then streams the new ones until the context is done
+func (l *MemoryLedger) ChaincodeEvents(ctx context.Context, startBlock *uint64) (<-chan *client.ChaincodeEvent, error) {
+	l.mu.RLock()
+	next := len(l.events)
+	var fromBlock uint64
+	if startBlock != nil {
+		next = 0
+		fromBlock = *startBlock
+	}
+	l.mu.RUnlock()
+
+	out := make(chan *client.ChaincodeEvent)
+	go func() {
+		defer close(out)
+		for {
+			l.mu.RLock()
+			// the committed events are never changed, the slice can be read without the lock
+			pending := l.events[next:]
+			notify := l.notify
+			closed := l.closed
+			l.mu.RUnlock()
+
+			for _, event := range pending {
+				if event.BlockNumber < fromBlock {
+					continue
+				}
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			next += len(pending)
+			if closed {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-notify:
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Status reports the ledger as a single healthy peer until it is closed
+func (l *MemoryLedger) Status() GatewayStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return GatewayStatus{
+		Ready:      !l.closed,
+		ActivePeer: l.name,
+		Peers: []PeerStatus{{
+			Endpoint:    l.name,
+			GatewayPeer: fmt.Sprintf("%s/%s", l.channelName, l.chaincodeName),
+			Active:      true,
+			Healthy:     !l.closed,
+			LastCheck:   time.Now().Format(time.RFC3339),
+		}},
+	}
+}
+
+// Close rejects the next transactions, ends the event streams and closes the transaction log
+func (l *MemoryLedger) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = true
+	close(l.notify)
+	if l.txLog != nil {
+		if err := l.txLog.close(); err != nil {
+			slog.Warn("Failed to close the transaction log", "error", err)
+		}
+	}
+}
+
+// Height returns the number of committed blocks
+func (l *MemoryLedger) Height() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.height
+}
+
+// newTxID returns a random transaction ID in the hex format of Fabric
+func newTxID() (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to create the transaction ID: %w", err)
+	}
+	return hex.EncodeToString(nonce), nil
+}
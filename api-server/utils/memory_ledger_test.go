@@ -0,0 +1,359 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
+)
+
+// putState commits the values in one transaction of the ledger
+func putState(t *testing.T, l *MemoryLedger, values map[string]string) {
+	t.Helper()
+	stub := newMemoryStub(l, "tx", false, []string{"PutState"})
+	for key, value := range values {
+		if err := stub.PutState(key, []byte(value)); err != nil {
+			t.Fatalf("PutState(%q): %v", key, err)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.commit(stub, ""); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+}
+
+// iteratorKeys returns the keys of the results of a state iterator
+func iteratorKeys(t *testing.T, iterator shim.StateQueryIteratorInterface) []string {
+	t.Helper()
+	defer iterator.Close()
+	keys := []string{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func TestStubReadsTheCommittedState(t *testing.T) {
+	l, err := NewMemoryLedger("channel", "drag_log")
+	if err != nil {
+		t.Fatalf("NewMemoryLedger: %v", err)
+	}
+	defer l.Close()
+	putState(t, l, map[string]string{"a": "1"})
+
+	// like on a peer, a transaction does not read its own writes
+	stub := newMemoryStub(l, "tx2", false, []string{"PutState"})
+	if err := stub.PutState("a", []byte("2")); err != nil {
+		t.Fatalf("PutState: %v", err)
+	}
+	if err := stub.DelState("b"); err != nil {
+		t.Fatalf("DelState: %v", err)
+	}
+	if value, _ := stub.GetState("a"); string(value) != "1" {
+		t.Errorf("GetState(a) = %q before the commit, want 1", value)
+	}
+	if err := stub.PutState("", []byte("x")); err == nil {
+		t.Error("PutState accepted an empty key")
+	}
+
+	l.mu.Lock()
+	err = l.commit(stub, "")
+	l.mu.Unlock()
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if value := l.state["a"]; string(value) != "2" {
+		t.Errorf("a = %q after the commit, want 2", value)
+	}
+	if l.Height() != 2 {
+		t.Errorf("height = %d, want 2", l.Height())
+	}
+
+	// the history is newest first
+	history, err := newMemoryStub(l, "tx3", true, nil).GetHistoryForKey("a")
+	if err != nil {
+		t.Fatalf("GetHistoryForKey: %v", err)
+	}
+	var values []string
+	for history.HasNext() {
+		modification, err := history.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		values = append(values, string(modification.Value))
+	}
+	if !slices.Equal(values, []string{"2", "1"}) {
+		t.Errorf("history of a = %v, want [2 1]", values)
+	}
+}
+
+func TestStubRangeQueries(t *testing.T) {
+	l, err := NewMemoryLedger("channel", "drag_log")
+	if err != nil {
+		t.Fatalf("NewMemoryLedger: %v", err)
+	}
+	defer l.Close()
+	stub := newMemoryStub(l, "tx", true, nil)
+	delta1, _ := stub.CreateCompositeKey("delta", []string{"s1", "1"})
+	delta2, _ := stub.CreateCompositeKey("delta", []string{"s1", "2"})
+	other, _ := stub.CreateCompositeKey("delta", []string{"s2", "1"})
+	putState(t, l, map[string]string{"a": "{}", "b": "{}", "c": "{}", delta1: "1", delta2: "2", other: "3"})
+
+	// simple key ranges skip the composite keys
+	iterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		t.Fatalf("GetStateByRange: %v", err)
+	}
+	if keys := iteratorKeys(t, iterator); !slices.Equal(keys, []string{"a", "b", "c"}) {
+		t.Errorf("every simple key = %v, want [a b c]", keys)
+	}
+	iterator, _ = stub.GetStateByRange("b", "c")
+	if keys := iteratorKeys(t, iterator); !slices.Equal(keys, []string{"b"}) {
+		t.Errorf("range [b, c) = %v, want [b]", keys)
+	}
+	if _, err := stub.GetStateByRange(delta1, ""); err == nil {
+		t.Error("GetStateByRange accepted a composite key")
+	}
+
+	iterator, err = stub.GetStateByPartialCompositeKey("delta", []string{"s1"})
+	if err != nil {
+		t.Fatalf("GetStateByPartialCompositeKey: %v", err)
+	}
+	if keys := iteratorKeys(t, iterator); !slices.Equal(keys, []string{delta1, delta2}) {
+		t.Errorf("deltas of s1 = %q, want %q", keys, []string{delta1, delta2})
+	}
+	objectType, attributes, err := stub.SplitCompositeKey(delta2)
+	if err != nil || objectType != "delta" || !slices.Equal(attributes, []string{"s1", "2"}) {
+		t.Errorf("SplitCompositeKey = %s %v %v, want delta [s1 2]", objectType, attributes, err)
+	}
+
+	// a page ends at the page size, the bookmark starts the next one
+	iterator, metadata, err := stub.GetStateByRangeWithPagination("", "", 2, "")
+	if err != nil {
+		t.Fatalf("GetStateByRangeWithPagination: %v", err)
+	}
+	if keys := iteratorKeys(t, iterator); !slices.Equal(keys, []string{"a", "b"}) || metadata.Bookmark != "c" {
+		t.Errorf("first page = %v bookmark %q, want [a b] bookmark c", keys, metadata.Bookmark)
+	}
+	iterator, metadata, _ = stub.GetStateByRangeWithPagination("", "", 2, metadata.Bookmark)
+	if keys := iteratorKeys(t, iterator); !slices.Equal(keys, []string{"c"}) || metadata.Bookmark != "" {
+		t.Errorf("last page = %v bookmark %q, want [c] without bookmark", keys, metadata.Bookmark)
+	}
+}
+
+func TestStubRichQueries(t *testing.T) {
+	l, err := NewMemoryLedger("channel", "drag_log")
+	if err != nil {
+		t.Fatalf("NewMemoryLedger: %v", err)
+	}
+	defer l.Close()
+	putState(t, l, map[string]string{
+		"r1":  `{"type": "reliability", "reliabilityScore": 80, "loggerID": "b"}`,
+		"r2":  `{"type": "reliability", "reliabilityScore": 20, "loggerID": "a"}`,
+		"r3":  `{"type": "reliability", "reliabilityScore": 50, "loggerID": "c"}`,
+		"l1":  `{"type": "log", "loggerID": "a"}`,
+		"bin": "not json",
+	})
+	stub := newMemoryStub(l, "tx", true, nil)
+
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{`{"selector": {"type": "reliability"}}`, []string{"r1", "r2", "r3"}},
+		{`{"selector": {"type": "reliability", "reliabilityScore": {"$gte": 50}}}`, []string{"r1", "r3"}},
+		{`{"selector": {"$or": [{"type": "log"}, {"reliabilityScore": {"$lt": 30}}]}}`, []string{"l1", "r2"}},
+		{`{"selector": {"loggerID": {"$in": ["a", "c"]}, "type": {"$ne": "log"}}}`, []string{"r2", "r3"}},
+		{`{"selector": {"type": "reliability"}, "sort": [{"reliabilityScore": "desc"}]}`, []string{"r1", "r3", "r2"}},
+		{`{"selector": {"type": "reliability"}, "sort": ["loggerID"], "skip": 1, "limit": 1}`, []string{"r1"}},
+		{`{"selector": {"_id": "r3"}}`, []string{"r3"}},
+	} {
+		iterator, err := stub.GetQueryResult(test.query)
+		if err != nil {
+			t.Errorf("GetQueryResult(%s): %v", test.query, err)
+			continue
+		}
+		if keys := iteratorKeys(t, iterator); !slices.Equal(keys, test.want) {
+			t.Errorf("GetQueryResult(%s) = %v, want %v", test.query, keys, test.want)
+		}
+	}
+	if _, err := stub.GetQueryResult(`{"sort": ["loggerID"]}`); err == nil {
+		t.Error("GetQueryResult accepted a query without selector")
+	}
+
+	// the fields project the documents
+	iterator, _ := stub.GetQueryResult(`{"selector": {"_id": "r1"}, "fields": ["loggerID"]}`)
+	kv, err := iterator.Next()
+	if err != nil || string(kv.Value) != `{"loggerID":"b"}` {
+		t.Errorf("projected r1 = %s %v, want {\"loggerID\":\"b\"}", kv.GetValue(), err)
+	}
+
+	query := `{"selector": {"type": "reliability"}, "sort": [{"reliabilityScore": "asc"}]}`
+	iterator, metadata, err := stub.GetQueryResultWithPagination(query, 2, "")
+	if err != nil {
+		t.Fatalf("GetQueryResultWithPagination: %v", err)
+	}
+	if keys := iteratorKeys(t, iterator); !slices.Equal(keys, []string{"r2", "r3"}) || metadata.FetchedRecordsCount != 2 {
+		t.Errorf("first page = %v, %d fetched, want [r2 r3], 2 fetched", keys, metadata.FetchedRecordsCount)
+	}
+	iterator, metadata, _ = stub.GetQueryResultWithPagination(query, 2, metadata.Bookmark)
+	if keys := iteratorKeys(t, iterator); !slices.Equal(keys, []string{"r1"}) || metadata.FetchedRecordsCount != 1 {
+		t.Errorf("last page = %v, %d fetched, want [r1], 1 fetched", keys, metadata.FetchedRecordsCount)
+	}
+
+	// as on a peer, the paginated queries are rejected in the transactions that are submitted
+	submitted := newMemoryStub(l, "tx2", false, nil)
+	if _, _, err := submitted.GetQueryResultWithPagination(query, 2, ""); err == nil {
+		t.Error("GetQueryResultWithPagination accepted a submitted transaction")
+	}
+	if _, _, err := submitted.GetStateByRangeWithPagination("", "", 2, ""); err == nil {
+		t.Error("GetStateByRangeWithPagination accepted a submitted transaction")
+	}
+}
+
+func TestMemoryLedgerTransactions(t *testing.T) {
+	l := useMemoryLedger(t)
+	ctx := context.Background()
+
+	// an evaluate discards its writes
+	if _, err := l.Evaluate(ctx, "CreateReliabilityRecord", "source1", "digest1", ""); err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if l.Height() != 0 || l.state["source1"] != nil {
+		t.Errorf("the evaluate committed block %d", l.Height())
+	}
+
+	if err := CreateReliabilityRecord(ctx, "source1", "digest1", ""); err != nil {
+		t.Fatalf("CreateReliabilityRecord: %v", err)
+	}
+	// a failed transaction commits nothing
+	if _, err := CreateLogRecord(ctx, "source1", "LLM0", "in", "reranker0", "out", "user", "2025-01-01", ""); err == nil {
+		t.Error("a log record overwrote the reliability record")
+	}
+	if l.Height() != 1 {
+		t.Errorf("height = %d, want 1", l.Height())
+	}
+
+	// the events are replayed from the start block
+	if err := UpdateReliabilityRecord(ctx, "source1", 40, false, "review"); err != nil {
+		t.Fatalf("UpdateReliabilityRecord: %v", err)
+	}
+	eventsCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	start := uint64(0)
+	events, err := l.ChaincodeEvents(eventsCtx, &start)
+	if err != nil {
+		t.Fatalf("ChaincodeEvents: %v", err)
+	}
+	var blocks []uint64
+	for len(blocks) < 2 {
+		select {
+		case event := <-events:
+			blocks = append(blocks, event.BlockNumber)
+		case <-eventsCtx.Done():
+			t.Fatalf("got the events of blocks %v, want 2 events", blocks)
+		}
+	}
+	if !slices.Equal(blocks, []uint64{1, 2}) {
+		t.Errorf("events of blocks %v, want [1 2]", blocks)
+	}
+
+	l.Close()
+	if _, ok := <-events; ok {
+		t.Error("the event stream is still open after Close")
+	}
+	if _, err := l.Evaluate(ctx, "ReadReliabilityRecord", "source1"); err == nil {
+		t.Error("the closed ledger ran a transaction")
+	}
+	if l.Status().Ready {
+		t.Error("the closed ledger is ready")
+	}
+}
+
+func TestLocalLedgerRestoresItsState(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	config := DefaultGatewayConfig()
+
+	l, err := InitLocalLedger(config, dir)
+	if err != nil {
+		t.Fatalf("InitLocalLedger: %v", err)
+	}
+	t.Cleanup(func() { ledger = nil })
+	if err := CreateReliabilityRecord(ctx, "source1", "digest1", ""); err != nil {
+		t.Fatalf("CreateReliabilityRecord: %v", err)
+	}
+	if err := UpdateReliabilityRecord(ctx, "source1", -10, true, "feedback"); err != nil {
+		t.Fatalf("UpdateReliabilityRecord: %v", err)
+	}
+	state := l.state
+	l.Close()
+
+	// a crash in the middle of a write leaves an incomplete last line, which is dropped
+	path := filepath.Join(dir, TxLogFile)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open the transaction log: %v", err)
+	}
+	file.WriteString(`{"block": 3, "txID": "`)
+	file.Close()
+
+	l, err = InitLocalLedger(config, dir)
+	if err != nil {
+		t.Fatalf("InitLocalLedger after the crash: %v", err)
+	}
+	if l.Height() != 2 {
+		t.Errorf("restored height = %d, want 2", l.Height())
+	}
+	for key, value := range state {
+		if string(l.state[key]) != string(value) {
+			t.Errorf("restored %q = %s, want %s", key, l.state[key], value)
+		}
+	}
+	if record := readReliabilityRecord(t, "source1"); record.ReliabilityScore != 90 {
+		t.Errorf("restored score = %v, want 90", record.ReliabilityScore)
+	}
+	if err := UpdateReliabilityRecord(ctx, "source1", -10, true, "feedback"); err != nil {
+		t.Fatalf("UpdateReliabilityRecord after the restore: %v", err)
+	}
+	l.Close()
+
+	blocks, _, err := VerifyTxLog(path)
+	if err != nil || blocks != 3 {
+		t.Errorf("VerifyTxLog = %d blocks, %v, want 3 blocks", blocks, err)
+	}
+
+	// a modified block breaks the hash chain
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the transaction log: %v", err)
+	}
+	var entry map[string]any
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	if err := json.Unmarshal(lines[0], &entry); err != nil {
+		t.Fatalf("failed to parse the first block: %v", err)
+	}
+	entry["caller"] = "someone else"
+	lines[0], _ = json.Marshal(entry)
+	if err := os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0644); err != nil {
+		t.Fatalf("failed to write the transaction log: %v", err)
+	}
+	if _, _, err := VerifyTxLog(path); !errors.Is(err, ErrTxLogCorrupted) {
+		t.Errorf("VerifyTxLog of a modified log: %v, want ErrTxLogCorrupted", err)
+	}
+	if _, err := OpenLocalLedger(dir, config.ChannelName, config.ChaincodeName); !errors.Is(err, ErrTxLogCorrupted) {
+		t.Errorf("OpenLocalLedger of a modified log: %v, want ErrTxLogCorrupted", err)
+	}
+}
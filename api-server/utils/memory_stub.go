@@ -0,0 +1,393 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// the prefix of the composite keys and the bounds of their attributes, as in the shim
+const (
+	compositeKeyNamespace = "\x00"
+	minUnicodeRuneValue   = 0
+	maxUnicodeRuneValue   = utf8.MaxRune
+	// the start of a range query without start key, which skips the composite keys
+	emptyKeySubstitute = "\x01"
+)
+
+// errNotSupported is returned by the stub functions the memory ledger does not emulate
+var errNotSupported = errors.New("not supported by the memory ledger")
+
+// stateWrite is a write of a transaction, applied when it is committed
+type stateWrite struct {
+	value    []byte
+	isDelete bool
+}
+
+// memoryStub is the chaincode stub of a transaction of the memory ledger. Like on a peer, the reads see the
+// committed state only, not the writes of the transaction, which are applied when the transaction is committed.
+type memoryStub struct {
+	ledger    *MemoryLedger
+	txID      string
+	timestamp *timestamppb.Timestamp
+	readOnly  bool
+	args      [][]byte
+	writes    map[string]stateWrite
+	event     *peer.ChaincodeEvent
+}
+
+func newMemoryStub(ledger *MemoryLedger, txID string, readOnly bool, args []string) *memoryStub {
+	stub := &memoryStub{
+		ledger:    ledger,
+		txID:      txID,
+		timestamp: timestamppb.Now(),
+		readOnly:  readOnly,
+		writes:    make(map[string]stateWrite),
+	}
+	for _, arg := range args {
+		stub.args = append(stub.args, []byte(arg))
+	}
+	return stub
+}
+
+func (s *memoryStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *memoryStub) GetStringArgs() []string {
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+func (s *memoryStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *memoryStub) GetArgsSlice() ([]byte, error) {
+	return bytes.Join(s.args, nil), nil
+}
+
+func (s *memoryStub) GetTxID() string {
+	return s.txID
+}
+
+func (s *memoryStub) GetChannelID() string {
+	return s.ledger.channelName
+}
+
+func (s *memoryStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) *peer.Response {
+	return shim.Error(fmt.Sprintf("failed to invoke chaincode %s: %v", chaincodeName, errNotSupported))
+}
+
+func (s *memoryStub) GetState(key string) ([]byte, error) {
+	return s.ledger.state[key], nil
+}
+
+func (s *memoryStub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	s.writes[key] = stateWrite{value: bytes.Clone(value)}
+	return nil
+}
+
+func (s *memoryStub) DelState(key string) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	s.writes[key] = stateWrite{isDelete: true}
+	return nil
+}
+
+func (s *memoryStub) SetStateValidationParameter(key string, ep []byte) error {
+	return fmt.Errorf("state validation parameters are %w", errNotSupported)
+}
+
+func (s *memoryStub) GetStateValidationParameter(key string) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *memoryStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, err
+	}
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	return &stateIterator{results: s.rangeResults(startKey, endKey)}, nil
+}
+
+func (s *memoryStub) GetStateByRangeWithPagination(startKey string, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if err := s.checkReadOnly(); err != nil {
+		return nil, nil, err
+	}
+	if err := validateSimpleKeys(startKey, endKey); err != nil {
+		return nil, nil, err
+	}
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	return s.rangePage(s.rangeResults(startKey, endKey), pageSize)
+}
+
+func (s *memoryStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	startKey, err := shim.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return &stateIterator{results: s.rangeResults(startKey, startKey+string(rune(maxUnicodeRuneValue)))}, nil
+}
+
+func (s *memoryStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if err := s.checkReadOnly(); err != nil {
+		return nil, nil, err
+	}
+	startKey, err := shim.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	endKey := startKey + string(rune(maxUnicodeRuneValue))
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	return s.rangePage(s.rangeResults(startKey, endKey), pageSize)
+}
+
+func (s *memoryStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+func (s *memoryStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, compositeKeyNamespace) {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+	components := strings.Split(strings.TrimSuffix(compositeKey[1:], string(rune(minUnicodeRuneValue))), string(rune(minUnicodeRuneValue)))
+	return components[0], components[1:], nil
+}
+
+func (s *memoryStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	q, err := parseRichQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	results, err := q.run(s.ledger.chaincodeName, s.ledger.state)
+	if err != nil {
+		return nil, err
+	}
+	return &stateIterator{results: q.window(results)}, nil
+}
+
+func (s *memoryStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if err := s.checkReadOnly(); err != nil {
+		return nil, nil, err
+	}
+	q, err := parseRichQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	results, err := q.run(s.ledger.chaincodeName, s.ledger.state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the bookmark is the offset of the next page in the sorted results
+	offset := 0
+	if bookmark != "" {
+		if offset, err = strconv.Atoi(bookmark); err != nil || offset < 0 {
+			return nil, nil, fmt.Errorf("invalid bookmark %q", bookmark)
+		}
+	}
+	results = results[min(offset, len(results)):]
+	if pageSize > 0 && len(results) > int(pageSize) {
+		results = results[:pageSize]
+	}
+	metadata := &peer.QueryResponseMetadata{
+		FetchedRecordsCount: int32(len(results)),
+		Bookmark:            strconv.Itoa(offset + len(results)),
+	}
+	return &stateIterator{results: results}, metadata, nil
+}
+
+func (s *memoryStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	// newest first, as since Fabric v2.0
+	modifications := slices.Clone(s.ledger.history[key])
+	slices.Reverse(modifications)
+	return &historyIterator{results: modifications}, nil
+}
+
+func (s *memoryStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return nil, fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	return nil, fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) PutPrivateData(collection string, key string, value []byte) error {
+	return fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) DelPrivateData(collection string, key string) error {
+	return fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) PurgePrivateData(collection string, key string) error {
+	return fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) SetPrivateDataValidationParameter(collection string, key string, ep []byte) error {
+	return fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) GetPrivateDataValidationParameter(collection string, key string) ([]byte, error) {
+	return nil, fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) GetPrivateDataByRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) GetPrivateDataQueryResult(collection string, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("private data is %w", errNotSupported)
+}
+
+func (s *memoryStub) GetCreator() ([]byte, error) {
+	return nil, fmt.Errorf("the creator is %w", errNotSupported)
+}
+
+func (s *memoryStub) GetTransient() (map[string][]byte, error) {
+	return map[string][]byte{}, nil
+}
+
+func (s *memoryStub) GetBinding() ([]byte, error) {
+	return nil, fmt.Errorf("the binding is %w", errNotSupported)
+}
+
+func (s *memoryStub) GetDecorations() map[string][]byte {
+	return nil
+}
+
+func (s *memoryStub) GetSignedProposal() (*peer.SignedProposal, error) {
+	return nil, fmt.Errorf("the signed proposal is %w", errNotSupported)
+}
+
+func (s *memoryStub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return s.timestamp, nil
+}
+
+func (s *memoryStub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	s.event = &peer.ChaincodeEvent{TxId: s.txID, EventName: name, Payload: bytes.Clone(payload)}
+	return nil
+}
+
+// checkReadOnly rejects the paginated queries in the transactions that are submitted, as the peer does
+func (s *memoryStub) checkReadOnly() error {
+	if !s.readOnly {
+		return errors.New("paginated queries are only supported in read only transactions")
+	}
+	return nil
+}
+
+// rangeResults returns the committed keys from startKey included to endKey excluded, every key when endKey is empty
+func (s *memoryStub) rangeResults(startKey string, endKey string) []*queryresult.KV {
+	var results []*queryresult.KV
+	for _, key := range slices.Sorted(maps.Keys(s.ledger.state)) {
+		if key < startKey || (endKey != "" && key >= endKey) {
+			continue
+		}
+		results = append(results, &queryresult.KV{Namespace: s.ledger.chaincodeName, Key: key, Value: s.ledger.state[key]})
+	}
+	return results
+}
+
+// rangePage returns the first page of a range, with the next key as the bookmark
+func (s *memoryStub) rangePage(results []*queryresult.KV, pageSize int32) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	bookmark := ""
+	if pageSize > 0 && len(results) > int(pageSize) {
+		bookmark = results[pageSize].Key
+		results = results[:pageSize]
+	}
+	metadata := &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(results)), Bookmark: bookmark}
+	return &stateIterator{results: results}, metadata, nil
+}
+
+// validateSimpleKeys rejects the composite keys, as the shim does for range queries
+func validateSimpleKeys(simpleKeys ...string) error {
+	for _, key := range simpleKeys {
+		if strings.HasPrefix(key, compositeKeyNamespace) {
+			return fmt.Errorf(`first character of the key [%s] contains a null character which is not allowed`, key)
+		}
+	}
+	return nil
+}
+
+// stateIterator iterates over the results of a range or rich query
+type stateIterator struct {
+	results []*queryresult.KV
+	next    int
+}
+
+func (i *stateIterator) HasNext() bool {
+	return i.next < len(i.results)
+}
+
+func (i *stateIterator) Next() (*queryresult.KV, error) {
+	if !i.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	i.next++
+	return i.results[i.next-1], nil
+}
+
+func (i *stateIterator) Close() error {
+	return nil
+}
+
+// historyIterator iterates over the modifications of a key
+type historyIterator struct {
+	results []*queryresult.KeyModification
+	next    int
+}
+
+func (i *historyIterator) HasNext() bool {
+	return i.next < len(i.results)
+}
+
+func (i *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !i.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	i.next++
+	return i.results[i.next-1], nil
+}
+
+func (i *historyIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/status"
+)
+
+// The phases of a chaincode call
+const (
+	phaseEvaluate = "evaluate"
+	phaseSubmit   = "submit"
+	phaseCommit   = "commit"
+	// phaseCompaction is a periodic compaction of the score deltas that failed, whatever the phase
+	phaseCompaction = "compaction"
+)
+
+// sourcesMaxAge is how long the source count and score distribution are kept before the next scrape reads them again
+const sourcesMaxAge = 30 * time.Second
+
+// sourceScoreBuckets are the upper bounds of the score distribution, data sources start at 100
+var sourceScoreBuckets = []float64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+
+// metricsRegistry holds the metrics exposed on /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "draglog_http_requests_total",
+		Help: "Requests handled by the API, by operation and status code.",
+	}, []string{"operation", "method", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "draglog_http_request_duration_seconds",
+		Help:    "Time to handle a request of the API, by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "method"})
+	chaincodeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "draglog_chaincode_duration_seconds",
+		Help:    "Time of the chaincode calls by function and phase: evaluate, submit (endorse and submit to the orderer) and commit (wait for the commit status).",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"function", "phase"})
+	chaincodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "draglog_chaincode_errors_total",
+		Help: "Failed chaincode calls by function, phase and code: the gRPC status code of the gateway, or the validation code of a transaction that failed to commit. The periodic compactions that failed are counted again with the compaction phase.",
+	}, []string{"function", "phase", "code"})
+)
+
+// pendingCommitCount is the number of asynchronous submissions waiting for their commit status
+var pendingCommitCount atomic.Int64
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		chaincodeDuration,
+		chaincodeErrors,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "draglog_write_queue_pending",
+			Help: "Writes in the write queue waiting for the ledger.",
+		}, func() float64 {
+			_, stats := GetWriteQueue()
+			return float64(stats.Pending)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "draglog_write_queue_failed",
+			Help: "Writes in the write queue rejected by the ledger.",
+		}, func() float64 {
+			_, stats := GetWriteQueue()
+			return float64(stats.Failed)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "draglog_pending_commits",
+			Help: "Asynchronous submissions waiting for their commit status.",
+		}, func() float64 {
+			return float64(pendingCommitCount.Load())
+		}),
+		&sourcesCollector{},
+	)
+}
+
+// MetricsHandler serves the metrics in the Prometheus exposition format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a request handled by the API
+func ObserveRequest(operation string, method string, code int, duration time.Duration) {
+	requestsTotal.WithLabelValues(operation, method, strconv.Itoa(code)).Inc()
+	requestDuration.WithLabelValues(operation, method).Observe(duration.Seconds())
+}
+
+// observeChaincode records the duration of a phase of a chaincode call started at start, and its error if any
+func observeChaincode(function string, phase string, start time.Time, err error) {
+	chaincodeDuration.WithLabelValues(function, phase).Observe(time.Since(start).Seconds())
+	if err != nil {
+		chaincodeErrors.WithLabelValues(function, phase, errorCode(err)).Inc()
+	}
+}
+
+// observeCommitFailure records a transaction the peers did not validate, by its validation code
+func observeCommitFailure(function string, code string) {
+	chaincodeErrors.WithLabelValues(function, phaseCommit, code).Inc()
+}
+
+// errorCode returns the gRPC status code of an error of the gateway
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "DeadlineExceeded"
+	case errors.Is(err, context.Canceled):
+		return "Canceled"
+	}
+	return status.Code(err).String()
+}
+
+// sourcesCollector exposes the number of data sources and the distribution of their effective scores,
+// read from the ledger at most every sourcesMaxAge
+type sourcesCollector struct {
+	mu        sync.Mutex
+	readAt    time.Time
+	count     uint64
+	sum       float64
+	buckets   map[float64]uint64
+	available bool
+}
+
+var (
+	sourcesDesc = prometheus.NewDesc(
+		"draglog_sources",
+		"Data sources with a reliability record.",
+		nil, nil,
+	)
+	sourceScoreDesc = prometheus.NewDesc(
+		"draglog_source_score",
+		"Distribution of the effective reliability scores of the data sources.",
+		nil, nil,
+	)
+)
+
+func (c *sourcesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sourcesDesc
+	ch <- sourceScoreDesc
+}
+
+func (c *sourcesCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.readAt) > sourcesMaxAge {
+		c.read()
+	}
+	if !c.available {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(sourcesDesc, prometheus.GaugeValue, float64(c.count))
+	ch <- prometheus.MustNewConstHistogram(sourceScoreDesc, c.count, c.sum, c.buckets)
+}
+
+// read reads the effective scores of every data source, the previous values are kept while the ledger is unreachable
+func (c *sourcesCollector) read() {
+	if ledger == nil || !GetGatewayStatus().Ready {
+		return
+	}
+	c.readAt = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := GetAllReliabilityRecords(ctx)
+	if err != nil {
+		return
+	}
+	var records []reliabilityRecord
+	if err := json.Unmarshal([]byte(result), &records); err != nil {
+		return
+	}
+	dataSourceIDs := make([]string, 0, len(records))
+	for _, record := range records {
+		dataSourceIDs = append(dataSourceIDs, record.LogID)
+	}
+
+	var scores []reliabilityScore
+	if len(dataSourceIDs) > 0 {
+		result, err = GetReliabilityScores(ctx, dataSourceIDs)
+		if err != nil {
+			return
+		}
+		if err := json.Unmarshal([]byte(result), &scores); err != nil {
+			return
+		}
+	}
+
+	c.count, c.sum = 0, 0
+	c.buckets = make(map[float64]uint64, len(sourceScoreBuckets))
+	for _, bound := range sourceScoreBuckets {
+		c.buckets[bound] = 0
+	}
+	for _, score := range scores {
+		if !score.Found {
+			continue
+		}
+		c.count++
+		c.sum += float64(score.ReliabilityScore)
+		for _, bound := range sourceScoreBuckets {
+			if float64(score.ReliabilityScore) <= bound {
+				c.buckets[bound]++
+			}
+		}
+	}
+	c.available = true
+}
@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The roles an API caller can hold
+const (
+	RoleReader     = "reader"
+	RoleDataSource = "datasource"
+	RoleEvaluator  = "evaluator"
+	RoleAdmin      = "admin"
+)
+
+// ErrForbidden is returned when the caller is not allowed to perform an operation
+var ErrForbidden = errors.New("forbidden")
+
+var readerRoles = []string{RoleReader, RoleDataSource, RoleEvaluator, RoleAdmin}
+
+// defaultOperationRoles are the roles allowed to call each operation when the policy file does not override them.
+// Operations missing from the map are reserved to admins.
+var defaultOperationRoles = map[string][]string{
+	"initLedger":                    {RoleAdmin},
+	"CreateLogRecord":               {RoleDataSource, RoleAdmin},
+	"CreateFeedbackRecord":          {RoleEvaluator, RoleAdmin},
+	"CreateReliabilityRecord":       {RoleDataSource, RoleAdmin},
+	"CreateReliabilityRecordAsync":  {RoleDataSource, RoleAdmin},
+	"CreateReliabilityRecordsBatch": {RoleAdmin},
+	"UpdateReliabilityRecord":       {RoleEvaluator, RoleAdmin},
+	"CompactReliabilityRecords":     {RoleAdmin},
+	"GetAllLogRecords":              readerRoles,
+	"GetAllReliabilityRecords":      readerRoles,
+	"GetAllFeedbackRecords":         readerRoles,
+	"GetLogRecord":                  readerRoles,
+	"GetReliabilityRecord":          readerRoles,
+	"GetFeedbackRecord":             readerRoles,
+	"GetRecordWithSelector":         readerRoles,
+	"GetHistoryForRecord":           readerRoles,
+	"ListLogs":                      readerRoles,
+	"GetLog":                        readerRoles,
+	"CreateLog":                     {RoleDataSource, RoleAdmin},
+	"ListFeedback":                  readerRoles,
+	"GetFeedback":                   readerRoles,
+	"CreateFeedback":                {RoleEvaluator, RoleAdmin},
+	"ListSources":                   readerRoles,
+	"GetSource":                     readerRoles,
+	"CreateSource":                  {RoleDataSource, RoleAdmin},
+	"GetSourceScore":                readerRoles,
+	"GetSourceScores":               readerRoles,
+	"SetSourceScore":                {RoleAdmin},
+	"AddSourceScoreDelta":           {RoleEvaluator, RoleAdmin},
+	"GetRecordHistory":              readerRoles,
+	"Query":                         readerRoles,
+	"GetCacheStats":                 {RoleAdmin},
+	"CreateInteraction":             {RoleDataSource, RoleAdmin},
+	"GetRecordLineage":              readerRoles,
+	"GetRecordLineageGraph":         readerRoles,
+	"StreamEvents":                  readerRoles,
+	"GetWriteQueue":                 {RoleAdmin},
+	"DrainWriteQueue":               {RoleAdmin},
+	"RetryQueuedWrite":              {RoleAdmin},
+	"DeleteQueuedWrite":             {RoleAdmin},
+}
+
+// PolicyConfig is the content of the policy file
+type PolicyConfig struct {
+	// Bindings grants roles to principal IDs, on top of the roles carried by their API key or token
+	Bindings map[string][]string `yaml:"bindings" json:"bindings"`
+	// Operations overrides the roles allowed to call an operation, by operation ID
+	Operations map[string][]string `yaml:"operations" json:"operations"`
+}
+
+// Policy decides which principals may call which operations and records the denials
+type Policy struct {
+	mu             sync.RWMutex
+	path           string
+	config         PolicyConfig
+	denialsMu      sync.Mutex
+	denialsLogPath string
+	denialsLog     *os.File
+}
+
+// Denial is an entry of the denial log
+type Denial struct {
+	Timestamp string   `json:"timestamp"`
+	Principal string   `json:"principal"`
+	Roles     []string `json:"roles"`
+	Operation string   `json:"operation"`
+	Reason    string   `json:"reason"`
+}
+
+// LoadPolicy reads the policy file, if any. Denials are appended to the denials log, opened on the first denial,
+// unless its path is empty.
+func LoadPolicy(path string, denialsLogPath string) (*Policy, error) {
+	policy := &Policy{path: path, denialsLogPath: denialsLogPath}
+	if err := policy.Reload(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Reload reads the policy file again, keeping the current policy if the file is invalid
+func (p *Policy) Reload() error {
+	var config PolicyConfig
+	if p.path != "" {
+		data, err := os.ReadFile(p.path)
+		if err != nil {
+			return fmt.Errorf("failed to read policy file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("failed to parse policy file %s: %w", p.path, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.config = config
+	p.mu.Unlock()
+	return nil
+}
+
+// Close closes the denials log
+func (p *Policy) Close() error {
+	p.denialsMu.Lock()
+	defer p.denialsMu.Unlock()
+	if p.denialsLog == nil {
+		return nil
+	}
+	return p.denialsLog.Close()
+}
+
+// Roles returns the roles of the principal, from its credentials and from the policy bindings
+func (p *Policy) Roles(principal *Principal) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	roles := slices.Clone(principal.Roles)
+	for _, role := range p.config.Bindings[principal.ID] {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// HasRole reports whether the caller holds one of the roles. Requests are unrestricted when authentication is disabled.
+func (p *Policy) HasRole(ctx context.Context, roles ...string) bool {
+	principal := PrincipalFromContext(ctx)
+	if principal == nil {
+		return true
+	}
+
+	for _, role := range p.Roles(principal) {
+		if slices.Contains(roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeOperation checks that the caller holds one of the roles allowed to call the operation
+func (p *Policy) AuthorizeOperation(ctx context.Context, operationID string) error {
+	p.mu.RLock()
+	allowed, ok := p.config.Operations[operationID]
+	if !ok {
+		allowed, ok = defaultOperationRoles[operationID]
+	}
+	p.mu.RUnlock()
+	if !ok {
+		allowed = []string{RoleAdmin}
+	}
+
+	if p.HasRole(ctx, allowed...) {
+		return nil
+	}
+	return p.Deny(ctx, operationID, fmt.Sprintf("requires one of the roles %v", allowed))
+}
+
+// AuthorizeOwner checks that a caller acting as a data source only writes in its own name, admins may write for anyone
+func (p *Policy) AuthorizeOwner(ctx context.Context, operationID string, ownerID string) error {
+	principal := PrincipalFromContext(ctx)
+	if principal == nil || p.HasRole(ctx, RoleAdmin) || principal.ID == ownerID {
+		return nil
+	}
+	return p.Deny(ctx, operationID, fmt.Sprintf("%s cannot write on behalf of %s", principal.ID, ownerID))
+}
+
+// RequireRole checks that the caller holds one of the roles for a restricted use of an operation
+func (p *Policy) RequireRole(ctx context.Context, operationID string, reason string, roles ...string) error {
+	if p.HasRole(ctx, roles...) {
+		return nil
+	}
+	return p.Deny(ctx, operationID, reason)
+}
+
+// Deny records the denial and returns the error to send to the caller
+func (p *Policy) Deny(ctx context.Context, operationID string, reason string) error {
+	denial := Denial{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Operation: operationID,
+		Reason:    reason,
+	}
+	if principal := PrincipalFromContext(ctx); principal != nil {
+		denial.Principal = principal.ID
+		denial.Roles = p.Roles(principal)
+	}
+
+	if err := p.writeDenial(denial); err != nil {
+		slog.WarnContext(ctx, "Failed to write to denials log", "error", err)
+	}
+
+	return fmt.Errorf("%w: %s", ErrForbidden, reason)
+}
+
+func (p *Policy) writeDenial(denial Denial) error {
+	if p.denialsLogPath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(denial)
+	if err != nil {
+		return err
+	}
+
+	p.denialsMu.Lock()
+	defer p.denialsMu.Unlock()
+	if p.denialsLog == nil {
+		if err := os.MkdirAll(filepath.Dir(p.denialsLogPath), 0755); err != nil {
+			return err
+		}
+		p.denialsLog, err = os.OpenFile(p.denialsLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = p.denialsLog.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyDenialsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denials", "authz.log")
+	policy, err := LoadPolicy("", path)
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	defer policy.Close()
+
+	reader := WithPrincipal(context.Background(), &Principal{ID: "reader1", Roles: []string{RoleReader}})
+	if err := policy.AuthorizeOperation(reader, "GetLog"); err != nil {
+		t.Errorf("reader denied GetLog: %v", err)
+	}
+	if err := policy.AuthorizeOperation(reader, "SetSourceScore"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("reader allowed SetSourceScore: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the denials log: %v", err)
+	}
+	var denial Denial
+	if err := json.Unmarshal(data, &denial); err != nil {
+		t.Fatalf("failed to parse the denial %s: %v", data, err)
+	}
+	if denial.Principal != "reader1" || denial.Operation != "SetSourceScore" {
+		t.Errorf("denial = %+v, want reader1 denied SetSourceScore", denial)
+	}
+}
+
+func TestPolicyWithoutDenialsLog(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	policy, err := LoadPolicy("", "")
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	defer policy.Close()
+
+	reader := WithPrincipal(context.Background(), &Principal{ID: "reader1", Roles: []string{RoleReader}})
+	if err := policy.AuthorizeOperation(reader, "SetSourceScore"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("reader allowed SetSourceScore: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("denials written with the log disabled: %v", entries)
+	}
+}
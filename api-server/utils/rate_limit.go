@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+// rateLimitSweepInterval is the interval between the removals of the buckets that are full again
+const rateLimitSweepInterval = time.Minute
+
+// Limit is a token bucket refilled with Rate requests per second and holding up to Burst requests,
+// unlimited when Rate is 0
+type Limit struct {
+	Rate  float64 `yaml:"rate" json:"rate"`
+	Burst int     `yaml:"burst" json:"burst"`
+	// MaxBodySize bounds the request body of an operation in bytes, the --max-body-size option if 0
+	MaxBodySize int64 `yaml:"maxBodySize,omitempty" json:"maxBodySize,omitempty"`
+}
+
+// burst returns the burst of the limit, at least the requests of one second
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return max(1, int(math.Ceil(l.Rate)))
+}
+
+// LimitsConfig is the content of the limits file
+type LimitsConfig struct {
+	// Default is the limit of every caller, the --rate-limit options if unset
+	Default Limit `yaml:"default" json:"default"`
+	// Principals overrides the limit of a caller, by principal ID
+	Principals map[string]Limit `yaml:"principals" json:"principals"`
+	// Addresses overrides the limit of a client address, such as a proxy forwarding the requests of many callers
+	Addresses map[string]Limit `yaml:"addresses" json:"addresses"`
+	// Operations adds a limit per caller to an operation, by operation ID, and may bound its request body
+	Operations map[string]Limit `yaml:"operations" json:"operations"`
+}
+
+// RateLimiter limits the requests of every caller, identified by its principal ID or by its address, with a bucket
+// per caller and a bucket per caller and operation for the operations of the limits file. A principal and an
+// address never share a bucket.
+type RateLimiter struct {
+	mu        sync.Mutex
+	path      string
+	defaults  Limit
+	config    LimitsConfig
+	buckets   map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+// LoadRateLimiter reads the limits file, if any, on top of the default limit of the options
+func LoadRateLimiter(path string, defaults Limit) (*RateLimiter, error) {
+	limiter := &RateLimiter{path: path, defaults: defaults}
+	if err := limiter.Reload(); err != nil {
+		return nil, err
+	}
+	return limiter, nil
+}
+
+// Reload reads the limits file again and refills every bucket, keeping the current limits if the file is invalid.
+// The body sizes are only read when the server starts.
+func (r *RateLimiter) Reload() error {
+	var config LimitsConfig
+	if r.path != "" {
+		data, err := os.ReadFile(r.path)
+		if err != nil {
+			return fmt.Errorf("failed to read limits file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("failed to parse limits file %s: %w", r.path, err)
+		}
+	}
+	if config.Default.Rate == 0 {
+		config.Default = Limit{Rate: r.defaults.Rate, Burst: r.defaults.Burst}
+	}
+
+	r.mu.Lock()
+	r.config = config
+	r.buckets = make(map[string]*rate.Limiter)
+	r.mu.Unlock()
+	return nil
+}
+
+// MaxBodySize returns the maximum size of the request body of the operation, defaultSize unless the limits file sets it
+func (r *RateLimiter) MaxBodySize(operationID string, defaultSize int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if size := r.config.Operations[operationID].MaxBodySize; size > 0 {
+		return size
+	}
+	return defaultSize
+}
+
+// Allow takes a request of the principal to the operation from its buckets. When a bucket is empty the request
+// is refused and Allow returns the time after which it would be accepted.
+func (r *RateLimiter) Allow(principalID string, operationID string) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limit, ok := r.config.Principals[principalID]
+	if !ok {
+		limit = r.config.Default
+	}
+	return r.allow("principal\x00"+principalID, limit, operationID)
+}
+
+// AllowAddress takes a request from the client address like Allow, before its principal is known. An empty
+// operationID only takes it from the bucket of the address, not from the one of the operation.
+func (r *RateLimiter) AllowAddress(address string, operationID string) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limit, ok := r.config.Addresses[address]
+	if !ok {
+		limit = r.config.Default
+	}
+	return r.allow("address\x00"+address, limit, operationID)
+}
+
+// allow takes a request from the bucket of the caller key and the one of the operation, r.mu must be held
+func (r *RateLimiter) allow(key string, limit Limit, operationID string) (time.Duration, bool) {
+	now := time.Now()
+	if now.Sub(r.lastSweep) > rateLimitSweepInterval {
+		r.sweep(now)
+	}
+
+	var reservations []*rate.Reservation
+	if limit.Rate > 0 {
+		reservations = append(reservations, r.bucket(key, limit).ReserveN(now, 1))
+	}
+	if operationLimit := r.config.Operations[operationID]; operationID != "" && operationLimit.Rate > 0 {
+		reservations = append(reservations, r.bucket(key+"\x00"+operationID, operationLimit).ReserveN(now, 1))
+	}
+
+	var retryAfter time.Duration
+	for _, reservation := range reservations {
+		retryAfter = max(retryAfter, reservation.DelayFrom(now))
+	}
+	if retryAfter == 0 {
+		return 0, true
+	}
+	// a refused request takes no token
+	for _, reservation := range reservations {
+		reservation.CancelAt(now)
+	}
+	return retryAfter, false
+}
+
+// bucket returns the bucket of the key, created full
+func (r *RateLimiter) bucket(key string, limit Limit) *rate.Limiter {
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = rate.NewLimiter(rate.Limit(limit.Rate), limit.burst())
+		r.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// sweep removes the buckets that are full again, which a new bucket would replace with the same tokens
+func (r *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range r.buckets {
+		if bucket.TokensAt(now) >= float64(bucket.Burst()) {
+			delete(r.buckets, key)
+		}
+	}
+	r.lastSweep = now
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loadLimits loads a rate limiter from the content of a limits file
+func loadLimits(t *testing.T, config string) *RateLimiter {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "limits.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write the limits file: %v", err)
+	}
+	limiter, err := LoadRateLimiter(path, Limit{Rate: 100})
+	if err != nil {
+		t.Fatalf("LoadRateLimiter: %v", err)
+	}
+	return limiter
+}
+
+// allowed counts the requests of the caller the limiter accepts out of n
+func allowed(allow func(caller string, operationID string) (time.Duration, bool), caller string, operationID string, n int) int {
+	count := 0
+	for range n {
+		if _, ok := allow(caller, operationID); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	limiter := loadLimits(t, `
+default: {rate: 0.01, burst: 3}
+principals:
+  pipeline0: {rate: 0.01, burst: 5}
+  ops: {rate: 0}
+addresses:
+  10.0.0.5: {rate: 0.01, burst: 10}
+operations:
+  Query: {rate: 0.01, burst: 1, maxBodySize: 1024}
+`)
+
+	for _, test := range []struct {
+		name        string
+		allow       func(string, string) (time.Duration, bool)
+		caller      string
+		operationID string
+		want        int
+	}{
+		{"default principal", limiter.Allow, "default0", "GetLog", 3},
+		{"principal override", limiter.Allow, "pipeline0", "GetLog", 5},
+		{"unlimited principal", limiter.Allow, "ops", "GetLog", 20},
+		{"operation limit", limiter.Allow, "evaluator0", "Query", 1},
+		{"default address", limiter.AllowAddress, "192.0.2.1", "GetLog", 3},
+		{"address override", limiter.AllowAddress, "10.0.0.5", "GetLog", 10},
+		// an address named like a principal has its own bucket
+		{"address of a principal ID", limiter.AllowAddress, "default0", "", 3},
+		{"address without operation", limiter.AllowAddress, "192.0.2.2", "Query", 1},
+		{"address without operation limit", limiter.AllowAddress, "192.0.2.3", "", 3},
+	} {
+		if got := allowed(test.allow, test.caller, test.operationID, 20); got != test.want {
+			t.Errorf("%s: %d requests allowed, want %d", test.name, got, test.want)
+		}
+	}
+
+	if size := limiter.MaxBodySize("Query", 4096); size != 1024 {
+		t.Errorf("MaxBodySize(Query) = %d, want 1024", size)
+	}
+	if size := limiter.MaxBodySize("GetLog", 4096); size != 4096 {
+		t.Errorf("MaxBodySize(GetLog) = %d, want the default 4096", size)
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	limiter := loadLimits(t, `
+default: {rate: 1, burst: 1}
+operations:
+  Query: {rate: 0.1, burst: 1}
+`)
+
+	if _, ok := limiter.Allow("default0", "Query"); !ok {
+		t.Fatal("the first request was refused")
+	}
+	retryAfter, ok := limiter.Allow("default0", "Query")
+	if ok || retryAfter < 9*time.Second || retryAfter > 10*time.Second {
+		t.Errorf("second request = %v, %v, want refused for the 10s of the operation bucket", retryAfter, ok)
+	}
+
+	// a refused request takes no token, so another operation is only limited by the caller bucket
+	time.Sleep(time.Second)
+	if _, ok := limiter.Allow("default0", "GetLog"); !ok {
+		t.Error("the refused request took a token of the caller bucket")
+	}
+
+	if err := limiter.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, ok := limiter.Allow("default0", "Query"); !ok {
+		t.Error("Reload did not refill the buckets")
+	}
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"drag_log/chaincode"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/queryresult"
+)
+
+// richQuery is a CouchDB query as passed to GetQueryResult, run by the memory ledger over the JSON values of
+// the world state with the selector matching of the chaincode. Values are ordered with the CouchDB collation,
+// strings by code point.
+type richQuery struct {
+	Selector map[string]any `json:"selector"`
+	Sort     []any          `json:"sort"`
+	Fields   []string       `json:"fields"`
+	Limit    *int           `json:"limit"`
+	Skip     int            `json:"skip"`
+	// use_index and the other options only tune CouchDB, they are accepted and ignored
+}
+
+// sortField is a field of the sort of a query
+type sortField struct {
+	field      string
+	descending bool
+}
+
+// parseRichQuery parses a CouchDB query string
+func parseRichQuery(query string) (*richQuery, error) {
+	var q richQuery
+	if err := json.Unmarshal([]byte(query), &q); err != nil {
+		return nil, fmt.Errorf("invalid query %s: %w", query, err)
+	}
+	if q.Selector == nil {
+		return nil, fmt.Errorf("invalid query %s: the selector is missing", query)
+	}
+	return &q, nil
+}
+
+// sortFields returns the fields the query sorts by, each as "field" or {"field": "asc|desc"}
+func (q *richQuery) sortFields() ([]sortField, error) {
+	var fields []sortField
+	for _, item := range q.Sort {
+		switch item := item.(type) {
+		case string:
+			fields = append(fields, sortField{field: item})
+		case map[string]any:
+			for field, direction := range item {
+				if direction != "asc" && direction != "desc" {
+					return nil, fmt.Errorf("invalid sort direction %v of %s", direction, field)
+				}
+				fields = append(fields, sortField{field: field, descending: direction == "desc"})
+			}
+		default:
+			return nil, fmt.Errorf("invalid sort %v", item)
+		}
+	}
+	return fields, nil
+}
+
+// run returns the documents of the state matching the selector, sorted and projected, ordered by key when unsorted
+func (q *richQuery) run(namespace string, state map[string][]byte) ([]*queryresult.KV, error) {
+	sortFields, err := q.sortFields()
+	if err != nil {
+		return nil, err
+	}
+
+	type match struct {
+		key string
+		doc map[string]any
+	}
+	var matches []match
+	for _, key := range slices.Sorted(maps.Keys(state)) {
+		var doc map[string]any
+		// values that are not JSON objects are stored as attachments by CouchDB and never match
+		if json.Unmarshal(state[key], &doc) != nil || doc == nil {
+			continue
+		}
+		ok, err := chaincode.MatchSelector(key, doc, q.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, match{key: key, doc: doc})
+		}
+	}
+
+	slices.SortStableFunc(matches, func(a match, b match) int {
+		for _, sort := range sortFields {
+			aValue, _ := chaincode.LookupField(a.key, a.doc, sort.field)
+			bValue, _ := chaincode.LookupField(b.key, b.doc, sort.field)
+			if c := chaincode.Collate(aValue, bValue); c != 0 {
+				if sort.descending {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	})
+
+	results := make([]*queryresult.KV, 0, len(matches))
+	for _, m := range matches {
+		value := state[m.key]
+		if len(q.Fields) > 0 {
+			projected := make(map[string]any, len(q.Fields))
+			for _, field := range q.Fields {
+				if fieldValue, ok := chaincode.LookupField(m.key, m.doc, field); ok {
+					projected[field] = fieldValue
+				}
+			}
+			if value, err = json.Marshal(projected); err != nil {
+				return nil, fmt.Errorf("failed to project %s: %w", m.key, err)
+			}
+		}
+		results = append(results, &queryresult.KV{Namespace: namespace, Key: m.key, Value: value})
+	}
+	return results, nil
+}
+
+// window applies the skip and limit of the query
+func (q *richQuery) window(results []*queryresult.KV) []*queryresult.KV {
+	results = results[min(max(q.Skip, 0), len(results)):]
+	if q.Limit != nil && *q.Limit >= 0 && len(results) > *q.Limit {
+		results = results[:*q.Limit]
+	}
+	return results
+}
@@ -0,0 +1,269 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// scoreChangedEvent is the chaincode event emitted by the transactions changing reliability scores
+const scoreChangedEvent = "ReliabilityScoreChanged"
+
+// reliabilityRecord is a reliability record as stored by the chaincode
+type reliabilityRecord struct {
+	LogID            string  `json:"logID"`
+	LoggerID         string  `json:"loggerID"`
+	Type             string  `json:"type"`
+	Input            string  `json:"input"`
+	InputFrom        string  `json:"inputFrom"`
+	Output           string  `json:"output"`
+	OutputTo         string  `json:"outputTo"`
+	ReliabilityScore float32 `json:"reliabilityScore"`
+	Timestamp        string  `json:"timestamp"`
+	Reserved         string  `json:"reserved"`
+}
+
+// scoreChange is an entry of the ReliabilityScoreChanged event, without record when the change was a delta
+type scoreChange struct {
+	DataSourceID string             `json:"dataSourceID"`
+	Record       *reliabilityRecord `json:"record,omitempty"`
+}
+
+// reliabilityScore is an entry of the result of the ReadReliabilityRecords transaction
+type reliabilityScore struct {
+	DataSourceID     string             `json:"dataSourceID"`
+	Found            bool               `json:"found"`
+	ReliabilityScore float32            `json:"reliabilityScore"`
+	Record           *reliabilityRecord `json:"record,omitempty"`
+}
+
+// ScoreCacheStats are the counters of the reliability record cache
+type ScoreCacheStats struct {
+	Enabled        bool   `json:"enabled" doc:"Whether the cache is enabled"`
+	Listening      bool   `json:"listening" doc:"Whether the chaincode events are received, the cache is bypassed otherwise"`
+	Entries        int    `json:"entries" doc:"Number of cached reliability records"`
+	Hits           uint64 `json:"hits" doc:"Reads served from the cache"`
+	Misses         uint64 `json:"misses" doc:"Reads sent to the ledger because the record was not cached, too old or the events were not received"`
+	Bypasses       uint64 `json:"bypasses" doc:"Reads sent to the ledger because the caller asked for it"`
+	Updates        uint64 `json:"updates" doc:"Records replaced from a chaincode event"`
+	Invalidations  uint64 `json:"invalidations" doc:"Records dropped because of a chaincode event or a local write"`
+	LastEventBlock uint64 `json:"lastEventBlock" doc:"Block of the last chaincode event received"`
+}
+
+type cacheEntry struct {
+	record   reliabilityRecord
+	storedAt time.Time
+}
+
+// scoreCache caches the reliability records read from the ledger. The entries are only trusted while the
+// chaincode events are received, which replace or drop the records changed by every committed transaction.
+type scoreCache struct {
+	mu      sync.Mutex
+	maxAge  time.Duration
+	entries map[string]cacheEntry
+	// generation changes with every event, a read started before an event must not fill the cache
+	generation uint64
+	stats      ScoreCacheStats
+}
+
+var cache *scoreCache
+
+// StartScoreCache enables the reliability record cache, which listens to the chaincode events until the
+// context is done. Entries older than maxAge are read again from the ledger.
+func StartScoreCache(ctx context.Context, maxAge time.Duration) {
+	cache = &scoreCache{
+		maxAge:  maxAge,
+		entries: make(map[string]cacheEntry),
+		stats:   ScoreCacheStats{Enabled: true},
+	}
+	go cache.listen(ctx)
+}
+
+// GetScoreCacheStats returns the counters of the reliability record cache
+func GetScoreCacheStats() ScoreCacheStats {
+	if cache == nil {
+		return ScoreCacheStats{}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	stats := cache.stats
+	stats.Entries = len(cache.entries)
+	return stats
+}
+
+type cacheBypassKey struct{}
+
+// WithCacheBypass returns a context whose reads go to the ledger, for callers needing the latest committed state
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+// listen receives the chaincode events from the active peer and reconnects when the stream ends
+func (c *scoreCache) listen(ctx context.Context) {
+	var lastErr string
+	for {
+		// only report a failure once while it lasts
+		if err := c.receiveEvents(ctx); err != nil && ctx.Err() == nil && err.Error() != lastErr {
+			slog.Warn("Score cache is not receiving chaincode events, reads go to the ledger", "error", err)
+			lastErr = err.Error()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(gatewayConfig.HealthCheckInterval):
+		}
+	}
+}
+
+// receiveEvents warms the cache and applies the chaincode events until the stream ends
+func (c *scoreCache) receiveEvents(ctx context.Context) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := chaincodeEvents(streamCtx, nil)
+	if err != nil {
+		return err
+	}
+
+	// the events of the transactions committed during the warm up are applied once it is done
+	c.setListening(true)
+	defer c.setListening(false)
+	if err := c.warm(ctx); err != nil {
+		return err
+	}
+
+	for event := range events {
+		if event.EventName == scoreChangedEvent {
+			c.apply(event)
+		}
+	}
+	return fmt.Errorf("chaincode event stream closed")
+}
+
+// warm loads every reliability record into the cache
+func (c *scoreCache) warm(ctx context.Context) error {
+	generation := c.currentGeneration()
+	result, err := GetAllReliabilityRecords(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to warm up the score cache: %w", err)
+	}
+
+	var records []reliabilityRecord
+	if err := json.Unmarshal([]byte(result), &records); err != nil {
+		return fmt.Errorf("failed to parse reliability records: %w", err)
+	}
+	c.fill(generation, records...)
+	slog.Info("Score cache warmed up", "records", len(records))
+	return nil
+}
+
+// apply replaces the records set by the transaction of the event and drops the ones changed by a delta
+func (c *scoreCache) apply(event *client.ChaincodeEvent) {
+	var changes []scoreChange
+	if err := json.Unmarshal(event.Payload, &changes); err != nil {
+		slog.Warn("Failed to parse score change event", "txID", event.TransactionID, "error", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if changes == nil {
+		// the changed records are unknown, drop them all
+		clear(c.entries)
+	}
+	c.stats.LastEventBlock = event.BlockNumber
+	for _, change := range changes {
+		if change.Record != nil {
+			c.entries[change.DataSourceID] = cacheEntry{record: *change.Record, storedAt: time.Now()}
+			c.stats.Updates++
+			continue
+		}
+		delete(c.entries, change.DataSourceID)
+		c.stats.Invalidations++
+	}
+}
+
+// setListening records whether the events are received, the entries are dropped when they stop
+func (c *scoreCache) setListening(listening bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Listening = listening
+	if !listening {
+		c.generation++
+		clear(c.entries)
+	}
+}
+
+// get returns the cached record, unless the caller bypasses the cache or the entry cannot be trusted
+func (c *scoreCache) get(ctx context.Context, dataSourceID string) (reliabilityRecord, bool) {
+	if c == nil {
+		return reliabilityRecord{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bypass, _ := ctx.Value(cacheBypassKey{}).(bool); bypass {
+		c.stats.Bypasses++
+		return reliabilityRecord{}, false
+	}
+
+	entry, ok := c.entries[dataSourceID]
+	if !ok || !c.stats.Listening || time.Since(entry.storedAt) > c.maxAge {
+		c.stats.Misses++
+		return reliabilityRecord{}, false
+	}
+	c.stats.Hits++
+	return entry.record, true
+}
+
+// currentGeneration is taken before a ledger read whose result fills the cache
+func (c *scoreCache) currentGeneration() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// fill caches the records read from the ledger, unless an event arrived since the read started
+func (c *scoreCache) fill(generation uint64, records ...reliabilityRecord) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation || !c.stats.Listening {
+		return
+	}
+	for _, record := range records {
+		if record.Type == "reliability" {
+			c.entries[record.LogID] = cacheEntry{record: record, storedAt: time.Now()}
+		}
+	}
+}
+
+// invalidate drops the records written by this server, so its callers read their own writes
+func (c *scoreCache) invalidate(dataSourceIDs ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, dataSourceID := range dataSourceIDs {
+		if _, ok := c.entries[dataSourceID]; ok {
+			delete(c.entries, dataSourceID)
+			c.stats.Invalidations++
+		}
+	}
+}
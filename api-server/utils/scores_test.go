@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScoreDeltasKeepTheCheckpoint(t *testing.T) {
+	l := useMemoryLedger(t)
+	ctx := context.Background()
+
+	if err := CreateReliabilityRecord(ctx, "source1", "digest1", ""); err != nil {
+		t.Fatalf("CreateReliabilityRecord: %v", err)
+	}
+	for _, delta := range []float32{-10, -5, 2.5} {
+		if err := UpdateReliabilityRecord(ctx, "source1", delta, true, "feedback"); err != nil {
+			t.Fatalf("UpdateReliabilityRecord(%v): %v", delta, err)
+		}
+	}
+
+	if record := readReliabilityRecord(t, "source1"); record.ReliabilityScore != 87.5 {
+		t.Errorf("effective score = %v, want 87.5", record.ReliabilityScore)
+	}
+	// the reliability record itself still holds the checkpoint, each delta has its own key
+	var checkpoint reliabilityRecord
+	if err := json.Unmarshal(l.state["source1"], &checkpoint); err != nil {
+		t.Fatalf("failed to parse the checkpoint: %v", err)
+	}
+	if checkpoint.ReliabilityScore != 100 {
+		t.Errorf("checkpoint score = %v, want 100", checkpoint.ReliabilityScore)
+	}
+	if deltas := countScoreDeltas(l, "source1"); deltas != 3 {
+		t.Errorf("%d deltas stored, want 3", deltas)
+	}
+}
+
+func TestCompactionFoldsTheDeltas(t *testing.T) {
+	l := useMemoryLedger(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"source1", "source2"} {
+		if err := CreateReliabilityRecord(ctx, id, "digest", ""); err != nil {
+			t.Fatalf("CreateReliabilityRecord(%s): %v", id, err)
+		}
+		if err := UpdateReliabilityRecord(ctx, id, -20, true, "feedback"); err != nil {
+			t.Fatalf("UpdateReliabilityRecord(%s): %v", id, err)
+		}
+	}
+
+	if err := CompactReliabilityRecord(ctx, "source1"); err != nil {
+		t.Fatalf("CompactReliabilityRecord: %v", err)
+	}
+	if deltas := countScoreDeltas(l, "source1"); deltas != 0 {
+		t.Errorf("%d deltas left after compaction, want 0", deltas)
+	}
+	if deltas := countScoreDeltas(l, "source2"); deltas != 1 {
+		t.Errorf("compacting source1 left %d deltas of source2, want 1", deltas)
+	}
+	if record := readReliabilityRecord(t, "source1"); record.ReliabilityScore != 80 {
+		t.Errorf("score after compaction = %v, want 80", record.ReliabilityScore)
+	}
+
+	if err := CompactAllReliabilityRecords(ctx); err != nil {
+		t.Fatalf("CompactAllReliabilityRecords: %v", err)
+	}
+	if deltas := countScoreDeltas(l, "source2"); deltas != 0 {
+		t.Errorf("%d deltas of source2 left after compacting all, want 0", deltas)
+	}
+	if record := readReliabilityRecord(t, "source2"); record.ReliabilityScore != 80 {
+		t.Errorf("score after compacting all = %v, want 80", record.ReliabilityScore)
+	}
+}
+
+func TestAbsoluteScoreDiscardsTheDeltas(t *testing.T) {
+	l := useMemoryLedger(t)
+	ctx := context.Background()
+
+	if err := CreateReliabilityRecord(ctx, "source1", "digest1", ""); err != nil {
+		t.Fatalf("CreateReliabilityRecord: %v", err)
+	}
+	if err := UpdateReliabilityRecord(ctx, "source1", -30, true, "feedback"); err != nil {
+		t.Fatalf("UpdateReliabilityRecord: %v", err)
+	}
+	if err := UpdateReliabilityRecord(ctx, "source1", 42, false, "reset"); err != nil {
+		t.Fatalf("UpdateReliabilityRecord: %v", err)
+	}
+
+	if record := readReliabilityRecord(t, "source1"); record.ReliabilityScore != 42 {
+		t.Errorf("score = %v, want 42", record.ReliabilityScore)
+	}
+	if deltas := countScoreDeltas(l, "source1"); deltas != 0 {
+		t.Errorf("%d deltas left after an absolute score, want 0", deltas)
+	}
+}
+
+func TestDeltaForUnknownSourceFails(t *testing.T) {
+	l := useMemoryLedger(t)
+	ctx := context.Background()
+	// a log record is not a reliability record, even with the ID of a data source
+	if _, err := CreateLogRecord(ctx, "log1", "LLM0", "in", "reranker0", "answer", "user", "2025-01-01", ""); err != nil {
+		t.Fatalf("CreateLogRecord: %v", err)
+	}
+
+	for _, id := range []string{"missing", "log1"} {
+		for _, isDelta := range []bool{true, false} {
+			err := UpdateReliabilityRecord(ctx, id, -1, isDelta, "feedback")
+			if err == nil || !strings.Contains(err.Error(), "does not exist") {
+				t.Errorf("UpdateReliabilityRecord(%s, delta %v) = %v, want a does not exist error", id, isDelta, err)
+			}
+		}
+	}
+	if n := countScoreDeltas(l, "log1"); n != 0 {
+		t.Errorf("%d deltas written for the log record, want none", n)
+	}
+	if _, err := GetReliabilityRecord(ctx, "log1"); err == nil {
+		t.Error("GetReliabilityRecord returned the log record")
+	}
+	if err := CompactReliabilityRecord(ctx, "log1"); err == nil {
+		t.Error("CompactReliabilityRecord compacted the log record")
+	}
+}
+
+func TestScoreQueriesSeePendingDeltas(t *testing.T) {
+	useMemoryLedger(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"source1", "source2", "source3"} {
+		if err := CreateReliabilityRecord(ctx, id, "digest", ""); err != nil {
+			t.Fatalf("CreateReliabilityRecord(%s): %v", id, err)
+		}
+	}
+	// source1 drops below 50 with deltas only, source2 with a compacted score, source3 stays at 100
+	if err := UpdateReliabilityRecord(ctx, "source1", -60, true, "feedback"); err != nil {
+		t.Fatalf("UpdateReliabilityRecord: %v", err)
+	}
+	if err := UpdateReliabilityRecord(ctx, "source2", 30, false, "reset"); err != nil {
+		t.Fatalf("UpdateReliabilityRecord: %v", err)
+	}
+
+	query := `{"selector": {"type": "reliability", "reliabilityScore": {"$lt": 50}}, "sort": [{"logID": "asc"}]}`
+	result, err := GetRecordWithSelector(ctx, query)
+	if err != nil {
+		t.Fatalf("GetRecordWithSelector: %v", err)
+	}
+	if got := recordIDs(t, result); strings.Join(got, ",") != "source1,source2" {
+		t.Errorf("query returned %v, want [source1 source2]", got)
+	}
+
+	page, err := QueryRecordsPage(ctx, query, 10, "")
+	if err != nil {
+		t.Fatalf("QueryRecordsPage: %v", err)
+	}
+	var parsed struct {
+		Records []reliabilityRecord `json:"records"`
+	}
+	if err := json.Unmarshal([]byte(page), &parsed); err != nil {
+		t.Fatalf("failed to parse the page %s: %v", page, err)
+	}
+	if len(parsed.Records) != 2 || parsed.Records[0].LogID != "source1" || parsed.Records[0].ReliabilityScore != 40 {
+		t.Errorf("page = %+v, want source1 at 40 and source2", parsed.Records)
+	}
+
+	// a record selected by another field is not dropped by the widened query
+	result, err = GetRecordWithSelector(ctx, `{"selector": {"$or": [{"logID": "source3"}, {"reliabilityScore": {"$lte": 40}}]}}`)
+	if err != nil {
+		t.Fatalf("GetRecordWithSelector: %v", err)
+	}
+	if got := recordIDs(t, result); strings.Join(got, ",") != "source1,source2,source3" {
+		t.Errorf("query returned %v, want [source1 source2 source3]", got)
+	}
+}
+
+// countScoreDeltas counts the score deltas of a data source in the world state
+func countScoreDeltas(l *MemoryLedger, dataSourceID string) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	count := 0
+	for key := range l.state {
+		if strings.HasPrefix(key, "\x00scoredelta\x00"+dataSourceID+"\x00") {
+			count++
+		}
+	}
+	return count
+}
+
+// recordIDs returns the log IDs of a JSON list of records
+func recordIDs(t *testing.T, result string) []string {
+	t.Helper()
+	var records []reliabilityRecord
+	if err := json.Unmarshal([]byte(result), &records); err != nil {
+		t.Fatalf("failed to parse the records %s: %v", result, err)
+	}
+	var ids []string
+	for _, record := range records {
+		ids = append(ids, record.LogID)
+	}
+	return ids
+}
+
+func TestScoresOfUnknownSources(t *testing.T) {
+	useMemoryLedger(t)
+	ctx := context.Background()
+	if err := CreateReliabilityRecord(ctx, "known", "digest", ""); err != nil {
+		t.Fatalf("CreateReliabilityRecord: %v", err)
+	}
+
+	result, err := GetReliabilityScores(ctx, []string{"known", "unknown"})
+	if err != nil {
+		t.Fatalf("GetReliabilityScores: %v", err)
+	}
+	var scores []reliabilityScore
+	if err := json.Unmarshal([]byte(result), &scores); err != nil {
+		t.Fatalf("failed to parse the scores %s: %v", result, err)
+	}
+	if len(scores) != 2 || !scores[0].Found || scores[0].ReliabilityScore != 100 || scores[1].Found || scores[1].Record != nil {
+		t.Errorf("scores = %+v, want known at 100 and unknown not found", scores)
+	}
+}
+
+func TestFailedCompactionsAreCounted(t *testing.T) {
+	l := &flakyLedger{MemoryLedger: useMemoryLedger(t)}
+	l.down.Store(true)
+	UseLedger(DefaultGatewayConfig(), l)
+	failures := chaincodeErrors.WithLabelValues("CompactReliabilityScores", phaseCompaction, "Unavailable")
+	before := testutil.ToFloat64(failures)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartCompaction(ctx, time.Millisecond)
+	for deadline := time.Now().Add(5 * time.Second); testutil.ToFloat64(failures) == before; {
+		if time.Now().After(deadline) {
+			t.Fatal("the failed compactions were not counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
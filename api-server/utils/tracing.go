@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The exporters of the traces
+const (
+	TraceExporterNone = "none"
+	TraceExporterOTLP = "otlp"
+	TraceExporterFile = "file"
+)
+
+// tracer creates the spans of the chaincode calls, a no-op until InitTracing sets the tracer provider
+var tracer = otel.Tracer("draglog_api/utils")
+
+// TracingConfig selects where the spans are exported
+type TracingConfig struct {
+	// Exporter is none, otlp or file
+	Exporter string
+	// Endpoint is the URL of the OTLP gRPC collector, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317 if empty
+	Endpoint string
+	// File is the path of the file the spans are appended to, one JSON object per line
+	File string
+}
+
+// InitTracing sets the tracer provider exporting the spans and the W3C trace context propagator. Every trace
+// is recorded unless the caller did not sample it or OTEL_TRACES_SAMPLER sets another sampler.
+// The returned function flushes the spans not yet exported and stops the exporter.
+func InitTracing(ctx context.Context, config TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch config.Exporter {
+	case "", TraceExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case TraceExporterOTLP:
+		var options []otlptracegrpc.Option
+		if config.Endpoint != "" {
+			options = append(options, otlptracegrpc.WithEndpointURL(config.Endpoint))
+		}
+		var err error
+		if exporter, err = otlptracegrpc.New(ctx, options...); err != nil {
+			return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
+		}
+	case TraceExporterFile:
+		if err := os.MkdirAll(filepath.Dir(config.File), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create the trace file directory: %w", err)
+		}
+		var err error
+		if file, err = os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, fmt.Errorf("failed to open the trace file: %w", err)
+		}
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(file)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to create the file exporter: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected none, otlp or file", config.Exporter)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("draglog-api")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// startChaincodeSpan starts the span of a chaincode call, the parent of the spans of its steps
+func startChaincodeSpan(ctx context.Context, kind string, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, kind+" "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("fabric.chaincode.function", name)),
+	)
+}
+
+// endSpan ends a span, recording the error that ended it if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// step runs a step of a chaincode call, such as the endorsement, in a child span of the call
+func step[T any](ctx context.Context, name string, fn func(context.Context) (T, error)) (T, error) {
+	ctx, span := tracer.Start(ctx, name)
+	result, err := fn(ctx)
+	endSpan(span, err)
+	return result, err
+}
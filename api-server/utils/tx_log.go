@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// TxLogEntry is a transaction committed by the local ledger, one JSON line of the transaction log.
+// Hash covers the entry with an empty Hash, PrevHash is the hash of the previous entry, so changing,
+// removing or reordering entries breaks the chain.
+type TxLogEntry struct {
+	Block     uint64       `json:"block"`
+	TxID      string       `json:"txID"`
+	Timestamp time.Time    `json:"timestamp"`
+	Caller    string       `json:"caller,omitempty"`
+	Function  string       `json:"function"`
+	Args      []string     `json:"args"`
+	Writes    []TxLogWrite `json:"writes"`
+	Event     *TxLogEvent  `json:"event,omitempty"`
+	PrevHash  string       `json:"prevHash"`
+	Hash      string       `json:"hash,omitempty"`
+}
+
+// TxLogWrite is a key written or deleted by a transaction. JSON values are kept readable in Value,
+// any other value is kept base64 encoded in RawValue.
+type TxLogWrite struct {
+	Key      string          `json:"key"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	RawValue []byte          `json:"rawValue,omitempty"`
+	IsDelete bool            `json:"isDelete,omitempty"`
+}
+
+// TxLogEvent is the chaincode event set by a transaction
+type TxLogEvent struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// RawPayload holds a payload that is not JSON
+	RawPayload []byte `json:"rawPayload,omitempty"`
+}
+
+// newTxLogWrite returns the log form of a write, the value is readable when it is compact JSON
+func newTxLogWrite(key string, write stateWrite) TxLogWrite {
+	logWrite := TxLogWrite{Key: key, IsDelete: write.isDelete}
+	if !write.isDelete {
+		logWrite.Value, logWrite.RawValue = splitJSON(write.value)
+	}
+	return logWrite
+}
+
+// value returns the bytes written
+func (w TxLogWrite) value() []byte {
+	if w.Value != nil {
+		return []byte(w.Value)
+	}
+	if w.RawValue == nil && !w.IsDelete {
+		return []byte{}
+	}
+	return w.RawValue
+}
+
+// payload returns the bytes of the event payload
+func (e *TxLogEvent) payload() []byte {
+	if e.Payload != nil {
+		return []byte(e.Payload)
+	}
+	return e.RawPayload
+}
+
+// splitJSON returns data as a JSON value when encoding it keeps the same bytes, as raw bytes otherwise
+func splitJSON(data []byte) (json.RawMessage, []byte) {
+	var compacted bytes.Buffer
+	if len(data) > 0 && json.Compact(&compacted, data) == nil && bytes.Equal(compacted.Bytes(), data) {
+		return json.RawMessage(data), nil
+	}
+	return nil, data
+}
+
+// computeHash returns the hash of the entry with an empty Hash
+func (e TxLogEntry) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal block %d: %w", e.Block, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// txLog appends the committed transactions to the transaction log file
+type txLog struct {
+	file     *os.File
+	lastHash string
+}
+
+// append chains the entry to the previous one and writes it to disk before returning
+func (t *txLog) append(entry *TxLogEntry) error {
+	entry.PrevHash = t.lastHash
+	hash, err := entry.computeHash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal block %d: %w", entry.Block, err)
+	}
+	if _, err := t.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write block %d to the transaction log: %w", entry.Block, err)
+	}
+	if err := t.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync the transaction log: %w", err)
+	}
+	t.lastHash = hash
+	return nil
+}
+
+func (t *txLog) close() error {
+	return t.file.Close()
+}
+
+// ErrTxLogCorrupted is returned when the transaction log cannot be parsed or its hash chain is broken
+var ErrTxLogCorrupted = errors.New("transaction log is corrupted")
+
+// ReadTxLog reads the transaction log, checking the hash chain and the block numbers, and calls fn for every entry.
+// It returns the size of the valid part of the log: an incomplete last line, left by a crash during a write,
+// is not an error and is not counted, as the transaction was never acknowledged.
+func ReadTxLog(path string, fn func(entry *TxLogEntry) error) (int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open the transaction log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var size int64
+	var previous *TxLogEntry
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		}
+		if err != nil {
+			return size, fmt.Errorf("failed to read the transaction log: %w", err)
+		}
+
+		var entry TxLogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return size, fmt.Errorf("%w: block after %d: %v", ErrTxLogCorrupted, blockOf(previous), err)
+		}
+		if err := checkTxLogEntry(previous, &entry); err != nil {
+			return size, err
+		}
+		if err := fn(&entry); err != nil {
+			return size, err
+		}
+		size += int64(len(line))
+		previous = &entry
+	}
+}
+
+// checkTxLogEntry checks that the entry follows the previous one and has its own hash
+func checkTxLogEntry(previous *TxLogEntry, entry *TxLogEntry) error {
+	wantBlock, wantPrevHash := uint64(1), ""
+	if previous != nil {
+		wantBlock, wantPrevHash = previous.Block+1, previous.Hash
+	}
+	if entry.Block != wantBlock {
+		return fmt.Errorf("%w: expected block %d, found block %d", ErrTxLogCorrupted, wantBlock, entry.Block)
+	}
+	if entry.PrevHash != wantPrevHash {
+		return fmt.Errorf("%w: block %d does not follow block %d", ErrTxLogCorrupted, entry.Block, blockOf(previous))
+	}
+	hash, err := entry.computeHash()
+	if err != nil {
+		return err
+	}
+	if entry.Hash != hash {
+		return fmt.Errorf("%w: block %d was modified", ErrTxLogCorrupted, entry.Block)
+	}
+	return nil
+}
+
+func blockOf(entry *TxLogEntry) uint64 {
+	if entry == nil {
+		return 0
+	}
+	return entry.Block
+}
+
+// VerifyTxLog checks the whole transaction log and returns the number of blocks and the hash of the last one
+func VerifyTxLog(path string) (uint64, string, error) {
+	var blocks uint64
+	var lastHash string
+	_, err := ReadTxLog(path, func(entry *TxLogEntry) error {
+		blocks, lastHash = entry.Block, entry.Hash
+		return nil
+	})
+	return blocks, lastHash, err
+}
+
+// ReplayResult is the outcome of replaying a block of the transaction log
+type ReplayResult struct {
+	Entry  *TxLogEntry
+	Result []byte
+	Err    error
+}
+
+// ReplayTxLog submits the transactions of the log from fromBlock to the active ledger, in order and as their
+// original caller, and reports each outcome to fn. It stops at the first failed transaction, or when fn returns false.
+// With dryRun nothing is submitted, fn gets every transaction that would be.
+func ReplayTxLog(ctx context.Context, path string, fromBlock uint64, dryRun bool, fn func(result ReplayResult) bool) error {
+	var target Ledger
+	if !dryRun {
+		var err error
+		if target, err = activeLedger(); err != nil {
+			return err
+		}
+	}
+
+	errStop := errors.New("replay stopped")
+	_, err := ReadTxLog(path, func(entry *TxLogEntry) error {
+		if entry.Block < fromBlock {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		result := ReplayResult{Entry: entry}
+		if !dryRun {
+			result.Result, result.Err = target.Submit(WithCaller(ctx, entry.Caller), entry.Function, entry.Args...)
+		}
+		if !fn(result) || result.Err != nil {
+			return errStop
+		}
+		return nil
+	})
+	if errors.Is(err, errStop) {
+		return nil
+	}
+	return err
+}
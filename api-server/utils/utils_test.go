@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// useMemoryLedger sends the transactions of the test to a new memory ledger
+func useMemoryLedger(t *testing.T) *MemoryLedger {
+	t.Helper()
+	l, err := InitMemoryLedger(DefaultGatewayConfig())
+	if err != nil {
+		t.Fatalf("InitMemoryLedger: %v", err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+		ledger = nil
+	})
+	return l
+}
+
+// readReliabilityRecord reads the reliability record of a data source from the ledger
+func readReliabilityRecord(t *testing.T, dataSourceID string) reliabilityRecord {
+	t.Helper()
+	result, err := GetReliabilityRecord(context.Background(), dataSourceID)
+	if err != nil {
+		t.Fatalf("GetReliabilityRecord(%s): %v", dataSourceID, err)
+	}
+	var record reliabilityRecord
+	if err := json.Unmarshal([]byte(result), &record); err != nil {
+		t.Fatalf("failed to parse the record %s: %v", result, err)
+	}
+	return record
+}
@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// ErrUnknownIdentity is returned when a caller has no identity in the wallet
+var ErrUnknownIdentity = errors.New("no wallet identity for caller")
+
+// WalletIdentity is an X.509 identity stored in the wallet, in the file format of the Fabric SDK wallets
+type WalletIdentity struct {
+	Credentials struct {
+		Certificate string `json:"certificate"`
+		PrivateKey  string `json:"privateKey"`
+	} `json:"credentials"`
+	MSPID   string `json:"mspId"`
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+}
+
+// FileWallet stores one identity per `<label>.id` file in a directory
+type FileWallet struct {
+	dir string
+}
+
+// NewFileWallet opens the wallet in the directory, creating the directory if needed
+func NewFileWallet(dir string) (*FileWallet, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create wallet directory: %w", err)
+	}
+	return &FileWallet{dir: dir}, nil
+}
+
+// Get reads the identity stored under the label
+func (w *FileWallet) Get(label string) (*WalletIdentity, error) {
+	data, err := os.ReadFile(w.path(label))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIdentity, label)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet identity %s: %w", label, err)
+	}
+
+	var walletIdentity WalletIdentity
+	if err := json.Unmarshal(data, &walletIdentity); err != nil {
+		return nil, fmt.Errorf("failed to parse wallet identity %s: %w", label, err)
+	}
+	if walletIdentity.Type != "X.509" {
+		return nil, fmt.Errorf("wallet identity %s has unsupported type %q", label, walletIdentity.Type)
+	}
+	return &walletIdentity, nil
+}
+
+// Put stores the identity under the label, replacing any existing one
+func (w *FileWallet) Put(label string, walletIdentity *WalletIdentity) error {
+	data, err := json.Marshal(walletIdentity)
+	if err != nil {
+		return fmt.Errorf("failed to marshal wallet identity %s: %w", label, err)
+	}
+	return os.WriteFile(w.path(label), data, 0600)
+}
+
+// List returns the labels of every identity in the wallet
+func (w *FileWallet) List() ([]string, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet directory: %w", err)
+	}
+
+	var labels []string
+	for _, entry := range entries {
+		if label, ok := strings.CutSuffix(entry.Name(), ".id"); ok && !entry.IsDir() {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+func (w *FileWallet) path(label string) string {
+	return filepath.Join(w.dir, filepath.Base(label)+".id")
+}
+
+// NewWalletIdentity reads a certificate and a private key PEM file into a wallet identity
+func NewWalletIdentity(mspID string, certFile string, keyFile string) (*WalletIdentity, error) {
+	certificatePEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	if _, err := identity.CertificateFromPEM(certificatePEM); err != nil {
+		return nil, err
+	}
+
+	privateKeyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+	if _, err := identity.PrivateKeyFromPEM(privateKeyPEM); err != nil {
+		return nil, err
+	}
+
+	walletIdentity := &WalletIdentity{MSPID: mspID, Type: "X.509", Version: 1}
+	walletIdentity.Credentials.Certificate = string(certificatePEM)
+	walletIdentity.Credentials.PrivateKey = string(privateKeyPEM)
+	return walletIdentity, nil
+}
+
+type callerKey struct{}
+
+// WithCaller returns a context carrying the ID of the API caller
+func WithCaller(ctx context.Context, callerID string) context.Context {
+	return context.WithValue(ctx, callerKey{}, callerID)
+}
+
+// CallerFromContext returns the ID of the API caller, empty when the caller is anonymous
+func CallerFromContext(ctx context.Context) string {
+	callerID, _ := ctx.Value(callerKey{}).(string)
+	return callerID
+}
+
+// pooledContract is a Gateway connection signing with one wallet identity through one peer
+type pooledContract struct {
+	gateway  *client.Gateway
+	contract *client.Contract
+	lastUsed time.Time
+}
+
+type poolKey struct {
+	label string
+	peer  int
+}
+
+var (
+	wallet     *FileWallet
+	poolMu     sync.Mutex
+	walletPool = make(map[poolKey]*pooledContract)
+)
+
+// ContractFor returns the contract of the active peer signing with the wallet identity of the caller.
+// Anonymous callers, and every caller when no wallet is configured, use the identity of the gateway config.
+func ContractFor(ctx context.Context) (*client.Contract, error) {
+	callerID := CallerFromContext(ctx)
+	if callerID == "" || wallet == nil {
+		return Contract()
+	}
+
+	gatewayMu.RLock()
+	if activePeer >= len(peers) {
+		gatewayMu.RUnlock()
+		return nil, ErrNoPeer
+	}
+	config := gatewayConfig
+	peerIndex := activePeer
+	peer := peers[activePeer]
+	gatewayMu.RUnlock()
+
+	label := callerID
+	if mapped, ok := config.Identities[callerID]; ok {
+		label = mapped
+	}
+	key := poolKey{label: label, peer: peerIndex}
+
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if pooled, ok := walletPool[key]; ok {
+		pooled.lastUsed = time.Now()
+		return pooled.contract, nil
+	}
+
+	walletIdentity, err := wallet.Get(label)
+	if err != nil {
+		return nil, err
+	}
+	gw, err := connectWalletIdentity(config, peer, walletIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect as %s: %w", label, err)
+	}
+
+	if len(walletPool) >= config.IdentityPoolSize {
+		evictLeastRecentlyUsed()
+	}
+	pooled := &pooledContract{
+		gateway:  gw,
+		contract: gw.GetNetwork(config.ChannelName).GetContract(config.ChaincodeName),
+		lastUsed: time.Now(),
+	}
+	walletPool[key] = pooled
+	return pooled.contract, nil
+}
+
+// connectWalletIdentity creates a Gateway for the identity over the existing gRPC connection of the peer
+func connectWalletIdentity(config *GatewayConfig, peer *peerConnection, walletIdentity *WalletIdentity) (*client.Gateway, error) {
+	certificate, err := identity.CertificateFromPEM([]byte(walletIdentity.Credentials.Certificate))
+	if err != nil {
+		return nil, err
+	}
+	id, err := identity.NewX509Identity(walletIdentity.MSPID, certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := identity.PrivateKeyFromPEM([]byte(walletIdentity.Credentials.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	sign, err := identity.NewPrivateKeySign(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Connect(
+		id,
+		client.WithSign(sign),
+		client.WithClientConnection(peer.conn),
+		client.WithEvaluateTimeout(config.EvaluateTimeout),
+		client.WithEndorseTimeout(config.EndorseTimeout),
+		client.WithSubmitTimeout(config.SubmitTimeout),
+		client.WithCommitStatusTimeout(config.CommitStatusTimeout),
+	)
+}
+
+// evictLeastRecentlyUsed closes the pooled connection that was used the longest time ago, poolMu must be held
+func evictLeastRecentlyUsed() {
+	var oldestKey poolKey
+	var oldest *pooledContract
+	for key, pooled := range walletPool {
+		if oldest == nil || pooled.lastUsed.Before(oldest.lastUsed) {
+			oldestKey, oldest = key, pooled
+		}
+	}
+	if oldest != nil {
+		oldest.gateway.Close()
+		delete(walletPool, oldestKey)
+	}
+}
+
+// closeWalletPool closes every pooled connection
+func closeWalletPool() {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	for key, pooled := range walletPool {
+		pooled.gateway.Close()
+		delete(walletPool, key)
+	}
+}
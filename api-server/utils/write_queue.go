@@ -0,0 +1,500 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WriteQueueFile is the name of the journal in the directory of the write queue
+const WriteQueueFile = "queue.jsonl"
+
+// ErrWriteQueueDisabled is returned by the queue operations when the server runs without a write queue
+var ErrWriteQueueDisabled = errors.New("the write queue is disabled")
+
+// ErrQueuedWriteNotFound is returned when no queued write has the sequence number
+var ErrQueuedWriteNotFound = errors.New("queued write not found")
+
+// ErrQueuedWriteConflict is returned when a write of the record with another content is already queued
+var ErrQueuedWriteConflict = errors.New("another write of the record is already queued")
+
+// QueuedWrite is a write accepted while the ledger was unreachable, waiting to be submitted
+type QueuedWrite struct {
+	Seq        uint64    `json:"seq" doc:"Position of the write in the queue"`
+	LogID      string    `json:"logID" doc:"ID of the record written, the same write of a record already in the queue is not queued again"`
+	Function   string    `json:"function" doc:"Chaincode function submitted"`
+	Args       []string  `json:"args" doc:"Arguments of the chaincode function"`
+	Caller     string    `json:"caller,omitempty" doc:"API caller the transaction is submitted for"`
+	AcceptedAt time.Time `json:"acceptedAt" doc:"Time the write was accepted"`
+	Attempts   int       `json:"attempts" doc:"Number of failed submissions"`
+	LastError  string    `json:"lastError,omitempty" doc:"Error of the last failed submission"`
+	Failed     bool      `json:"failed" doc:"Whether the ledger rejected the write, a failed write is kept until it is retried or deleted"`
+}
+
+// WriteQueueStats are the counters of the write queue
+type WriteQueueStats struct {
+	Enabled    bool   `json:"enabled" doc:"Whether writes are queued while the ledger is unreachable"`
+	Pending    int    `json:"pending" doc:"Writes waiting to be submitted"`
+	Failed     int    `json:"failed" doc:"Writes rejected by the ledger"`
+	Accepted   uint64 `json:"accepted" doc:"Writes queued since the server started"`
+	Submitted  uint64 `json:"submitted" doc:"Queued writes committed since the server started"`
+	Duplicates uint64 `json:"duplicates" doc:"Writes dropped because the record was already queued or on the ledger"`
+	LastDrain  string `json:"lastDrain,omitempty" doc:"Time of the last drain of the queue"`
+	LastError  string `json:"lastError,omitempty" doc:"Reason the last drain stopped early"`
+}
+
+// DrainResult is the outcome of a drain of the write queue
+type DrainResult struct {
+	Submitted  int    `json:"submitted" doc:"Writes committed"`
+	Duplicates int    `json:"duplicates" doc:"Writes dropped because the record was already on the ledger"`
+	Failed     int    `json:"failed" doc:"Writes rejected by the ledger"`
+	Pending    int    `json:"pending" doc:"Writes still waiting"`
+	Stopped    string `json:"stopped,omitempty" doc:"Reason the drain stopped before the queue was empty"`
+}
+
+// queueOp is a change of the queue, one JSON line of the journal
+type queueOp struct {
+	Op    string       `json:"op"`
+	Seq   uint64       `json:"seq"`
+	Write *QueuedWrite `json:"write,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// The changes recorded in the journal
+const (
+	queueOpAccept  = "accept"
+	queueOpAttempt = "attempt"
+	queueOpFail    = "fail"
+	queueOpRetry   = "retry"
+	queueOpDone    = "done"
+	queueOpDelete  = "delete"
+)
+
+// writeQueue keeps the writes accepted while the ledger is unreachable in a journal on disk and submits them
+// in order once the ledger is back. Every change is synced to disk before it is acknowledged.
+type writeQueue struct {
+	// drainMu makes sure one drain runs at a time, so the writes are submitted in order
+	drainMu  sync.Mutex
+	interval time.Duration
+	wake     chan struct{}
+
+	mu      sync.Mutex
+	path    string
+	journal *os.File
+	writes  []*QueuedWrite
+	nextSeq uint64
+	stats   WriteQueueStats
+}
+
+var queue *writeQueue
+
+// StartWriteQueue restores the write queue of the directory and drains it in the background, every interval
+// and after each queued write, until the context is done
+func StartWriteQueue(ctx context.Context, dir string, interval time.Duration) error {
+	q, err := openWriteQueue(dir, interval)
+	if err != nil {
+		return err
+	}
+	if pending := q.count(false); pending > 0 {
+		slog.Info("Write queue restored", "pending", pending)
+	}
+
+	queue = q
+	go q.run(ctx)
+	return nil
+}
+
+// openWriteQueue restores the write queue of the directory from its journal
+func openWriteQueue(dir string, interval time.Duration) (*writeQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the write queue directory: %w", err)
+	}
+
+	q := &writeQueue{
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+		path:     filepath.Join(dir, WriteQueueFile),
+		nextSeq:  1,
+		stats:    WriteQueueStats{Enabled: true},
+	}
+	if err := q.restore(); err != nil {
+		return nil, err
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// CloseWriteQueue closes the journal of the write queue, the queued writes are submitted after the next start
+func CloseWriteQueue() {
+	if queue == nil {
+		return
+	}
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.journal != nil {
+		queue.journal.Close()
+		queue.journal = nil
+	}
+}
+
+// submitOrQueue submits a write of a record, or queues it when the ledger is unreachable or writes are already
+// waiting. It reports whether the write was queued instead of committed.
+func submitOrQueue(ctx context.Context, logID string, name string, args ...string) (bool, error) {
+	if queue == nil {
+		return false, submit(ctx, name, args...)
+	}
+
+	if queue.count(false) == 0 && GetGatewayStatus().Ready {
+		err := submit(ctx, name, args...)
+		if err == nil || !isUnreachable(err) {
+			return false, err
+		}
+		slog.WarnContext(ctx, "Ledger unreachable, queueing the write", "logID", logID, "error", err)
+	}
+	if err := queue.accept(ctx, logID, name, args); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// isUnreachable reports whether a transaction failed because no peer could be reached, rather than being rejected
+func isUnreachable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrNoPeer)
+}
+
+// isDuplicate reports whether a transaction was rejected because the record already exists
+func isDuplicate(err error) bool {
+	return strings.Contains(err.Error(), "already exists")
+}
+
+// accept queues a write unless the same write of the record is already queued, and rejects a write of a
+// queued record with another content
+func (q *writeQueue) accept(ctx context.Context, logID string, name string, args []string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if i := slices.IndexFunc(q.writes, func(write *QueuedWrite) bool { return write.LogID == logID }); i >= 0 {
+		if queued := q.writes[i]; queued.Function != name || !slices.Equal(queued.Args, args) {
+			return fmt.Errorf("%w: %s, queued as write %d", ErrQueuedWriteConflict, logID, queued.Seq)
+		}
+		q.stats.Duplicates++
+		return nil
+	}
+	write := &QueuedWrite{
+		Seq:        q.nextSeq,
+		LogID:      logID,
+		Function:   name,
+		Args:       args,
+		Caller:     CallerFromContext(ctx),
+		AcceptedAt: time.Now().UTC(),
+	}
+	if err := q.record(queueOp{Op: queueOpAccept, Seq: write.Seq, Write: write}); err != nil {
+		return err
+	}
+	q.nextSeq++
+	q.writes = append(q.writes, write)
+	q.stats.Accepted++
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// record appends a change to the journal and syncs it, q.mu must be held
+func (q *writeQueue) record(op queueOp) error {
+	if q.journal == nil {
+		return fmt.Errorf("the write queue is closed")
+	}
+	data, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the write queue change: %w", err)
+	}
+	if _, err := q.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write the write queue journal: %w", err)
+	}
+	if err := q.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync the write queue journal: %w", err)
+	}
+	return nil
+}
+
+// apply applies a change to the queued writes, q.mu must be held
+func (q *writeQueue) apply(op queueOp) {
+	if op.Op == queueOpAccept {
+		if op.Write != nil {
+			q.writes = append(q.writes, op.Write)
+			q.nextSeq = max(q.nextSeq, op.Seq+1)
+		}
+		return
+	}
+
+	i := slices.IndexFunc(q.writes, func(write *QueuedWrite) bool { return write.Seq == op.Seq })
+	if i < 0 {
+		return
+	}
+	write := q.writes[i]
+	switch op.Op {
+	case queueOpAttempt:
+		write.Attempts++
+		write.LastError = op.Error
+	case queueOpFail:
+		write.Attempts++
+		write.LastError = op.Error
+		write.Failed = true
+	case queueOpRetry:
+		write.Failed = false
+	case queueOpDone, queueOpDelete:
+		q.writes = slices.Delete(q.writes, i, i+1)
+	}
+}
+
+// update records a change of a queued write and applies it
+func (q *writeQueue) update(op queueOp) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !slices.ContainsFunc(q.writes, func(write *QueuedWrite) bool { return write.Seq == op.Seq }) {
+		return fmt.Errorf("%w: %d", ErrQueuedWriteNotFound, op.Seq)
+	}
+	if err := q.record(op); err != nil {
+		return err
+	}
+	q.apply(op)
+	return nil
+}
+
+// restore replays the journal, an incomplete last line left by a crash is ignored
+func (q *writeQueue) restore() error {
+	file, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open the write queue journal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the write queue journal: %w", err)
+		}
+		var op queueOp
+		if err := json.Unmarshal(line, &op); err != nil {
+			return fmt.Errorf("failed to parse the write queue journal: %w", err)
+		}
+		q.apply(op)
+	}
+}
+
+// compact rewrites the journal with the queued writes only and opens it for the next changes
+func (q *writeQueue) compact() error {
+	tmpPath := q.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to compact the write queue journal: %w", err)
+	}
+	encoder := json.NewEncoder(tmp)
+	for _, write := range q.writes {
+		if err := encoder.Encode(queueOp{Op: queueOpAccept, Seq: write.Seq, Write: write}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to compact the write queue journal: %w", err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact the write queue journal: %w", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		return fmt.Errorf("failed to compact the write queue journal: %w", err)
+	}
+
+	if q.journal, err = os.OpenFile(q.path, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return fmt.Errorf("failed to open the write queue journal: %w", err)
+	}
+	return nil
+}
+
+// count returns the number of failed writes, or of the writes waiting to be submitted
+func (q *writeQueue) count(failed bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := 0
+	for _, write := range q.writes {
+		if write.Failed == failed {
+			n++
+		}
+	}
+	return n
+}
+
+// head returns a copy of the first write waiting to be submitted, nil if there is none
+func (q *writeQueue) head() *QueuedWrite {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, write := range q.writes {
+		if !write.Failed {
+			head := *write
+			return &head
+		}
+	}
+	return nil
+}
+
+// run drains the queue every interval and whenever a write is queued, until the context is done
+func (q *writeQueue) run(ctx context.Context) {
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+		q.drain(ctx)
+	}
+}
+
+// drain submits the queued writes in order until the queue is empty or the ledger is unreachable.
+// A write rejected by the ledger is marked failed and the next one is submitted, a write of a record
+// that is already on the ledger is dropped.
+func (q *writeQueue) drain(ctx context.Context) DrainResult {
+	q.drainMu.Lock()
+	defer q.drainMu.Unlock()
+
+	var result DrainResult
+	for {
+		write := q.head()
+		if write == nil {
+			break
+		}
+		if !GetGatewayStatus().Ready {
+			result.Stopped = "the ledger is not ready"
+			break
+		}
+
+		err := submit(WithCaller(ctx, write.Caller), write.Function, write.Args...)
+		op := queueOp{Op: queueOpDone, Seq: write.Seq}
+		switch {
+		case err == nil:
+			result.Submitted++
+		case isDuplicate(err):
+			result.Duplicates++
+		case isUnreachable(err) || ctx.Err() != nil:
+			op = queueOp{Op: queueOpAttempt, Seq: write.Seq, Error: err.Error()}
+			result.Stopped = err.Error()
+		default:
+			op = queueOp{Op: queueOpFail, Seq: write.Seq, Error: err.Error()}
+			result.Failed++
+		}
+
+		// a write deleted while it was submitted is not found anymore, which is fine
+		if err := q.update(op); err != nil && !errors.Is(err, ErrQueuedWriteNotFound) {
+			result.Stopped = err.Error()
+			break
+		}
+		if op.Op == queueOpAttempt {
+			break
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stats.Submitted += uint64(result.Submitted)
+	q.stats.Duplicates += uint64(result.Duplicates)
+	q.stats.LastDrain = time.Now().Format(time.RFC3339)
+	q.stats.LastError = result.Stopped
+	for _, write := range q.writes {
+		if !write.Failed {
+			result.Pending++
+		}
+	}
+	if len(q.writes) == 0 && q.journal != nil {
+		// nothing is left to replay, start the journal over
+		if err := q.journal.Truncate(0); err != nil {
+			slog.Warn("Failed to truncate the write queue journal", "error", err)
+		}
+	}
+	return result
+}
+
+// GetWriteQueue returns the queued writes in order and the counters of the queue
+func GetWriteQueue() ([]QueuedWrite, WriteQueueStats) {
+	if queue == nil {
+		return []QueuedWrite{}, WriteQueueStats{}
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	writes := make([]QueuedWrite, 0, len(queue.writes))
+	stats := queue.stats
+	for _, write := range queue.writes {
+		writes = append(writes, *write)
+		if write.Failed {
+			stats.Failed++
+		} else {
+			stats.Pending++
+		}
+	}
+	return writes, stats
+}
+
+// DrainWriteQueue submits the queued writes now instead of waiting for the next background drain
+func DrainWriteQueue(ctx context.Context) (DrainResult, error) {
+	if queue == nil {
+		return DrainResult{}, ErrWriteQueueDisabled
+	}
+	return queue.drain(ctx), nil
+}
+
+// RetryQueuedWrite puts a failed write back in the queue, at its original position
+func RetryQueuedWrite(seq uint64) error {
+	if queue == nil {
+		return ErrWriteQueueDisabled
+	}
+	if err := queue.update(queueOp{Op: queueOpRetry, Seq: seq}); err != nil {
+		return err
+	}
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// DeleteQueuedWrite drops a queued write, it is never submitted
+func DeleteQueuedWrite(seq uint64) error {
+	if queue == nil {
+		return ErrWriteQueueDisabled
+	}
+	return queue.update(queueOp{Op: queueOpDelete, Seq: seq})
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyLedger is a memory ledger whose peers can be taken down, and which rejects the writes of one record
+type flakyLedger struct {
+	*MemoryLedger
+	down   atomic.Bool
+	reject string
+}
+
+func (l *flakyLedger) Submit(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if l.down.Load() {
+		return nil, status.Error(codes.Unavailable, "no peer reachable")
+	}
+	if len(args) > 0 && args[0] == l.reject {
+		return nil, fmt.Errorf("chaincode response 500, the record %s is rejected", l.reject)
+	}
+	return l.MemoryLedger.Submit(ctx, name, args...)
+}
+
+func (l *flakyLedger) Status() GatewayStatus {
+	status := l.MemoryLedger.Status()
+	status.Ready = status.Ready && !l.down.Load()
+	return status
+}
+
+// useWriteQueue sends the transactions of the test to a memory ledger that is down, through a write queue
+// in the directory which is only drained by the test
+func useWriteQueue(t *testing.T, dir string) *flakyLedger {
+	t.Helper()
+	l := &flakyLedger{MemoryLedger: useMemoryLedger(t)}
+	l.down.Store(true)
+	UseLedger(DefaultGatewayConfig(), l)
+	openQueue(t, dir)
+	return l
+}
+
+// openQueue restores the write queue of the directory, without its background drain
+func openQueue(t *testing.T, dir string) {
+	t.Helper()
+	q, err := openWriteQueue(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("openWriteQueue: %v", err)
+	}
+	queue = q
+	t.Cleanup(func() {
+		CloseWriteQueue()
+		queue = nil
+	})
+}
+
+// createLog writes a log record and reports whether it was queued
+func createLog(t *testing.T, logID string, output string) bool {
+	t.Helper()
+	queued, err := CreateLogRecord(context.Background(), logID, "LLM0", "in", "reranker0", output, "user", "2025-01-01", "")
+	if err != nil {
+		t.Fatalf("CreateLogRecord(%s): %v", logID, err)
+	}
+	return queued
+}
+
+func TestWriteQueueDrainsInOrder(t *testing.T) {
+	l := useWriteQueue(t, t.TempDir())
+	ctx := context.Background()
+
+	if !createLog(t, "log1", "answer1") || !createLog(t, "log2", "answer2") {
+		t.Fatal("the writes were not queued while the ledger is down")
+	}
+	// the same write is accepted once, another content for the record is a conflict
+	if !createLog(t, "log1", "answer1") {
+		t.Error("the repeated write was not accepted")
+	}
+	if _, err := CreateLogRecord(ctx, "log1", "LLM0", "in", "reranker0", "another answer", "user", "2025-01-01", ""); !errors.Is(err, ErrQueuedWriteConflict) {
+		t.Errorf("conflicting write: %v, want ErrQueuedWriteConflict", err)
+	}
+	writes, stats := GetWriteQueue()
+	if len(writes) != 2 || writes[0].LogID != "log1" || writes[1].LogID != "log2" || stats.Pending != 2 || stats.Duplicates != 1 {
+		t.Fatalf("queue = %+v, %+v, want log1 and log2 pending and 1 duplicate", writes, stats)
+	}
+
+	result, err := DrainWriteQueue(ctx)
+	if err != nil || result.Submitted != 0 || result.Pending != 2 || result.Stopped == "" {
+		t.Errorf("drain while down = %+v, %v, want it stopped with 2 pending", result, err)
+	}
+
+	// a write queued behind others is not submitted before them, even with the ledger back
+	l.down.Store(false)
+	if !createLog(t, "log3", "answer3") {
+		t.Error("the write was submitted ahead of the queue")
+	}
+	result, err = DrainWriteQueue(ctx)
+	if err != nil || result.Submitted != 3 || result.Pending != 0 || result.Stopped != "" {
+		t.Errorf("drain = %+v, %v, want 3 submitted", result, err)
+	}
+	if blocks := l.Height(); blocks != 3 {
+		t.Errorf("%d blocks committed, want 3", blocks)
+	}
+	if _, err := GetLogRecord(ctx, "log1"); err != nil {
+		t.Errorf("GetLogRecord(log1): %v", err)
+	}
+	if info, err := os.Stat(queue.path); err != nil || info.Size() != 0 {
+		t.Errorf("the empty queue did not truncate its journal")
+	}
+
+	// with the queue empty, the writes go to the ledger again
+	if createLog(t, "log4", "answer4") {
+		t.Error("the write was queued with the ledger up")
+	}
+}
+
+func TestWriteQueueKeepsRejectedWrites(t *testing.T) {
+	l := useWriteQueue(t, t.TempDir())
+	ctx := context.Background()
+
+	createLog(t, "log1", "answer1")
+	createLog(t, "rejected", "answer")
+	createLog(t, "log2", "answer2")
+
+	l.down.Store(false)
+	l.reject = "rejected"
+	// log1 is already on the ledger, written by another server
+	if _, err := l.MemoryLedger.Submit(ctx, "CreateLogRecord", "log1", "LLM0", "in", "reranker0", "answer1", "user", "2025-01-01", ""); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	result, err := DrainWriteQueue(ctx)
+	if err != nil || result.Submitted != 1 || result.Duplicates != 1 || result.Failed != 1 || result.Pending != 0 {
+		t.Errorf("drain = %+v, %v, want 1 submitted, 1 duplicate and 1 failed", result, err)
+	}
+	writes, stats := GetWriteQueue()
+	if len(writes) != 1 || writes[0].LogID != "rejected" || !writes[0].Failed || writes[0].LastError == "" || stats.Failed != 1 {
+		t.Fatalf("queue = %+v, want the failed write of rejected", writes)
+	}
+
+	if err := RetryQueuedWrite(writes[0].Seq); err != nil {
+		t.Fatalf("RetryQueuedWrite: %v", err)
+	}
+	if _, stats := GetWriteQueue(); stats.Pending != 1 {
+		t.Errorf("%d writes pending after the retry, want 1", stats.Pending)
+	}
+	if err := DeleteQueuedWrite(writes[0].Seq); err != nil {
+		t.Fatalf("DeleteQueuedWrite: %v", err)
+	}
+	if err := DeleteQueuedWrite(writes[0].Seq); !errors.Is(err, ErrQueuedWriteNotFound) {
+		t.Errorf("second DeleteQueuedWrite: %v, want ErrQueuedWriteNotFound", err)
+	}
+}
+
+func TestWriteQueueRestoresItsJournal(t *testing.T) {
+	dir := t.TempDir()
+	l := useWriteQueue(t, dir)
+	ctx := context.Background()
+
+	createLog(t, "log1", "answer1")
+	createLog(t, "log2", "answer2")
+	createLog(t, "log3", "answer3")
+	writes, _ := GetWriteQueue()
+	if err := DeleteQueuedWrite(writes[1].Seq); err != nil {
+		t.Fatalf("DeleteQueuedWrite: %v", err)
+	}
+	CloseWriteQueue()
+
+	// a crash in the middle of a write leaves an incomplete last line, which is ignored
+	file, err := os.OpenFile(filepath.Join(dir, WriteQueueFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open the journal: %v", err)
+	}
+	file.WriteString(`{"op": "accept", "seq": 4, "wri`)
+	file.Close()
+
+	openQueue(t, dir)
+	writes, _ = GetWriteQueue()
+	if len(writes) != 2 || writes[0].LogID != "log1" || writes[1].LogID != "log3" || writes[1].Seq != 3 {
+		t.Fatalf("restored queue = %+v, want log1 and log3", writes)
+	}
+	// the restored queue keeps numbering the writes after the last one
+	createLog(t, "log4", "answer4")
+	if writes, _ = GetWriteQueue(); writes[2].Seq != 4 {
+		t.Errorf("new write numbered %d, want 4", writes[2].Seq)
+	}
+
+	l.down.Store(false)
+	if result, err := DrainWriteQueue(ctx); err != nil || result.Submitted != 3 {
+		t.Errorf("drain of the restored queue = %+v, %v, want 3 submitted", result, err)
+	}
+	if _, err := GetLogRecord(ctx, "log2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the deleted write was submitted: %v", err)
+	}
+}
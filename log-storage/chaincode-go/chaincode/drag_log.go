@@ -0,0 +1,1486 @@
+// Package chaincode is the DRagLog smart contract, storing the log, feedback and reliability records
+package chaincode
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	// Add this import statement
+	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// the index defined for the couchdb
+const index = "id~time"
+
+// the composite key object type for the reliability score deltas, keyed by data source ID and tx ID
+const scoreDeltaObjectType = "scoredelta"
+
+// the chaincode event emitted by the transactions changing reliability scores
+const scoreChangedEvent = "ReliabilityScoreChanged"
+
+// the bounds of a lineage traversal
+const (
+	maxLineageDepth   = 20
+	maxLineageRecords = 1000
+)
+
+// the prefix of every composite key in world state
+const compositeKeyNamespace = "\x00"
+
+// The maximum sizes in bytes of the transaction arguments, checked on every write so a single caller
+// cannot bloat the world state and the blocks
+const (
+	// MaxIDSize bounds the log, logger, data source and recipient IDs and the timestamps
+	MaxIDSize = 1 << 10
+	// MaxContentSize bounds the input, the output and the sources of a record, and the digest of a data source
+	MaxContentSize = 1 << 20
+	// MaxReservedSize bounds the reserved field of a record and the info of a score update
+	MaxReservedSize = 64 << 10
+	// MaxPayloadSize bounds the JSON of a batch of records or of an interaction
+	MaxPayloadSize = 4 << 20
+)
+
+// CheckSize fails if the value of the field is larger than max bytes
+func CheckSize(field string, value string, max int) error {
+	if len(value) > max {
+		return fmt.Errorf("the %s is %d bytes, larger than the maximum of %d bytes", field, len(value), max)
+	}
+	return nil
+}
+
+// CheckRecordSizes fails if a field of the record is larger than its maximum size
+func CheckRecordSizes(record *LogRecord) error {
+	for _, field := range []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"logID", record.LogID, MaxIDSize},
+		{"loggerID", record.LoggerID, MaxIDSize},
+		{"input", record.Input, MaxContentSize},
+		{"inputFrom", record.InputFrom, MaxContentSize},
+		{"output", record.Output, MaxContentSize},
+		{"outputTo", record.OutputTo, MaxIDSize},
+		{"timestamp", record.Timestamp, MaxIDSize},
+		{"reserved", record.Reserved, MaxReservedSize},
+	} {
+		if err := CheckSize(field.name+" field", field.value, field.max); err != nil {
+			return fmt.Errorf("record %.64s: %w", record.LogID, err)
+		}
+	}
+	return nil
+}
+
+// logger logs the chaincode activity, warnings only unless SetLogger replaces it, so the peer logs
+// are not flooded with record contents
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+// SetLogger replaces the logger of the chaincode
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+type SimpleChaincode struct {
+	contractapi.Contract
+}
+
+// type ReliabilityRecord struct {
+// 	DataSourceID     string  `json:"dataSourceID"`
+// 	ReliabilityScore float32 `json:"reliabilityScore"`
+// 	Type             string  `json:"type"`
+// 	Timestamp        string  `json:"timestamp"`
+// 	Reserved         string  `json:"reserved"`
+// }
+
+type LogRecord struct {
+	LogID            string  `json:"logID"`
+	LoggerID         string  `json:"loggerID"`
+	Type             string  `json:"type"`
+	Input            string  `json:"input"`
+	InputFrom        string  `json:"inputFrom"`
+	Output           string  `json:"output"`
+	OutputTo         string  `json:"outputTo"`
+	ReliabilityScore float32 `json:"reliabilityScore"`
+	Timestamp        string  `json:"timestamp"`
+	Reserved         string  `json:"reserved"`
+}
+
+// Feedback is the feedback from the source to the user
+type Feedback struct {
+	Output   string `json:"sourceID"`
+	Feedback string `json:"feedback"`
+	Reserved string `json:"reserved"`
+}
+
+// HistoryLogRecord is the history log record
+type HistoryLogRecord struct {
+	Record    *LogRecord `json:"record"`
+	Timestamp string     `json:"timestamp"`
+	TxId      string     `json:"txID"`
+	IsDelete  bool       `json:"isDelete"`
+}
+
+// ScoreDelta is a single change to the reliability score of a data source. Every delta
+// is written under its own composite key, so concurrent feedback for the same data source
+// does not conflict on the reliability record, which only holds the last checkpoint.
+type ScoreDelta struct {
+	DataSourceID string  `json:"dataSourceID"`
+	Type         string  `json:"type"`
+	Delta        float32 `json:"delta"`
+	Info         string  `json:"info"`
+	TxID         string  `json:"txID"`
+	Timestamp    string  `json:"timestamp"`
+}
+
+// ReliabilityScore is the effective score of a data source, Found is false when it has no reliability record
+type ReliabilityScore struct {
+	DataSourceID     string     `json:"dataSourceID"`
+	Found            bool       `json:"found"`
+	ReliabilityScore float32    `json:"reliabilityScore"`
+	Record           *LogRecord `json:"record,omitempty" metadata:",optional"`
+}
+
+// ScoreChange is an entry of the ReliabilityScoreChanged event. Record is the new reliability record when
+// the transaction writes it, and nil when a score delta makes the previously read record stale.
+type ScoreChange struct {
+	DataSourceID string     `json:"dataSourceID"`
+	Record       *LogRecord `json:"record,omitempty"`
+}
+
+// Interaction is a whole RAG interaction: the documents retrieved from the data sources,
+// the re-ranked content sent to the LLM, its answer and the optional feedback of the user
+type Interaction struct {
+	InteractionID  string               `json:"interactionID"`
+	RerankerID     string               `json:"rerankerID"`
+	LLMID          string               `json:"llmID"`
+	UserID         string               `json:"userID"`
+	Sources        []InteractionSource  `json:"sources"`
+	RerankerOutput string               `json:"rerankerOutput"`
+	LLMOutput      string               `json:"llmOutput"`
+	Timestamp      string               `json:"timestamp"`
+	Feedback       *InteractionFeedback `json:"feedback,omitempty"`
+}
+
+// InteractionSource is the document retrieved from a data source
+type InteractionSource struct {
+	DataSourceID string `json:"dataSourceID"`
+	Digest       string `json:"digest"`
+}
+
+// InteractionFeedback is the evaluation of the answer by the user
+type InteractionFeedback struct {
+	Evaluation string `json:"evaluation"`
+	Reserved   string `json:"reserved"`
+}
+
+// InteractionResult lists the records written for an interaction
+type InteractionResult struct {
+	InteractionID string   `json:"interactionID"`
+	LogIDs        []string `json:"logIDs"`
+}
+
+// LineageNode is a record reached by a lineage traversal, Depth is its distance to the start
+type LineageNode struct {
+	Record *LogRecord `json:"record"`
+	Depth  int        `json:"depth"`
+}
+
+// LineageEdge links the record whose output is an input of another record
+type LineageEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Lineage is the provenance graph around a record or an output digest. Sources are the data sources
+// whose documents were reached, Truncated is set when the traversal stopped at maxLineageRecords.
+type Lineage struct {
+	Start     []string           `json:"start"`
+	Nodes     []LineageNode      `json:"nodes"`
+	Edges     []LineageEdge      `json:"edges"`
+	Sources   []ReliabilityScore `json:"sources"`
+	Truncated bool               `json:"truncated"`
+}
+
+type PaginatedQueryResult struct {
+	Records             []LogRecord `json:"records"`
+	FetchedRecordsCount int32       `json:"fetchedRecordsCount"`
+	Bookmark            string      `json:"bookmark"`
+}
+
+// Hello returns a greeting message to check if the chaincode is alive
+func (s *SimpleChaincode) Hello(ctx contractapi.TransactionContextInterface) string {
+	return "Hello from fabric, the service is running!"
+}
+
+// ReadReliabilityRecord returns the reliability record for the given data source ID,
+// with the effective score aggregated from the checkpoint and all pending deltas
+func (s *SimpleChaincode) ReadReliabilityRecord(ctx contractapi.TransactionContextInterface, dataSourceID string) (*LogRecord, error) {
+	reliabilityRecord, err := s.readReliabilityCheckpoint(ctx, dataSourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas, _, err := s.getScoreDeltas(ctx, dataSourceID)
+	if err != nil {
+		return nil, err
+	}
+	applyScoreDeltas(reliabilityRecord, deltas)
+
+	return reliabilityRecord, nil
+}
+
+// ReadReliabilityRecords returns the effective scores of many data sources in one call,
+// data sources without a reliability record are returned with Found set to false
+func (s *SimpleChaincode) ReadReliabilityRecords(ctx contractapi.TransactionContextInterface, dataSourceIDs []string) ([]ReliabilityScore, error) {
+	scores := make([]ReliabilityScore, 0, len(dataSourceIDs))
+	for _, dataSourceID := range dataSourceIDs {
+		score := ReliabilityScore{DataSourceID: dataSourceID}
+
+		reliabilityRecordJSON, err := ctx.GetStub().GetState(dataSourceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the reliability record for the data source %s: %v", dataSourceID, err)
+		}
+		if reliabilityRecordJSON != nil {
+			var reliabilityRecord LogRecord
+			err = json.Unmarshal(reliabilityRecordJSON, &reliabilityRecord)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unmarshal the reliability record for the data source %s: %v", dataSourceID, err)
+			}
+
+			// other records sharing the ID are not reliability records
+			if reliabilityRecord.Type == "reliability" {
+				err = s.applyPendingScoreDeltas(ctx, &reliabilityRecord)
+				if err != nil {
+					return nil, err
+				}
+				score.Found = true
+				score.ReliabilityScore = reliabilityRecord.ReliabilityScore
+				score.Record = &reliabilityRecord
+			}
+		}
+
+		scores = append(scores, score)
+	}
+
+	return scores, nil
+}
+
+// readReliabilityCheckpoint returns the reliability record as stored in world state, without pending deltas.
+// A log or feedback record sharing the ID is not a reliability record.
+func (s *SimpleChaincode) readReliabilityCheckpoint(ctx contractapi.TransactionContextInterface, dataSourceID string) (*LogRecord, error) {
+	reliabilityRecordJSON, err := ctx.GetStub().GetState(dataSourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the reliability record for the data source %s: %v", dataSourceID, err)
+	}
+	if reliabilityRecordJSON == nil {
+		return nil, fmt.Errorf("the reliability record for the data source %s does not exist", dataSourceID)
+	}
+
+	var reliabilityRecord LogRecord
+	err = json.Unmarshal(reliabilityRecordJSON, &reliabilityRecord)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the reliability record for the data source %s: %v", dataSourceID, err)
+	}
+	if reliabilityRecord.Type != "reliability" {
+		return nil, fmt.Errorf("the reliability record for the data source %s does not exist, %s is a %s record", dataSourceID, dataSourceID, reliabilityRecord.Type)
+	}
+
+	return &reliabilityRecord, nil
+}
+
+// RecordExists returns true when record with given ID exists in world state
+func (s *SimpleChaincode) RecordExists(ctx contractapi.TransactionContextInterface, recordID string) (bool, error) {
+	recordJSON, err := ctx.GetStub().GetState(recordID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read record %s from world state: %v", recordID, err)
+	}
+
+	return recordJSON != nil, nil
+}
+
+// ReadLogRecord returns the log record for the given log ID
+func (s *SimpleChaincode) ReadLogRecord(ctx contractapi.TransactionContextInterface, logID string) (*LogRecord, error) {
+	logRecordJSON, err := ctx.GetStub().GetState(logID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the log record for the log ID %s: %v", logID, err)
+	}
+	if logRecordJSON == nil {
+		return nil, fmt.Errorf("the log record for the log ID %s does not exist", logID)
+	}
+
+	var logRecord LogRecord
+	err = json.Unmarshal(logRecordJSON, &logRecord)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the log record for the log ID %s: %v", logID, err)
+	}
+
+	return &logRecord, nil
+}
+
+func (s *SimpleChaincode) ReadFeedbackRecord(ctx contractapi.TransactionContextInterface, logID string) (*LogRecord, error) {
+	feedbackRecordJSON, err := ctx.GetStub().GetState(logID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the feedback record for the log ID %s: %v", logID, err)
+	}
+	if feedbackRecordJSON == nil {
+		return nil, fmt.Errorf("the feedback record for the log ID %s does not exist", logID)
+	}
+
+	var feedbackRecord LogRecord
+	err = json.Unmarshal(feedbackRecordJSON, &feedbackRecord)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the feedback record for the log ID %s: %v", logID, err)
+	}
+
+	return &feedbackRecord, nil
+}
+
+func MD5Hash(text string) string {
+	hash := md5.New()
+	hash.Write([]byte(text))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (s *SimpleChaincode) CreateReliabilityRecord(ctx contractapi.TransactionContextInterface, dataSourceID string, digest string, reserved string) error {
+	reliabilityRecord, err := s.createReliabilityRecord(ctx, dataSourceID, digest, reserved)
+	if err != nil || reliabilityRecord == nil {
+		return err
+	}
+
+	return emitScoreChanges(ctx, []ScoreChange{{DataSourceID: dataSourceID, Record: reliabilityRecord}})
+}
+
+// createReliabilityRecord writes a new reliability record and returns it, or nil if the record already exists
+func (s *SimpleChaincode) createReliabilityRecord(ctx contractapi.TransactionContextInterface, dataSourceID string, digest string, reserved string) (*LogRecord, error) {
+	err := CheckRecordSizes(&LogRecord{LogID: dataSourceID, LoggerID: dataSourceID, Input: digest, Reserved: reserved})
+	if err != nil {
+		return nil, err
+	}
+
+	// check if the reliability record already exists
+	exists, err := s.RecordExists(ctx, dataSourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if the reliability record for the data source %s exists: %v", dataSourceID, err)
+	}
+	if exists {
+		logger.Debug("the reliability record already exists, skipping", "dataSourceID", dataSourceID)
+		return nil, nil
+	}
+
+	reliabilityRecord := LogRecord{
+		LogID:            dataSourceID,
+		LoggerID:         dataSourceID,
+		Type:             "reliability",
+		Input:            digest,
+		InputFrom:        "",
+		Output:           "",
+		OutputTo:         "",
+		ReliabilityScore: 100,
+		Timestamp:        "0",
+		Reserved:         reserved,
+	}
+
+	reliabilityRecordJSON, err := json.Marshal(reliabilityRecord)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the reliability record for the data source %s: %v", dataSourceID, err)
+	}
+
+	err = ctx.GetStub().PutState(dataSourceID, reliabilityRecordJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put the reliability record for the data source %s: %v", dataSourceID, err)
+	}
+
+	return &reliabilityRecord, nil
+}
+
+// emitScoreChanges sets the ReliabilityScoreChanged event of the transaction. A transaction carries
+// a single event, so transactions changing several scores report them all at once.
+func emitScoreChanges(ctx contractapi.TransactionContextInterface, changes []ScoreChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the score changes: %v", err)
+	}
+
+	err = ctx.GetStub().SetEvent(scoreChangedEvent, payload)
+	if err != nil {
+		return fmt.Errorf("failed to set the score changed event: %v", err)
+	}
+
+	return nil
+}
+
+// create reliability records in batch
+func (s *SimpleChaincode) CreateReliabilityRecordsBatch(ctx contractapi.TransactionContextInterface, recordsJSON string) error {
+	err := CheckSize("batch of records", recordsJSON, MaxPayloadSize)
+	if err != nil {
+		return err
+	}
+
+	var records []LogRecord
+	err = json.Unmarshal([]byte(recordsJSON), &records)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal records: %v", err)
+	}
+
+	var changes []ScoreChange
+	for _, record := range records {
+		err = CheckRecordSizes(&record)
+		if err != nil {
+			return err
+		}
+
+		// Check if record already exists
+		exists, err := s.RecordExists(ctx, record.LogID)
+		if err != nil {
+			return fmt.Errorf("failed to check if record %s exists: %v", record.LogID, err)
+		}
+		if exists {
+			logger.Debug("the record already exists, skipping", "logID", record.LogID)
+			continue
+		}
+
+		// Marshal the record
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal record %s: %v", record.LogID, err)
+		}
+
+		// Put the record in the ledger
+		err = ctx.GetStub().PutState(record.LogID, recordJSON)
+		if err != nil {
+			return fmt.Errorf("failed to put record %s: %v", record.LogID, err)
+		}
+
+		if record.Type == "reliability" {
+			reliabilityRecord := record
+			changes = append(changes, ScoreChange{DataSourceID: record.LogID, Record: &reliabilityRecord})
+		}
+	}
+
+	return emitScoreChanges(ctx, changes)
+}
+
+func (s *SimpleChaincode) CreateLogRecord(ctx contractapi.TransactionContextInterface, logID string, loggerID string, input string, inputFrom string, output string, outputTo string, timestamp string, reserved string) error {
+	// check if the log record already exists with RecordExists
+	exists, err := s.RecordExists(ctx, logID)
+	if err != nil {
+		return fmt.Errorf("failed to check if the log record for the log ID %s exists: %v", logID, err)
+	}
+	if exists {
+		return fmt.Errorf("the log record for the log ID %s already exists", logID)
+	}
+
+	logRecord := LogRecord{
+		LogID:            logID,
+		LoggerID:         loggerID,
+		Type:             "log",
+		Input:            input,
+		InputFrom:        inputFrom,
+		Output:           output,
+		OutputTo:         outputTo,
+		Timestamp:        timestamp,
+		ReliabilityScore: -1,
+		Reserved:         reserved,
+	}
+
+	err = CheckRecordSizes(&logRecord)
+	if err != nil {
+		return err
+	}
+
+	logRecordJSON, err := json.Marshal(logRecord)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the log record for the log ID %s: %v", logID, err)
+	}
+
+	err = ctx.GetStub().PutState(logID, logRecordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put the log record for the log ID %s: %v", logID, err)
+	}
+
+	return nil
+}
+
+func (s *SimpleChaincode) CreateFeedbackRecord(ctx contractapi.TransactionContextInterface, logID string, loggerID string, input string, inputFrom string, output string, outputTo string, timestamp string, reserved string) error {
+	// check if the feedback record already exists with RecordExists
+	exists, err := s.RecordExists(ctx, logID)
+	if err != nil {
+		return fmt.Errorf("failed to check if the feedback record for the log ID %s exists: %v", logID, err)
+	}
+	if exists {
+		return fmt.Errorf("the feedback record for the log ID %s already exists", logID)
+	}
+
+	feedbackRecord := LogRecord{
+		LogID:            logID,
+		LoggerID:         loggerID,
+		Type:             "feedback",
+		Input:            input,
+		InputFrom:        inputFrom,
+		Output:           output,
+		OutputTo:         outputTo,
+		Timestamp:        timestamp,
+		ReliabilityScore: -1,
+		Reserved:         reserved,
+	}
+
+	err = CheckRecordSizes(&feedbackRecord)
+	if err != nil {
+		return err
+	}
+
+	// the record contents stay out of the peer logs
+	logger.Debug("creating the feedback record", "logID", logID, "loggerID", loggerID, "reservedSize", len(reserved))
+
+	feedbackRecordJSON, err := json.Marshal(feedbackRecord)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the feedback record for the log ID %s: %v", logID, err)
+	}
+
+	err = ctx.GetStub().PutState(logID, feedbackRecordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put the feedback record for the log ID %s: %v", logID, err)
+	}
+
+	return nil
+}
+
+// CreateInteraction writes every record of a RAG interaction in a single transaction, so an interaction
+// is either fully logged or not at all. The log IDs follow the "<from>-<to>" scheme of InitLedger,
+// prefixed by the interaction ID, which defaults to the transaction ID:
+//
+//	<interactionID>:<dataSourceID>-<rerankerID>  for each data source
+//	<interactionID>:<rerankerID>-<llmID>
+//	<interactionID>:<llmID>-<userID>
+//	<interactionID>:<userID>-feedback            when there is feedback
+func (s *SimpleChaincode) CreateInteraction(ctx contractapi.TransactionContextInterface, interactionJSON string) (*InteractionResult, error) {
+	err := CheckSize("interaction", interactionJSON, MaxPayloadSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var interaction Interaction
+	err = json.Unmarshal([]byte(interactionJSON), &interaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the interaction: %v", err)
+	}
+
+	if interaction.InteractionID == "" {
+		interaction.InteractionID = ctx.GetStub().GetTxID()
+	}
+	if interaction.UserID == "" {
+		interaction.UserID = "user"
+	}
+	if interaction.RerankerID == "" || interaction.LLMID == "" {
+		return nil, fmt.Errorf("the interaction %s must name the re-ranker and the LLM", interaction.InteractionID)
+	}
+	if len(interaction.Sources) == 0 {
+		return nil, fmt.Errorf("the interaction %s has no data source", interaction.InteractionID)
+	}
+
+	prefix := interaction.InteractionID + ":"
+	var records []LogRecord
+	var digests []string
+	var sourceIDs []string
+	for _, source := range interaction.Sources {
+		if source.DataSourceID == "" {
+			return nil, fmt.Errorf("the interaction %s has a data source without ID", interaction.InteractionID)
+		}
+		records = append(records, LogRecord{
+			LogID:            prefix + source.DataSourceID + "-" + interaction.RerankerID,
+			LoggerID:         source.DataSourceID,
+			Type:             "log",
+			Output:           source.Digest,
+			OutputTo:         interaction.RerankerID,
+			Timestamp:        interaction.Timestamp,
+			ReliabilityScore: -1,
+		})
+		digests = append(digests, source.Digest)
+		sourceIDs = append(sourceIDs, source.DataSourceID)
+	}
+
+	// the re-ranker takes the list of digests from the list of data sources
+	digestsJSON, err := json.Marshal(digests)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the digests of the interaction %s: %v", interaction.InteractionID, err)
+	}
+	sourceIDsJSON, err := json.Marshal(sourceIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the data sources of the interaction %s: %v", interaction.InteractionID, err)
+	}
+	records = append(records,
+		LogRecord{
+			LogID:            prefix + interaction.RerankerID + "-" + interaction.LLMID,
+			LoggerID:         interaction.RerankerID,
+			Type:             "log",
+			Input:            string(digestsJSON),
+			InputFrom:        string(sourceIDsJSON),
+			Output:           interaction.RerankerOutput,
+			OutputTo:         interaction.LLMID,
+			Timestamp:        interaction.Timestamp,
+			ReliabilityScore: -1,
+		},
+		LogRecord{
+			LogID:            prefix + interaction.LLMID + "-" + interaction.UserID,
+			LoggerID:         interaction.LLMID,
+			Type:             "log",
+			Input:            interaction.RerankerOutput,
+			InputFrom:        interaction.RerankerID,
+			Output:           interaction.LLMOutput,
+			OutputTo:         interaction.UserID,
+			Timestamp:        interaction.Timestamp,
+			ReliabilityScore: -1,
+		},
+	)
+	if interaction.Feedback != nil {
+		records = append(records, LogRecord{
+			LogID:            prefix + interaction.UserID + "-feedback",
+			LoggerID:         interaction.UserID,
+			Type:             "feedback",
+			Input:            interaction.LLMOutput,
+			InputFrom:        interaction.LLMID,
+			Output:           interaction.Feedback.Evaluation,
+			Timestamp:        interaction.Timestamp,
+			ReliabilityScore: -1,
+			Reserved:         interaction.Feedback.Reserved,
+		})
+	}
+
+	// a transaction does not read its own writes, so the IDs must also be unique within the interaction
+	result := &InteractionResult{InteractionID: interaction.InteractionID}
+	seen := make(map[string]bool, len(records))
+	for i := range records {
+		if seen[records[i].LogID] {
+			return nil, fmt.Errorf("the interaction %s writes the record %s twice", interaction.InteractionID, records[i].LogID)
+		}
+		seen[records[i].LogID] = true
+
+		err = s.putNewRecord(ctx, &records[i])
+		if err != nil {
+			return nil, err
+		}
+		result.LogIDs = append(result.LogIDs, records[i].LogID)
+	}
+
+	return result, nil
+}
+
+// putNewRecord writes a record, failing if a record with the same ID already exists
+func (s *SimpleChaincode) putNewRecord(ctx contractapi.TransactionContextInterface, record *LogRecord) error {
+	err := CheckRecordSizes(record)
+	if err != nil {
+		return err
+	}
+
+	exists, err := s.RecordExists(ctx, record.LogID)
+	if err != nil {
+		return fmt.Errorf("failed to check if the record %s exists: %v", record.LogID, err)
+	}
+	if exists {
+		return fmt.Errorf("the record %s already exists", record.LogID)
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the record %s: %v", record.LogID, err)
+	}
+
+	err = ctx.GetStub().PutState(record.LogID, recordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put the record %s: %v", record.LogID, err)
+	}
+
+	return nil
+}
+
+// update the reliability score of the data source
+// A delta is written under its own composite key instead of rewriting the reliability record,
+// so many feedback transactions for the same data source can commit in one block.
+// An absolute score replaces the checkpoint and discards the pending deltas.
+func (s *SimpleChaincode) UpdateReliabilityScore(ctx contractapi.TransactionContextInterface, dataSourceID string, score float32, isDelta bool, info string) error {
+	err := CheckSize("info field", info, MaxReservedSize)
+	if err != nil {
+		return err
+	}
+
+	if !isDelta {
+		return s.setReliabilityScore(ctx, dataSourceID, score, info)
+	}
+
+	_, err = s.readReliabilityCheckpoint(ctx, dataSourceID)
+	if err != nil {
+		return err
+	}
+
+	err = s.putScoreDelta(ctx, dataSourceID, score, info)
+	if err != nil {
+		return err
+	}
+
+	return emitScoreChanges(ctx, []ScoreChange{{DataSourceID: dataSourceID}})
+}
+
+// putScoreDelta writes a score delta for the data source under a key unique to the transaction
+func (s *SimpleChaincode) putScoreDelta(ctx contractapi.TransactionContextInterface, dataSourceID string, score float32, info string) error {
+	txID := ctx.GetStub().GetTxID()
+	deltaKey, err := ctx.GetStub().CreateCompositeKey(scoreDeltaObjectType, []string{dataSourceID, txID})
+	if err != nil {
+		return fmt.Errorf("failed to create the delta key for the data source %s: %v", dataSourceID, err)
+	}
+
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("failed to get the transaction timestamp: %v", err)
+	}
+
+	delta := ScoreDelta{
+		DataSourceID: dataSourceID,
+		Type:         "scoreDelta",
+		Delta:        score,
+		Info:         info,
+		TxID:         txID,
+		Timestamp:    fmt.Sprintf("%d.%09d", timestamp.Seconds, timestamp.Nanos),
+	}
+
+	deltaJSON, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the score delta for the data source %s: %v", dataSourceID, err)
+	}
+
+	err = ctx.GetStub().PutState(deltaKey, deltaJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put the score delta for the data source %s: %v", dataSourceID, err)
+	}
+
+	return nil
+}
+
+// setReliabilityScore writes an absolute score as the new checkpoint and deletes the pending deltas
+func (s *SimpleChaincode) setReliabilityScore(ctx contractapi.TransactionContextInterface, dataSourceID string, score float32, info string) error {
+	reliabilityRecord, err := s.readReliabilityCheckpoint(ctx, dataSourceID)
+	if err != nil {
+		return fmt.Errorf("failed to read the reliability record for the data source %s: %v", dataSourceID, err)
+	}
+
+	deltas, deltaKeys, err := s.getScoreDeltas(ctx, dataSourceID)
+	if err != nil {
+		return err
+	}
+	applyScoreDeltas(reliabilityRecord, deltas)
+
+	reliabilityRecord.ReliabilityScore = score
+	if info != "" {
+		reliabilityRecord.Reserved += "," + info
+	}
+
+	err = s.putReliabilityCheckpoint(ctx, reliabilityRecord, deltaKeys)
+	if err != nil {
+		return err
+	}
+
+	return emitScoreChanges(ctx, []ScoreChange{{DataSourceID: dataSourceID, Record: reliabilityRecord}})
+}
+
+// CompactReliabilityScore folds the pending deltas of a data source into its reliability record checkpoint
+func (s *SimpleChaincode) CompactReliabilityScore(ctx contractapi.TransactionContextInterface, dataSourceID string) error {
+	reliabilityRecord, err := s.readReliabilityCheckpoint(ctx, dataSourceID)
+	if err != nil {
+		return fmt.Errorf("failed to read the reliability record for the data source %s: %v", dataSourceID, err)
+	}
+
+	deltas, deltaKeys, err := s.getScoreDeltas(ctx, dataSourceID)
+	if err != nil {
+		return err
+	}
+	if len(deltas) == 0 {
+		return nil
+	}
+	applyScoreDeltas(reliabilityRecord, deltas)
+
+	return s.putReliabilityCheckpoint(ctx, reliabilityRecord, deltaKeys)
+}
+
+// CompactReliabilityScores folds the pending deltas of every data source into the reliability record checkpoints
+func (s *SimpleChaincode) CompactReliabilityScores(ctx contractapi.TransactionContextInterface) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(scoreDeltaObjectType, []string{})
+	if err != nil {
+		return fmt.Errorf("failed to get the score deltas: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var dataSourceIDs []string
+	seen := make(map[string]bool)
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(response.Key)
+		if err != nil {
+			return fmt.Errorf("failed to split the delta key %s: %v", response.Key, err)
+		}
+		if len(attributes) == 0 || seen[attributes[0]] {
+			continue
+		}
+		seen[attributes[0]] = true
+		dataSourceIDs = append(dataSourceIDs, attributes[0])
+	}
+
+	for _, dataSourceID := range dataSourceIDs {
+		err = s.CompactReliabilityScore(ctx, dataSourceID)
+		if err != nil {
+			return fmt.Errorf("failed to compact the reliability score for the data source %s: %v", dataSourceID, err)
+		}
+	}
+
+	return nil
+}
+
+// getScoreDeltas returns the pending score deltas of a data source together with their keys
+func (s *SimpleChaincode) getScoreDeltas(ctx contractapi.TransactionContextInterface, dataSourceID string) ([]ScoreDelta, []string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(scoreDeltaObjectType, []string{dataSourceID})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the score deltas for the data source %s: %v", dataSourceID, err)
+	}
+	defer resultsIterator.Close()
+
+	var deltas []ScoreDelta
+	var deltaKeys []string
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var delta ScoreDelta
+		err = json.Unmarshal(response.Value, &delta)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal the score delta %s: %v", response.Key, err)
+		}
+		deltas = append(deltas, delta)
+		deltaKeys = append(deltaKeys, response.Key)
+	}
+
+	return deltas, deltaKeys, nil
+}
+
+// putReliabilityCheckpoint writes the reliability record and deletes the deltas already folded into it
+func (s *SimpleChaincode) putReliabilityCheckpoint(ctx contractapi.TransactionContextInterface, reliabilityRecord *LogRecord, deltaKeys []string) error {
+	dataSourceID := reliabilityRecord.LogID
+	reliabilityRecordJSON, err := json.Marshal(reliabilityRecord)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the reliability record for the data source %s: %v", dataSourceID, err)
+	}
+
+	err = ctx.GetStub().PutState(dataSourceID, reliabilityRecordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put the reliability record for the data source %s: %v", dataSourceID, err)
+	}
+
+	for _, deltaKey := range deltaKeys {
+		err = ctx.GetStub().DelState(deltaKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete the score delta %s: %v", deltaKey, err)
+		}
+	}
+
+	return nil
+}
+
+// applyScoreDeltas adds the deltas to the score of the reliability record, in the order they were read
+func applyScoreDeltas(reliabilityRecord *LogRecord, deltas []ScoreDelta) {
+	for _, delta := range deltas {
+		reliabilityRecord.ReliabilityScore += delta.Delta
+		if delta.Info != "" {
+			reliabilityRecord.Reserved += "," + delta.Info
+		}
+	}
+}
+
+// update the log record
+func (s *SimpleChaincode) UpdateLogRecord(ctx contractapi.TransactionContextInterface, logID string, loggerID string, input string, inputFrom string, output string, outputTo string, timestamp string, reserved string) error {
+	logRecord, err := s.ReadLogRecord(ctx, logID)
+	if err != nil {
+		return fmt.Errorf("failed to read the log record for the log ID %s: %v", logID, err)
+	}
+
+	logRecord.LoggerID = loggerID
+	logRecord.Input = input
+	logRecord.InputFrom = inputFrom
+	logRecord.Output = output
+	logRecord.OutputTo = outputTo
+	logRecord.Timestamp = timestamp
+	logRecord.Reserved = reserved
+
+	err = CheckRecordSizes(logRecord)
+	if err != nil {
+		return err
+	}
+
+	logRecordJSON, err := json.Marshal(logRecord)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the log record for the log ID %s: %v", logID, err)
+	}
+
+	err = ctx.GetStub().PutState(logID, logRecordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put the log record for the log ID %s: %v", logID, err)
+	}
+
+	return nil
+}
+
+// InitLedger adds the initial reliability record for the data source "default"
+func (s *SimpleChaincode) InitLedger(ctx contractapi.TransactionContextInterface) error {
+	// err := s.CreateReliabilityRecord(ctx, "default", "default")
+	// if err != nil {
+	// 	return fmt.Errorf("failed to create the initial reliability record for the data source %s: %v", "default", err)
+	// }
+	//
+
+	// create 10 reliability records with datasource id like "default0", "default1" ...
+	var changes []ScoreChange
+	for i := 0; i < 10; i++ {
+		reliabilityRecord, err := s.createReliabilityRecord(ctx, fmt.Sprintf("default%d", i), "default", "")
+		if err != nil {
+			return fmt.Errorf("failed to create the initial reliability record for the data source %s: %v", fmt.Sprintf("default%d", i), err)
+		}
+		if reliabilityRecord != nil {
+			changes = append(changes, ScoreChange{DataSourceID: reliabilityRecord.LogID, Record: reliabilityRecord})
+		}
+	}
+
+	// create 10 log records with log id like "default0-reranker0", "default1-reranker0" ...
+	for i := 0; i < 10; i++ {
+		err := s.CreateLogRecord(ctx, fmt.Sprintf("default%d-reranker0", i), fmt.Sprintf("default%d", i), "", "", "default_output_from_datasource_default"+strconv.Itoa(i), "reranker0", "2025-01-01 00:00:00", "")
+		if err != nil {
+			return fmt.Errorf("failed to create the initial log record for the log ID %s: %v", fmt.Sprintf("default%d-reranker0", i), err)
+		}
+	}
+
+	// create 1 log records with log id like reranker0-LLM0
+	err := s.CreateLogRecord(ctx, "reranker0-LLM0", "reranker0", "", "", "reranker0_output_from_reranker0", "LLM0", "2025-01-02 00:00:00", "")
+	if err != nil {
+		return fmt.Errorf("failed to create the initial log record for the log ID %s: %v", "reranker0-LLM0", err)
+	}
+
+	return emitScoreChanges(ctx, changes)
+}
+
+// // GetLogRecord returns the log record for the given log ID
+// func (s *SimpleChaincode) GetLogRecord(ctx contractapi.TransactionContextInterface, logID string) (*LogRecord, error) {
+// 	logRecord, err := s.ReadLogRecord(ctx, logID)
+// 	if err != nil {
+// 		return nil, fmt.Errorf("failed to read the log record for the log ID %s: %v", logID, err)
+// 	}
+// 	return logRecord, nil
+// }
+
+// GetAllReliabilityRecords returns all reliability records found in world state
+func (s *SimpleChaincode) GetAllRecords(ctx contractapi.TransactionContextInterface) ([]LogRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var records []LogRecord
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record LogRecord
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		err = s.applyPendingScoreDeltas(ctx, &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// applyPendingScoreDeltas aggregates the pending deltas into the score when the record is a reliability record
+func (s *SimpleChaincode) applyPendingScoreDeltas(ctx contractapi.TransactionContextInterface, record *LogRecord) error {
+	if record.Type != "reliability" {
+		return nil
+	}
+
+	deltas, _, err := s.getScoreDeltas(ctx, record.LogID)
+	if err != nil {
+		return err
+	}
+	applyScoreDeltas(record, deltas)
+
+	return nil
+}
+
+// constructQueryResponseFromIterator constructs a slices of Records from QueryResultsIterator
+func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) ([]*LogRecord, error) {
+	var records []*LogRecord
+	for resultsIterator.HasNext() {
+		recordResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		// skip the score deltas, which are stored under composite keys
+		if strings.HasPrefix(recordResponse.Key, compositeKeyNamespace) {
+			continue
+		}
+		var record LogRecord
+		err = json.Unmarshal(recordResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
+
+// widenScoreQuery widens a query whose selector constrains the reliability score to every reliability record.
+// CouchDB only sees the score of the checkpoints, so the selector is matched again once the pending deltas are
+// applied, with the returned selector. The query is returned unchanged with a nil selector otherwise.
+// Sorting by the score still orders the records by their checkpoint score.
+func widenScoreQuery(queryString string) (string, map[string]any, error) {
+	var query map[string]any
+	err := json.Unmarshal([]byte(queryString), &query)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse the query: %v", err)
+	}
+	selector, ok := query["selector"].(map[string]any)
+	if !ok || !selectsField(selector, "reliabilityScore") {
+		return queryString, nil, nil
+	}
+
+	widened := map[string]any{"$or": []any{selector, map[string]any{"type": "reliability"}}}
+	// CouchDB only sorts with an index on the field, which is only used when the selector constrains the field
+	sorts, _ := query["sort"].([]any)
+	for _, sort := range sorts {
+		switch sort := sort.(type) {
+		case string:
+			widened[sort] = map[string]any{"$gt": nil}
+		case map[string]any:
+			for field := range sort {
+				widened[field] = map[string]any{"$gt": nil}
+			}
+		}
+	}
+	query["selector"] = widened
+
+	widenedJSON, err := json.Marshal(query)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal the query: %v", err)
+	}
+	return string(widenedJSON), selector, nil
+}
+
+// selectsField reports whether a selector has a condition on the field, at any depth
+func selectsField(selector map[string]any, field string) bool {
+	for key, condition := range selector {
+		if key == field {
+			return true
+		}
+		switch condition := condition.(type) {
+		case map[string]any:
+			if selectsField(condition, field) {
+				return true
+			}
+		case []any:
+			for _, item := range condition {
+				if sub, ok := item.(map[string]any); ok && selectsField(sub, field) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// effectiveRecords applies the pending deltas to the scores of the records and, when the selector is not nil,
+// keeps the records matching it with their effective score
+func (s *SimpleChaincode) effectiveRecords(ctx contractapi.TransactionContextInterface, records []*LogRecord, selector map[string]any) ([]*LogRecord, error) {
+	var matching []*LogRecord
+	for _, record := range records {
+		err := s.applyPendingScoreDeltas(ctx, record)
+		if err != nil {
+			return nil, err
+		}
+		if selector != nil {
+			ok, err := matchRecord(record, selector)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		matching = append(matching, record)
+	}
+	return matching, nil
+}
+
+// matchRecord reports whether a record matches a CouchDB selector over its JSON fields
+func matchRecord(record *LogRecord, selector map[string]any) (bool, error) {
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal the record %s: %v", record.LogID, err)
+	}
+	var doc map[string]any
+	err = json.Unmarshal(recordJSON, &doc)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal the record %s: %v", record.LogID, err)
+	}
+	return MatchSelector(record.LogID, doc, selector)
+}
+
+// getQueryResultForQueryString queries for records based on a passed in query string.
+// This is only supported for couchdb
+func (s *SimpleChaincode) getQueryResultForQueryString(ctx contractapi.TransactionContextInterface, queryString string) ([]*LogRecord, error) {
+	queryString, scoreSelector, err := widenScoreQuery(queryString)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.effectiveRecords(ctx, records, scoreSelector)
+}
+
+// QueryRecords uses a query string to perform a query for records.
+func (s *SimpleChaincode) QueryRecords(ctx contractapi.TransactionContextInterface, queryString string) ([]*LogRecord, error) {
+	return s.getQueryResultForQueryString(ctx, queryString)
+}
+
+// QueryRecordsWithPagination uses a query string to perform a query for records and returns
+// one page of at most pageSize records, with the bookmark of the next page. A page filtered on the reliability
+// score may hold fewer records than it fetched, FetchedRecordsCount tells whether more pages follow.
+// This is only supported for couchdb
+func (s *SimpleChaincode) QueryRecordsWithPagination(ctx contractapi.TransactionContextInterface, queryString string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	queryString, scoreSelector, err := widenScoreQuery(queryString)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+	records, err = s.effectiveRecords(ctx, records, scoreSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PaginatedQueryResult{
+		Records:             []LogRecord{},
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}
+	for _, record := range records {
+		result.Records = append(result.Records, *record)
+	}
+
+	return result, nil
+}
+
+// QueryReliabilityRecords uses a query string to perform a query for reliability records.
+func (s *SimpleChaincode) QueryReliabilityRecords(ctx contractapi.TransactionContextInterface, dataSourceID string) ([]*LogRecord, error) {
+	queryString := fmt.Sprintf(`{"selector":{"LogID":"%s", "Type":"reliability"}}`, dataSourceID)
+	return s.getQueryResultForQueryString(ctx, queryString)
+}
+
+// QueryLogRecords uses a query string to perform a query for log records.
+func (s *SimpleChaincode) QueryLogRecords(ctx contractapi.TransactionContextInterface, logID string) ([]*LogRecord, error) {
+	queryString := fmt.Sprintf(`{"selector":{"LogID":"%s", "Type":"log"}}`, logID)
+	return s.getQueryResultForQueryString(ctx, queryString)
+}
+
+// QueryFeedbackRecords uses a query string to perform a query for feedback records.
+func (s *SimpleChaincode) QueryFeedbackRecords(ctx contractapi.TransactionContextInterface, logID string) ([]*LogRecord, error) {
+	queryString := fmt.Sprintf(`{"selector":{"LogID":"%s", "Type":"feedback"}}`, logID)
+	return s.getQueryResultForQueryString(ctx, queryString)
+}
+
+// GetHistoryForRecord returns the history of a record for a given record ID.
+func (s *SimpleChaincode) GetHistoryForRecord(ctx contractapi.TransactionContextInterface, recordID string) ([]HistoryLogRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var historyLogs []HistoryLogRecord
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var logRecord LogRecord
+		if len(response.Value) > 0 {
+			err = json.Unmarshal(response.Value, &logRecord)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			logRecord = LogRecord{
+				LogID: recordID,
+			}
+		}
+
+		historyLog := HistoryLogRecord{
+			Record:    &logRecord,
+			Timestamp: fmt.Sprintf("%d.%09d", response.Timestamp.Seconds, response.Timestamp.Nanos),
+			TxId:      response.TxId,
+			IsDelete:  response.IsDelete,
+		}
+
+		historyLogs = append(historyLogs, historyLog)
+	}
+
+	return historyLogs, nil
+}
+
+// GetLineage walks the provenance graph from a record, or from the records with the given output digest,
+// following the InputFrom/OutputTo edges upstream to the data sources, downstream to the answers and
+// feedback, or both ways, at most maxDepth edges away. Every record is visited once, so cycles terminate.
+func (s *SimpleChaincode) GetLineage(ctx contractapi.TransactionContextInterface, recordIDOrDigest string, direction string, maxDepth int) (*Lineage, error) {
+	if direction != "upstream" && direction != "downstream" && direction != "both" {
+		return nil, fmt.Errorf("the direction %s must be upstream, downstream or both", direction)
+	}
+	if maxDepth < 0 {
+		return nil, fmt.Errorf("the depth %d must not be negative", maxDepth)
+	}
+	maxDepth = min(maxDepth, maxLineageDepth)
+
+	starts, err := s.lineageStart(ctx, recordIDOrDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	lineage := &Lineage{Nodes: []LineageNode{}, Edges: []LineageEdge{}, Sources: []ReliabilityScore{}}
+	nodes := make(map[string]bool)
+	for _, record := range starts {
+		lineage.Start = append(lineage.Start, record.LogID)
+		lineage.Nodes = append(lineage.Nodes, LineageNode{Record: record, Depth: 0})
+		nodes[record.LogID] = true
+	}
+
+	if direction != "downstream" {
+		err = s.walkLineage(ctx, lineage, nodes, starts, true, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if direction != "upstream" {
+		err = s.walkLineage(ctx, lineage, nodes, starts, false, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the records without input are the documents of the data sources
+	var dataSourceIDs []string
+	for _, node := range lineage.Nodes {
+		if node.Record.InputFrom == "" && !slices.Contains(dataSourceIDs, node.Record.LoggerID) {
+			dataSourceIDs = append(dataSourceIDs, node.Record.LoggerID)
+		}
+	}
+	lineage.Sources, err = s.ReadReliabilityRecords(ctx, dataSourceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return lineage, nil
+}
+
+// lineageStart returns the record with the given ID, or else the records with the given output digest
+func (s *SimpleChaincode) lineageStart(ctx contractapi.TransactionContextInterface, recordIDOrDigest string) ([]*LogRecord, error) {
+	recordJSON, err := ctx.GetStub().GetState(recordIDOrDigest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the record %s: %v", recordIDOrDigest, err)
+	}
+	if recordJSON != nil {
+		var record LogRecord
+		err = json.Unmarshal(recordJSON, &record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the record %s: %v", recordIDOrDigest, err)
+		}
+		if record.Type != "reliability" {
+			return []*LogRecord{&record}, nil
+		}
+	}
+
+	records, err := s.queryLineageRecords(ctx, "output", recordIDOrDigest)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the record or output digest %s does not exist", recordIDOrDigest)
+	}
+	return records, nil
+}
+
+// walkLineage adds the records reached from the starts in one direction, breadth first
+func (s *SimpleChaincode) walkLineage(ctx contractapi.TransactionContextInterface, lineage *Lineage, nodes map[string]bool, starts []*LogRecord, upstream bool, maxDepth int) error {
+	visited := make(map[string]bool)
+	frontier := starts
+	for _, record := range starts {
+		visited[record.LogID] = true
+	}
+
+	for depth := 1; depth <= maxDepth && len(frontier) > 0; depth++ {
+		var next []*LogRecord
+		for _, record := range frontier {
+			linked, err := s.linkedRecords(ctx, record, upstream)
+			if err != nil {
+				return err
+			}
+
+			for _, other := range linked {
+				if !nodes[other.LogID] {
+					if len(lineage.Nodes) >= maxLineageRecords {
+						lineage.Truncated = true
+						return nil
+					}
+					lineage.Nodes = append(lineage.Nodes, LineageNode{Record: other, Depth: depth})
+					nodes[other.LogID] = true
+				}
+
+				edge := LineageEdge{From: other.LogID, To: record.LogID}
+				if !upstream {
+					edge = LineageEdge{From: record.LogID, To: other.LogID}
+				}
+				if !slices.Contains(lineage.Edges, edge) {
+					lineage.Edges = append(lineage.Edges, edge)
+				}
+
+				if !visited[other.LogID] {
+					visited[other.LogID] = true
+					next = append(next, other)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	return nil
+}
+
+// linkedRecords returns the records whose output is an input of the record, or the records taking its output
+func (s *SimpleChaincode) linkedRecords(ctx contractapi.TransactionContextInterface, record *LogRecord, upstream bool) ([]*LogRecord, error) {
+	var candidates []*LogRecord
+	var err error
+	if upstream {
+		if record.InputFrom == "" {
+			return nil, nil
+		}
+		candidates, err = s.queryLineageRecords(ctx, "outputTo", record.LoggerID)
+	} else {
+		if record.OutputTo == "" {
+			return nil, nil
+		}
+		candidates, err = s.queryLineageRecords(ctx, "loggerID", record.OutputTo)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var linked []*LogRecord
+	for _, candidate := range candidates {
+		if upstream && feeds(candidate, record) || !upstream && feeds(record, candidate) {
+			linked = append(linked, candidate)
+		}
+	}
+	return linked, nil
+}
+
+// queryLineageRecords returns the log and feedback records with the given field value
+func (s *SimpleChaincode) queryLineageRecords(ctx contractapi.TransactionContextInterface, field string, value string) ([]*LogRecord, error) {
+	query, err := json.Marshal(map[string]any{
+		"selector": map[string]any{
+			field:  value,
+			"type": map[string]any{"$in": []string{"log", "feedback"}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the lineage query: %v", err)
+	}
+
+	records, err := s.getQueryResultForQueryString(ctx, string(query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the records with the %s %s: %v", field, value, err)
+	}
+	return records, nil
+}
+
+// feeds reports whether the output of the upstream record is an input of the downstream record.
+// The components must match, and the digests too when both records carry one. Records without a digest
+// to compare are only linked within the same interaction.
+func feeds(upstream *LogRecord, downstream *LogRecord) bool {
+	if upstream.LogID == downstream.LogID || upstream.OutputTo != downstream.LoggerID {
+		return false
+	}
+	if downstream.InputFrom != upstream.LoggerID && !slices.Contains(linkedValues(downstream.InputFrom), upstream.LoggerID) {
+		return false
+	}
+	if upstream.Output != "" && downstream.Input != "" {
+		return downstream.Input == upstream.Output || slices.Contains(linkedValues(downstream.Input), upstream.Output)
+	}
+	upstreamInteraction, ok := interactionOf(upstream.LogID)
+	downstreamInteraction, downstreamOK := interactionOf(downstream.LogID)
+	return ok && downstreamOK && upstreamInteraction == downstreamInteraction
+}
+
+// interactionOf returns the interaction ID of a record written by CreateInteraction, named
+// <interactionID>:<from>-<to>
+func interactionOf(logID string) (string, bool) {
+	i := strings.LastIndex(logID, ":")
+	if i <= 0 || !strings.Contains(logID[i+1:], "-") {
+		return "", false
+	}
+	return logID[:i], true
+}
+
+// linkedValues parses a field holding a JSON list of IDs or digests, as written by CreateInteraction
+func linkedValues(field string) []string {
+	var values []string
+	if !strings.HasPrefix(field, "[") || json.Unmarshal([]byte(field), &values) != nil {
+		return nil
+	}
+	return values
+}
@@ -0,0 +1,236 @@
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// LookupField returns the value of a field of a document, nested fields are separated by dots
+func LookupField(key string, doc map[string]any, field string) (any, bool) {
+	if field == "_id" {
+		return key, true
+	}
+	var value any = doc
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// MatchSelector reports whether a document matches every condition of a CouchDB selector, the _id field
+// being the key of the document. The selector operators of CouchDB are supported except the array ones.
+func MatchSelector(key string, doc map[string]any, selector map[string]any) (bool, error) {
+	for field, condition := range selector {
+		var ok bool
+		var err error
+		switch field {
+		case "$and", "$or", "$nor":
+			ok, err = matchCombination(key, doc, field, condition)
+		case "$not":
+			sub, isSelector := condition.(map[string]any)
+			if !isSelector {
+				return false, fmt.Errorf("$not expects a selector object")
+			}
+			ok, err = MatchSelector(key, doc, sub)
+			ok = !ok
+		default:
+			value, exists := LookupField(key, doc, field)
+			ok, err = matchCondition(value, exists, condition)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchCombination matches a document with the selectors of $and, $or or $nor
+func matchCombination(key string, doc map[string]any, operator string, condition any) (bool, error) {
+	selectors, ok := condition.([]any)
+	if !ok {
+		return false, fmt.Errorf("%s expects an array of selectors", operator)
+	}
+
+	matched := 0
+	for _, item := range selectors {
+		sub, ok := item.(map[string]any)
+		if !ok {
+			return false, fmt.Errorf("%s expects an array of selectors", operator)
+		}
+		ok, err := MatchSelector(key, doc, sub)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+
+	switch operator {
+	case "$and":
+		return matched == len(selectors), nil
+	case "$or":
+		return matched > 0, nil
+	default:
+		return matched == 0, nil
+	}
+}
+
+// matchCondition matches the value of a field with a value or an object of operators.
+// A missing field only matches $exists false and the negations of the other operators.
+func matchCondition(value any, exists bool, condition any) (bool, error) {
+	operators, ok := condition.(map[string]any)
+	if !ok || !isOperatorObject(operators) {
+		return exists && Collate(value, condition) == 0, nil
+	}
+
+	for operator, operand := range operators {
+		var ok bool
+		switch operator {
+		case "$eq":
+			ok = exists && Collate(value, operand) == 0
+		case "$ne":
+			ok = exists && Collate(value, operand) != 0
+		case "$gt":
+			ok = exists && Collate(value, operand) > 0
+		case "$gte":
+			ok = exists && Collate(value, operand) >= 0
+		case "$lt":
+			ok = exists && Collate(value, operand) < 0
+		case "$lte":
+			ok = exists && Collate(value, operand) <= 0
+		case "$in", "$nin":
+			values, isArray := operand.([]any)
+			if !isArray {
+				return false, fmt.Errorf("%s expects an array", operator)
+			}
+			found := slices.ContainsFunc(values, func(candidate any) bool { return Collate(value, candidate) == 0 })
+			ok = exists && found == (operator == "$in")
+		case "$exists":
+			want, isBool := operand.(bool)
+			if !isBool {
+				return false, fmt.Errorf("$exists expects a boolean")
+			}
+			ok = exists == want
+		case "$regex":
+			pattern, isString := operand.(string)
+			if !isString {
+				return false, fmt.Errorf("$regex expects a string pattern")
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return false, fmt.Errorf("invalid $regex %s: %w", pattern, err)
+			}
+			text, isText := value.(string)
+			ok = exists && isText && re.MatchString(text)
+		case "$type":
+			ok = exists && collationType(value) == operand
+		case "$not":
+			matched, err := matchCondition(value, exists, operand)
+			if err != nil {
+				return false, err
+			}
+			ok = !matched
+		default:
+			return false, fmt.Errorf("unsupported operator %s", operator)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isOperatorObject reports whether an object holds operators rather than being a value to compare with
+func isOperatorObject(object map[string]any) bool {
+	for key := range object {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// collationType returns the CouchDB type name of a JSON value
+func collationType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// collationRank orders the JSON types as CouchDB does: null, false, true, numbers, strings, arrays, objects
+func collationRank(value any) int {
+	switch value := value.(type) {
+	case nil:
+		return 0
+	case bool:
+		if value {
+			return 2
+		}
+		return 1
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []any:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// Collate compares two JSON values in the CouchDB collation order, strings by code point
+func Collate(a any, b any) int {
+	if rankA, rankB := collationRank(a), collationRank(b); rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case []any:
+		b := b.([]any)
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := Collate(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(b)
+	case map[string]any:
+		// objects are compared by their JSON encoding, which sorts the keys
+		aJSON, _ := json.Marshal(a)
+		bJSON, _ := json.Marshal(b)
+		return bytes.Compare(aJSON, bJSON)
+	}
+	return 0
+}
@@ -6,6 +6,7 @@ from ctypes import c_float as float32
 import json
 import os
 import hashlib
+from urllib.parse import quote
 
 
 @dataclass
@@ -42,15 +43,22 @@ class LogRecordHistory:
     isDelete: bool
 
 class DragLogClient:
-    def __init__(self, base_url: str = "http://localhost:8080", local: bool = False, log_file: str = "logs/draglog.jsonl", reliability_history_path: str = "logs/reliability_history.jsonl"):
+    def __init__(self, base_url: str = "http://localhost:8080", local: bool = False, log_file: str = "logs/draglog.jsonl", reliability_history_path: str = "logs/reliability_history.jsonl", api_key: Optional[str] = None, token: Optional[str] = None):
         """Initialize the DragLog client.
         
         Args:
             base_url: Base URL of the DragLog API server
             local: Whether to store records locally without making API requests
             log_file: Path to the log file for local mode
+            api_key: API key sent in the X-API-Key header
+            token: JWT sent as a bearer token
         """
         self.base_url = base_url.rstrip('/')
+        self.headers = {}
+        if api_key:
+            self.headers['X-API-Key'] = api_key
+        elif token:
+            self.headers['Authorization'] = f'Bearer {token}'
         self.local = local
         self.log_file = log_file
         self.reliability_history_path = reliability_history_path
@@ -82,7 +90,7 @@ class DragLogClient:
             
         try:
             url = f"{self.base_url}/{endpoint.lstrip('/')}"
-            response = requests.request(method, url, **kwargs)
+            response = requests.request(method, url, headers=self.headers, **kwargs)
             response.raise_for_status()
             
             # Return empty dict if response is empty
@@ -226,6 +234,38 @@ class DragLogClient:
         record_dict = {"dataSourceID": data_source_id, "digest": digest, "reserved": reserved}
         self._make_request('POST', '/create-reliability-record-async', json=record_dict)
     
+    def create_interaction(self, reranker_id: str, llm_id: str, sources: List[Dict[str, str]], reranker_output: str, llm_output: str, timestamp: str, feedback: Optional[Dict[str, str]] = None, interaction_id: str = "", user_id: str = "user") -> List[str]:
+        """Log a whole interaction in a single transaction.
+        
+        Args:
+            reranker_id: ID of the re-ranker
+            llm_id: ID of the LLM
+            sources: List of {"dataSourceID", "digest"} of the retrieved documents
+            reranker_output: Re-ranked content sent to the LLM
+            llm_output: Answer of the LLM
+            timestamp: Timestamp of the interaction
+            feedback: Optional {"evaluation", "reserved"} of the answer
+            interaction_id: Prefix of the log IDs, the transaction ID if empty
+            user_id: ID of the user
+            
+        Returns:
+            IDs of the written records
+        """
+        interaction = {
+            "interactionID": interaction_id,
+            "rerankerID": reranker_id,
+            "llmID": llm_id,
+            "userID": user_id,
+            "sources": sources,
+            "rerankerOutput": reranker_output,
+            "llmOutput": llm_output,
+            "timestamp": timestamp,
+        }
+        if feedback is not None:
+            interaction["feedback"] = feedback
+        response = self._make_request('POST', '/v1/interactions', json=interaction)
+        return response.get('logIDs', [])
+
     def get_all_log_records(self) -> List[LogRecord]:
         """Get all log records.
         
@@ -285,6 +325,36 @@ class DragLogClient:
         else:
             return self.get_reliability_record(data_source_id).reliabilityScore
 
+    def get_reliability_scores(self, data_source_ids: List[str]) -> Dict[str, Optional[float]]:
+        """Get the reliability scores of many data sources in a single call.
+        
+        Args:
+            data_source_ids: IDs of the data sources
+            
+        Returns:
+            Dictionary of data source ID to score, None for unknown data sources
+        """
+        if self.local:
+            return {data_source_id: self.reliability_scores.get(data_source_id) for data_source_id in data_source_ids}
+        response = self._make_request('POST', '/v1/sources/scores', json={"ids": data_source_ids})
+        scores = response.get('scores', {})
+        return {data_source_id: scores.get(data_source_id, {}).get('reliabilityScore') for data_source_id in data_source_ids}
+
+    def get_lineage(self, record_id_or_digest: str, direction: str = "both", depth: int = 5) -> Dict[str, Any]:
+        """Get the provenance graph of a record or an output digest.
+        
+        Args:
+            record_id_or_digest: ID of the record, or digest of an output
+            direction: upstream, downstream or both
+            depth: Maximum number of edges from the start
+            
+        Returns:
+            Dictionary with the start, nodes, edges and sources of the graph
+        """
+        if self.local:
+            return {"start": [], "nodes": [], "edges": [], "sources": [], "truncated": False}
+        return self._make_request('GET', f'/v1/records/{quote(record_id_or_digest, safe="")}/lineage', params={"direction": direction, "depth": depth})
+
     def update_reliability_record(self, data_source_id: str, reliability_score: float, is_delta: bool, info: str) -> None:
         """Update a reliability record's score.
         